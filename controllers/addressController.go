@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errAddressNotFound = errors.New("selected delivery address was not found")
+
+// formatAddress joins the parts of an address into a single delivery location line
+func formatAddress(address models.Address) string {
+	var parts []string
+	for _, part := range []string{address.Street, address.Landmark, address.Town, address.County} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// applyAddress copies a saved address of userID, the signed in user, onto the order. Guests
+// have no saved addresses.
+func applyAddress(db *gorm.DB, order *models.Order, userID, addressID int) (models.Address, error) {
+	var address models.Address
+	if userID == 0 {
+		return address, errAddressNotFound
+	}
+	if err := db.Where("user_id = ?", userID).First(&address, addressID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return address, errAddressNotFound
+		}
+		return address, err
+	}
+
+	order.FirstName = address.FirstName
+	order.LastName = address.LastName
+	order.Phone = address.Phone
+	order.DeliveryLocation = formatAddress(address)
+	return address, nil
+}
+
+// saveOrderAddress keeps a snapshot of the delivery address with the order
+func saveOrderAddress(db *gorm.DB, order *models.Order, address models.Address) error {
+	snapshot := models.OrderAddress{
+		OrderID:   int(order.ID),
+		AddressID: int(address.ID),
+		Label:     address.Label,
+		FirstName: address.FirstName,
+		LastName:  address.LastName,
+		Phone:     address.Phone,
+		County:    address.County,
+		Town:      address.Town,
+		Street:    address.Street,
+		Landmark:  address.Landmark,
+	}
+	if err := db.Create(&snapshot).Error; err != nil {
+		return err
+	}
+	order.DeliveryAddress = &snapshot
+	return nil
+}
+
+// findUserAddress loads the address named by the addressId path parameter for the caller
+func findUserAddress(ctx *gin.Context) (models.Address, bool) {
+	var address models.Address
+
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return address, false
+	}
+
+	addressId, err := strconv.Atoi(ctx.Param("addressId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid address ID", err)
+		return address, false
+	}
+
+	if err := initializers.DB.Where("user_id = ?", userID).First(&address, addressId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, "Address not found", nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Unable to retrieve address", err)
+		}
+		return address, false
+	}
+	return address, true
+}
+
+// setDefaultAddress makes an address the user's only default
+func setDefaultAddress(tx *gorm.DB, userID int, addressID uint) error {
+	if err := tx.Model(&models.Address{}).
+		Where("user_id = ? AND id <> ?", userID, addressID).
+		Update("is_default", false).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Address{}).Where("id = ?", addressID).Update("is_default", true).Error
+}
+
+func GetAddresses(ctx *gin.Context) {
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var addresses []models.Address
+	if result := initializers.DB.Where("user_id = ?", userID).
+		Order("is_default desc, created_at desc").
+		Find(&addresses); result.Error != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch addresses", result.Error)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"addresses": addresses})
+}
+
+func CreateAddress(ctx *gin.Context) {
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var address models.Address
+	if err := ctx.ShouldBindJSON(&address); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	address.ID = 0
+	address.UserID = userID
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		// The first address a user saves becomes their default
+		var count int64
+		if err := tx.Model(&models.Address{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			address.IsDefault = true
+		}
+
+		if err := tx.Create(&address).Error; err != nil {
+			return err
+		}
+		if address.IsDefault {
+			return setDefaultAddress(tx, userID, address.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create address", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, address)
+}
+
+func UpdateAddress(ctx *gin.Context) {
+	address, ok := findUserAddress(ctx)
+	if !ok {
+		return
+	}
+
+	var updateData models.Address
+	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	// Orders keep their own copy of the address, so editing it doesn't change past orders
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&address).
+			Select("Label", "FirstName", "LastName", "Phone", "County", "Town", "Street", "Landmark").
+			Updates(updateData).Error; err != nil {
+			return err
+		}
+		if updateData.IsDefault {
+			return setDefaultAddress(tx, address.UserID, address.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Failed to update address:", err)
+		sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to update address")
+		return
+	}
+
+	initializers.DB.First(&address, address.ID)
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message": "Address updated successfully",
+		"address": address,
+	})
+}
+
+func SetDefaultAddress(ctx *gin.Context) {
+	address, ok := findUserAddress(ctx)
+	if !ok {
+		return
+	}
+
+	if err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		return setDefaultAddress(tx, address.UserID, address.ID)
+	}); err != nil {
+		log.Println("Failed to set default address:", err)
+		sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to set default address")
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Default address updated successfully."})
+}
+
+func DeleteAddress(ctx *gin.Context) {
+	address, ok := findUserAddress(ctx)
+	if !ok {
+		return
+	}
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&address).Error; err != nil {
+			return err
+		}
+		if !address.IsDefault {
+			return nil
+		}
+
+		// Promote the most recent remaining address to default
+		var next models.Address
+		result := tx.Where("user_id = ?", address.UserID).Order("created_at desc").Limit(1).Find(&next)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return setDefaultAddress(tx, address.UserID, next.ID)
+	})
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to delete address.")
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Address deleted successfully."})
+}
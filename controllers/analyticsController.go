@@ -0,0 +1,461 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	paymentStatusCompleted = "Completed"
+
+	defaultAnalyticsDays     = 30
+	defaultAnalyticsTopLimit = 10
+	maxAnalyticsTopLimit     = 100
+)
+
+// eastAfricaTime is the shop's time zone. Kenya has no daylight saving time, so a fixed
+// offset is used instead of relying on the host having time zone data.
+var eastAfricaTime = time.FixedZone("EAT", 3*60*60)
+
+// analyticsLocalTime converts order times from the database's location (the DSN's loc) to East
+// Africa Time in SQL. The location's current offset is used, which is exact for locations
+// without daylight saving time such as UTC and Africa/Nairobi.
+func analyticsLocalTime() string {
+	offset := time.Now().In(initializers.DBLocation).Format("-07:00")
+	return "CONVERT_TZ(orders.created_at, '" + offset + "', '+03:00')"
+}
+
+// Sales can be grouped by day, by week (starting on Monday) or by month. Each interval has the
+// SQL that labels an order with the first day of its period, given its local time, and the Go
+// step to the next period.
+var analyticsIntervals = map[string]struct {
+	Period func(local string) string
+	Next   func(time.Time) time.Time
+}{
+	"day": {
+		func(local string) string { return "DATE_FORMAT(" + local + ", '%Y-%m-%d')" },
+		func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	},
+	"week": {
+		func(local string) string {
+			return "DATE_FORMAT(DATE_SUB(" + local + ", INTERVAL WEEKDAY(" + local + ") DAY), '%Y-%m-%d')"
+		},
+		func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	},
+	"month": {
+		func(local string) string { return "DATE_FORMAT(" + local + ", '%Y-%m-01')" },
+		func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	},
+}
+
+// analyticsRange is the reporting period, from its first moment up to but excluding to
+type analyticsRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// parseAnalyticsRange reads the from and to dates (inclusive, in East Africa Time). The
+// default is the last 30 days including today.
+func parseAnalyticsRange(ctx *gin.Context) (analyticsRange, error) {
+	now := time.Now().In(eastAfricaTime)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, eastAfricaTime)
+	period := analyticsRange{From: today.AddDate(0, 0, 1-defaultAnalyticsDays), To: today.AddDate(0, 0, 1)}
+
+	if from := ctx.Query("from"); from != "" {
+		date, err := parseOrderDate(from, false)
+		if err != nil {
+			return period, fmt.Errorf("invalid from date %q", from)
+		}
+		period.From = date
+	}
+	if to := ctx.Query("to"); to != "" {
+		date, err := parseOrderDate(to, true)
+		if err != nil {
+			return period, fmt.Errorf("invalid to date %q", to)
+		}
+		period.To = date
+	}
+	if !period.From.Before(period.To) {
+		return period, fmt.Errorf("from must be before to")
+	}
+	return period, nil
+}
+
+// ordersIn limits an order query to orders placed in the period
+func (period analyticsRange) ordersIn(query *gorm.DB) *gorm.DB {
+	return query.Where("orders.created_at >= ? AND orders.created_at < ?", period.From, period.To)
+}
+
+func (period analyticsRange) metadata() gin.H {
+	return gin.H{
+		"from":     period.From.In(eastAfricaTime).Format(time.DateOnly),
+		"to":       period.To.In(eastAfricaTime).Add(-time.Nanosecond).Format(time.DateOnly),
+		"timeZone": "Africa/Nairobi",
+	}
+}
+
+// salesTotals are the order counts and revenue of a period. Revenue only counts paid orders,
+// Refunds are what was refunded on returns of those orders.
+type salesTotals struct {
+	Orders     int64        `json:"orders"`
+	PaidOrders int64        `json:"paidOrders"`
+	Revenue    models.Money `json:"revenue"`
+	Refunds    models.Money `json:"refunds"`
+}
+
+func (totals salesTotals) averageOrderValue() models.Money {
+	if totals.PaidOrders == 0 {
+		return 0
+	}
+	return totals.Revenue / models.Money(totals.PaidOrders)
+}
+
+// conversionRate is the share of orders placed that went on to be paid
+func (totals salesTotals) conversionRate() float64 {
+	return ratio(totals.PaidOrders, totals.Orders)
+}
+
+const salesTotalsSelect = "COUNT(*) AS orders, " +
+	"COALESCE(SUM(orders.payment_status = '" + paymentStatusCompleted + "'), 0) AS paid_orders, " +
+	"COALESCE(SUM(CASE WHEN orders.payment_status = '" + paymentStatusCompleted + "' THEN orders.total ELSE 0 END), 0) AS revenue, " +
+	"COALESCE(SUM((SELECT SUM(return_requests.refund_amount) FROM return_requests WHERE return_requests.order_id = orders.id" +
+	" AND return_requests.status = '" + models.ReturnStatusRefunded + "' AND return_requests.deleted_at IS NULL)), 0) AS refunds"
+
+// ratio divides part by whole, rounded to four decimal places, and is 0 when whole is 0
+func ratio(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	rate, _ := strconv.ParseFloat(strconv.FormatFloat(float64(part)/float64(whole), 'f', 4, 64), 64)
+	return rate
+}
+
+func salesSummary(totals salesTotals) gin.H {
+	return gin.H{
+		"orders":            totals.Orders,
+		"paidOrders":        totals.PaidOrders,
+		"revenue":           totals.Revenue,
+		"refunds":           totals.Refunds,
+		"netRevenue":        totals.Revenue - totals.Refunds,
+		"averageOrderValue": totals.averageOrderValue(),
+		"conversionRate":    totals.conversionRate(),
+	}
+}
+
+// GetSalesAnalytics returns order counts, revenue, refunds and average order value for each day, week
+// or month (interval) between from and to, along with the totals for the whole period.
+// Periods without orders are included so the series can be charted as is.
+func GetSalesAnalytics(ctx *gin.Context) {
+	intervalName := ctx.DefaultQuery("interval", "day")
+	interval, ok := analyticsIntervals[intervalName]
+	if !ok {
+		sendErrorResponse(ctx, http.StatusBadRequest, "interval must be day, week or month")
+		return
+	}
+	period, err := parseAnalyticsRange(ctx)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid date range", err)
+		return
+	}
+
+	var rows []struct {
+		Period     string
+		Orders     int64
+		PaidOrders int64
+		Revenue    models.Money
+		Refunds    models.Money
+	}
+	if err := period.ordersIn(initializers.DB.Model(&models.Order{})).
+		Select(interval.Period(analyticsLocalTime()) + " AS period, " + salesTotalsSelect).
+		Group("period").
+		Order("period").
+		Scan(&rows).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch sales", err)
+		return
+	}
+	byPeriod := make(map[string]salesTotals, len(rows))
+	for _, row := range rows {
+		byPeriod[row.Period] = salesTotals{Orders: row.Orders, PaidOrders: row.PaidOrders, Revenue: row.Revenue, Refunds: row.Refunds}
+	}
+
+	// The first period is the one holding from, which for weeks and months starts before it
+	var totals salesTotals
+	series := []gin.H{}
+	start := period.From.In(eastAfricaTime)
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, eastAfricaTime)
+	switch intervalName {
+	case "week":
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	case "month":
+		start = start.AddDate(0, 0, 1-start.Day())
+	}
+	for ; start.Before(period.To); start = interval.Next(start) {
+		label := start.Format(time.DateOnly)
+		row := byPeriod[label]
+		totals.Orders += row.Orders
+		totals.PaidOrders += row.PaidOrders
+		totals.Revenue += row.Revenue
+		totals.Refunds += row.Refunds
+
+		point := salesSummary(row)
+		point["period"] = label
+		series = append(series, point)
+	}
+
+	metadata := period.metadata()
+	metadata["interval"] = intervalName
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"sales":    series,
+		"totals":   salesSummary(totals),
+		"metadata": metadata,
+	})
+}
+
+// topSellersQuery sums the units and revenue of the items of orders paid in the period.
+// Revenue is the item price times quantity less the item discount.
+func topSellersQuery(ctx *gin.Context, period analyticsRange) (*gorm.DB, int, error) {
+	order := ctx.DefaultQuery("by", "revenue")
+	if order != "revenue" && order != "units" {
+		return nil, 0, fmt.Errorf("by must be revenue or units")
+	}
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultAnalyticsTopLimit)))
+	if limit < 1 {
+		limit = defaultAnalyticsTopLimit
+	}
+	if limit > maxAnalyticsTopLimit {
+		limit = maxAnalyticsTopLimit
+	}
+
+	query := initializers.DB.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL").
+		Where("orders.payment_status = ?", paymentStatusCompleted).
+		Order(order + " DESC").
+		Limit(limit)
+	return period.ordersIn(query), limit, nil
+}
+
+const topSellersSelect = "COALESCE(SUM(order_items.quantity), 0) AS units, " +
+	"COALESCE(SUM(order_items.price * order_items.quantity - order_items.discount), 0) AS revenue, " +
+	"COUNT(DISTINCT order_items.order_id) AS orders"
+
+// GetTopProducts lists the best selling products between from and to, by revenue or units (by)
+func GetTopProducts(ctx *gin.Context) {
+	period, err := parseAnalyticsRange(ctx)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid date range", err)
+		return
+	}
+	query, limit, err := topSellersQuery(ctx, period)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid query", err)
+		return
+	}
+
+	products := []struct {
+		ProductID int          `json:"productId"`
+		Name      string       `json:"name"`
+		Units     int64        `json:"units"`
+		Revenue   models.Money `json:"revenue"`
+		Orders    int64        `json:"orders"`
+	}{}
+	if err := query.
+		Select("order_items.product_id, MAX(order_items.name) AS name, " + topSellersSelect).
+		Group("order_items.product_id").
+		Scan(&products).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch top products", err)
+		return
+	}
+
+	metadata := period.metadata()
+	metadata["limit"] = limit
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"products": products, "metadata": metadata})
+}
+
+// GetTopCategories lists the best selling product categories between from and to, by revenue
+// or units (by). Items of products that have since been deleted keep their category.
+func GetTopCategories(ctx *gin.Context) {
+	period, err := parseAnalyticsRange(ctx)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid date range", err)
+		return
+	}
+	query, limit, err := topSellersQuery(ctx, period)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid query", err)
+		return
+	}
+
+	categories := []struct {
+		Category string       `json:"category"`
+		Units    int64        `json:"units"`
+		Revenue  models.Money `json:"revenue"`
+		Orders   int64        `json:"orders"`
+	}{}
+	if err := query.
+		Joins("LEFT JOIN products ON products.id = order_items.product_id").
+		Select("COALESCE(products.category, '') AS category, " + topSellersSelect).
+		Group("COALESCE(products.category, '')").
+		Scan(&categories).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch top categories", err)
+		return
+	}
+
+	metadata := period.metadata()
+	metadata["limit"] = limit
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"categories": categories, "metadata": metadata})
+}
+
+// paymentAnalytics counts the orders of the period by payment status. The success rate is the
+// share of completed payments among orders whose payment has an outcome, so orders still
+// waiting for the customer to pay are left out.
+func paymentAnalytics(period analyticsRange) (gin.H, error) {
+	var rows []struct {
+		PaymentStatus string
+		Count         int64
+	}
+	if err := period.ordersIn(initializers.DB.Model(&models.Order{})).
+		Select("orders.payment_status, COUNT(*) AS count").
+		Group("orders.payment_status").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	statuses := gin.H{}
+	var completed, attempted int64
+	for _, row := range rows {
+		status := row.PaymentStatus
+		if status == "" {
+			status = "Pending"
+		}
+		if count, ok := statuses[status].(int64); ok {
+			row.Count += count
+		}
+		statuses[status] = row.Count
+		if status != "Pending" {
+			attempted += row.Count
+		}
+		if status == paymentStatusCompleted {
+			completed += row.Count
+		}
+	}
+
+	return gin.H{
+		"statuses":    statuses,
+		"attempted":   attempted,
+		"completed":   completed,
+		"successRate": ratio(completed, attempted),
+	}, nil
+}
+
+// customerAnalytics splits the customers who paid for an order in the period into new ones,
+// whose first paid order falls in the period, and returning ones who had paid before it.
+// Signed in customers are told apart by account and guests by email address.
+func customerAnalytics(period analyticsRange) (gin.H, error) {
+	var counts struct {
+		Customers          int64
+		NewCustomers       int64
+		ReturningCustomers int64
+		NewRevenue         models.Money
+		ReturningRevenue   models.Money
+	}
+	customers := initializers.DB.Model(&models.Order{}).
+		Select("CASE WHEN orders.user_id IS NOT NULL THEN CONCAT('user:', orders.user_id) ELSE CONCAT('email:', LOWER(orders.email)) END AS customer, "+
+			"MIN(orders.created_at) AS first_paid_at, "+
+			"MAX(orders.created_at) AS last_paid_at, "+
+			"SUM(CASE WHEN orders.created_at >= ? THEN orders.total ELSE 0 END) AS revenue", period.From).
+		Where("orders.payment_status = ? AND orders.created_at < ?", paymentStatusCompleted, period.To).
+		Group("customer").
+		Having("last_paid_at >= ?", period.From)
+
+	if err := initializers.DB.Table("(?) AS customers", customers).
+		Select("COUNT(*) AS customers, "+
+			"COALESCE(SUM(first_paid_at >= ?), 0) AS new_customers, "+
+			"COALESCE(SUM(CASE WHEN first_paid_at >= ? THEN revenue ELSE 0 END), 0) AS new_revenue, "+
+			"COALESCE(SUM(CASE WHEN first_paid_at < ? THEN revenue ELSE 0 END), 0) AS returning_revenue", period.From, period.From, period.From).
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	counts.ReturningCustomers = counts.Customers - counts.NewCustomers
+
+	return gin.H{
+		"customers":          counts.Customers,
+		"newCustomers":       counts.NewCustomers,
+		"returningCustomers": counts.ReturningCustomers,
+		"newRevenue":         counts.NewRevenue,
+		"returningRevenue":   counts.ReturningRevenue,
+		"returningRate":      ratio(counts.ReturningCustomers, counts.Customers),
+	}, nil
+}
+
+// GetPaymentAnalytics returns the orders placed between from and to by payment status and
+// the payment success rate
+func GetPaymentAnalytics(ctx *gin.Context) {
+	period, err := parseAnalyticsRange(ctx)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid date range", err)
+		return
+	}
+	payments, err := paymentAnalytics(period)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch payments", err)
+		return
+	}
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"payments": payments, "metadata": period.metadata()})
+}
+
+// GetCustomerAnalytics returns the new and returning customers between from and to
+func GetCustomerAnalytics(ctx *gin.Context) {
+	period, err := parseAnalyticsRange(ctx)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid date range", err)
+		return
+	}
+	customers, err := customerAnalytics(period)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch customers", err)
+		return
+	}
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"customers": customers, "metadata": period.metadata()})
+}
+
+// GetAnalyticsSummary returns the headline dashboard figures between from and to: sales,
+// average order value, conversion from order placed to paid, payments and customers
+func GetAnalyticsSummary(ctx *gin.Context) {
+	period, err := parseAnalyticsRange(ctx)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid date range", err)
+		return
+	}
+
+	var totals salesTotals
+	if err := period.ordersIn(initializers.DB.Model(&models.Order{})).
+		Select(salesTotalsSelect).
+		Scan(&totals).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch sales", err)
+		return
+	}
+	payments, err := paymentAnalytics(period)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch payments", err)
+		return
+	}
+	customers, err := customerAnalytics(period)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch customers", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"sales":     salesSummary(totals),
+		"payments":  payments,
+		"customers": customers,
+		"metadata":  period.metadata(),
+	})
+}
@@ -13,31 +13,26 @@ import (
 	"github.com/Kariqs/amexan-api/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 const (
-	// Default cost for bcrypt password hashing
-	bcryptCost = 10
-
 	// Standard response messages
-	msgInvalidInput           = "invalid input"
-	msgUserAlreadyExists      = "user already exists"
-	msgFailedToHashPassword   = "failed to hash password"
-	msgInvalidCredentials     = "invalid username or password"
-	msgAccountNotActivated    = "Account not activated, check your email to activate email."
-	msgFailedToGenerateToken  = "failed to generate token"
-	msgInternalServerError    = "Internal server error"
-	msgInvalidActivationLink  = "Invalid or expired activation link"
-	msgActivationSuccess      = "account has been activated successfully."
-	msgResetLinkSent          = "Check your email for a password reset link."
-	msgUserCreated            = "User created successfully. Check your email to activate your account."
-	msgUserNotFound           = "user with this email does not exist"
-	msgResetTokenError        = "There was an error trying to generate password reset link. Try again later."
-	msgUnableToSaveToken      = "unable to save reset token."
-	msgUnableToResetPassword  = "unable to reset password"
-	msgFailedToCreateCart     = "failed to create cart"
-	msgFailedToCreateCartItem = "failed to create cart item"
+	msgInvalidInput          = "invalid input"
+	msgUserAlreadyExists     = "user already exists"
+	msgFailedToHashPassword  = "failed to hash password"
+	msgInvalidCredentials    = "invalid username or password"
+	msgAccountNotActivated   = "Account not activated, check your email to activate email."
+	msgFailedToGenerateToken = "failed to generate token"
+	msgInternalServerError   = "Internal server error"
+	msgInvalidActivationLink = "Invalid or expired activation link"
+	msgActivationSuccess     = "account has been activated successfully."
+	msgResetLinkSent         = "Check your email for a password reset link."
+	msgUserCreated           = "User created successfully. Check your email to activate your account."
+	msgUserNotFound          = "user with this email does not exist"
+	msgResetTokenError       = "There was an error trying to generate password reset link. Try again later."
+	msgUnableToSaveToken     = "unable to save reset token."
+	msgUnableToResetPassword = "unable to reset password"
 )
 
 func sendJSONResponse(ctx *gin.Context, status int, data gin.H) {
@@ -49,15 +44,28 @@ func sendErrorResponse(ctx *gin.Context, status int, message string) {
 }
 
 func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	return utils.HashPassword(password)
+}
+
+// comparePasswords verifies a password against its stored hash and reports
+// whether the hash uses a legacy algorithm or outdated parameters.
+func comparePasswords(hashedPassword, password string) (bool, error) {
+	return utils.VerifyPassword(hashedPassword, password)
+}
+
+// rehashPassword replaces a user's stored hash after a successful login
+func rehashPassword(user models.User, password string) {
+	hashedPassword, err := hashPassword(password)
 	if err != nil {
-		return "", err
+		log.Println("Password rehash error:", err)
+		return
 	}
-	return string(bytes), nil
-}
 
-func comparePasswords(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	if result := initializers.DB.Model(&models.User{}).
+		Where("id = ?", user.ID).
+		Update("password", hashedPassword); result.Error != nil {
+		log.Println("Error saving rehashed password:", result.Error)
+	}
 }
 
 func generateJWT(user models.User) (string, error) {
@@ -74,6 +82,40 @@ func generateJWT(user models.User) (string, error) {
 	return token.SignedString([]byte(jwtSecret))
 }
 
+// getAuthenticatedUserID returns the id of the user whose claims were saved by the auth middleware
+func getAuthenticatedUserID(ctx *gin.Context) (int, bool) {
+	userClaims, exists := ctx.Get("user")
+	if !exists {
+		return 0, false
+	}
+
+	claims, ok := userClaims.(jwt.MapClaims)
+	if !ok {
+		return 0, false
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, false
+	}
+	return int(userID), true
+}
+
+func isAdminUser(ctx *gin.Context) bool {
+	userClaims, exists := ctx.Get("user")
+	if !exists {
+		return false
+	}
+
+	claims, ok := userClaims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+
+	role, _ := claims["role"].(string)
+	return role == "admin"
+}
+
 func checkUserExists(email, username string) (bool, error) {
 	var existingUser models.User
 	result := initializers.DB.Where("email = ? OR username = ?", email, username).Find(&existingUser)
@@ -92,8 +134,8 @@ func findUserByEmail(email string) (models.User, error) {
 	return user, result.Error
 }
 
-// Send an account verification email
-func sendAccountVerificationEmail(user models.User, activationToken string) error {
+// Queue an account verification email
+func queueAccountVerificationEmail(tx *gorm.DB, user models.User, activationToken string) error {
 	emailData := utils.EmailData{
 		Name:            user.Username,
 		Message:         "Thank you for signing up! Click the button below to verify your account.",
@@ -102,11 +144,11 @@ func sendAccountVerificationEmail(user models.User, activationToken string) erro
 	}
 
 	templatePath := filepath.Join("templates", "verify_email.html")
-	return utils.SendEmail(user.Email, "Account Verification", emailData, templatePath)
+	return queueTemplatedEmail(tx, user.Email, "Account Verification", emailData, templatePath)
 }
 
-// Send a password reset email
-func sendPasswordResetEmail(user models.User, resetToken string) error {
+// Queue a password reset email
+func queuePasswordResetEmail(tx *gorm.DB, user models.User, resetToken string) error {
 	emailData := utils.EmailData{
 		Name:            user.Username,
 		Message:         "You requested a password reset. Click the button below to reset your password.",
@@ -115,7 +157,7 @@ func sendPasswordResetEmail(user models.User, resetToken string) error {
 	}
 
 	templatePath := filepath.Join("templates", "reset_password.html")
-	return utils.SendEmail(user.Email, "Amexan Account Password Reset", emailData, templatePath)
+	return queueTemplatedEmail(tx, user.Email, "Amexan Account Password Reset", emailData, templatePath)
 }
 
 // Signup handles user registration
@@ -137,6 +179,11 @@ func Signup(ctx *gin.Context) {
 		return
 	}
 
+	if err := utils.ValidatePassword(signUpData.Password); err != nil {
+		sendErrorResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Hash the password
 	hashedPassword, err := hashPassword(signUpData.Password)
 	if err != nil {
@@ -161,21 +208,24 @@ func Signup(ctx *gin.Context) {
 	signUpData.AccountActivationToken = activationToken
 	signUpData.AccountActivated = false
 
-	// Create the user and their cart in the database
-	if result := initializers.DB.Create(&signUpData); result.Error != nil {
-		log.Println("User creation error:", result.Error)
+	// Create the user and queue their verification email together
+	if err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&signUpData).Error; err != nil {
+			return err
+		}
+		// The subscription is confirmed when the account is activated
+		if signUpData.SubscribeToNews {
+			if _, err := subscribeToNewsletter(tx, signUpData.Email, signUpData.Fullname, int(signUpData.ID), "signup"); err != nil {
+				return err
+			}
+		}
+		return queueAccountVerificationEmail(tx, signUpData, activationToken)
+	}); err != nil {
+		log.Println("User creation error:", err)
 		sendErrorResponse(ctx, http.StatusInternalServerError, msgInternalServerError)
 		return
 	}
 
-	// Send email to the user
-	if err := sendAccountVerificationEmail(signUpData, activationToken); err != nil {
-		log.Println("Error sending verification email:", err)
-		// Continue despite email error, but log it
-	} else {
-		log.Println("Verification email sent successfully to:", signUpData.Email)
-	}
-
 	sendJSONResponse(ctx, http.StatusCreated, gin.H{"message": msgUserCreated})
 }
 
@@ -195,7 +245,8 @@ func Login(ctx *gin.Context) {
 	}
 
 	// Check if the password is correct
-	if err := comparePasswords(user.Password, loginData.Password); err != nil {
+	needsRehash, err := comparePasswords(user.Password, loginData.Password)
+	if err != nil {
 		sendErrorResponse(ctx, http.StatusBadRequest, msgInvalidCredentials)
 		return
 	}
@@ -206,6 +257,18 @@ func Login(ctx *gin.Context) {
 		return
 	}
 
+	// Upgrade legacy bcrypt hashes to Argon2id transparently
+	if needsRehash {
+		rehashPassword(user, loginData.Password)
+	}
+
+	// Move any items added while browsing as a guest into the user's cart
+	if cartToken := ctx.GetHeader(cartTokenHeader); cartToken != "" {
+		if err := mergeGuestCart(int(user.ID), cartToken); err != nil {
+			log.Println("Error merging guest cart:", err)
+		}
+	}
+
 	// Generate a JWT token
 	tokenString, err := generateJWT(user)
 	if err != nil {
@@ -221,6 +284,13 @@ func Login(ctx *gin.Context) {
 func ActivateAccount(ctx *gin.Context) {
 	activationToken := ctx.Param("activationToken")
 
+	var user models.User
+	if result := initializers.DB.Where("account_activation_token = ?", activationToken).Limit(1).Find(&user); result.Error != nil {
+		log.Println("Account activation error:", result.Error)
+		sendErrorResponse(ctx, http.StatusInternalServerError, msgInternalServerError)
+		return
+	}
+
 	result := initializers.DB.Model(&models.User{}).
 		Where("account_activation_token = ?", activationToken).
 		Updates(map[string]any{
@@ -239,6 +309,14 @@ func ActivateAccount(ctx *gin.Context) {
 		return
 	}
 
+	// The email is now verified, so orders placed with it as a guest belong to this user
+	if _, err := claimGuestOrders(int(user.ID), user.Email); err != nil {
+		log.Println("Error claiming guest orders:", err)
+	}
+	if err := confirmSignupSubscriber(user); err != nil {
+		log.Println("Error confirming newsletter subscription:", err)
+	}
+
 	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": msgActivationSuccess})
 }
 
@@ -271,23 +349,20 @@ func SendPasswordResetLink(ctx *gin.Context) {
 		return
 	}
 
-	// Save the reset token to db
-	if result := initializers.DB.Model(&models.User{}).
-		Where("email = ?", forgotPasswordData.Email).
-		Update("password_reset_token", passwordResetToken); result.Error != nil {
-
-		log.Println("Error saving reset token:", result.Error)
+	// Save the reset token to db and queue the email with it
+	if err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).
+			Where("email = ?", forgotPasswordData.Email).
+			Update("password_reset_token", passwordResetToken).Error; err != nil {
+			return err
+		}
+		return queuePasswordResetEmail(tx, user, passwordResetToken)
+	}); err != nil {
+		log.Println("Error saving reset token:", err)
 		sendErrorResponse(ctx, http.StatusInternalServerError, msgUnableToSaveToken)
 		return
 	}
 
-	// Send email to the user
-	if err := sendPasswordResetEmail(user, passwordResetToken); err != nil {
-		log.Println("Error sending password reset email:", err)
-	} else {
-		log.Println("Password reset email sent successfully to:", forgotPasswordData.Email)
-	}
-
 	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": msgResetLinkSent})
 }
 
@@ -304,6 +379,11 @@ func ResetPassword(ctx *gin.Context) {
 		return
 	}
 
+	if err := utils.ValidatePassword(resetPasswordData.Password); err != nil {
+		sendErrorResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Hash the new password
 	hashedPassword, err := hashPassword(resetPasswordData.Password)
 	if err != nil {
@@ -0,0 +1,405 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const msgBrandNotFound = "Brand not found"
+
+var errUnknownBrand = errors.New("unknown brand, create it first")
+
+// brandsWithProductCount selects brands with the number of products each one has
+func brandsWithProductCount(db *gorm.DB) *gorm.DB {
+	return db.Model(&models.Brand{}).Select("brands.*, " +
+		"(SELECT COUNT(*) FROM products WHERE products.brand_id = brands.id AND products.deleted_at IS NULL) AS product_count")
+}
+
+// findBrandByRef finds a brand by id or slug
+func findBrandByRef(db *gorm.DB, ref string) (models.Brand, error) {
+	var brand models.Brand
+	query := brandsWithProductCount(db)
+	if id, err := strconv.Atoi(ref); err == nil {
+		query = query.Where("brands.id = ?", id)
+	} else {
+		query = query.Where("brands.slug = ?", ref)
+	}
+	err := query.Take(&brand).Error
+	return brand, err
+}
+
+// resolveProductBrand links a product to its brand, chosen by brandId or by the name in brand.
+// Names are matched ignoring case and punctuation and the product keeps the brand's own spelling.
+func resolveProductBrand(db *gorm.DB, product *models.Product) error {
+	var brand models.Brand
+	if product.BrandID != nil {
+		if err := db.First(&brand, *product.BrandID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errUnknownBrand
+			}
+			return err
+		}
+	} else {
+		name := strings.TrimSpace(product.Brand)
+		if err := db.Where("name = ? OR slug = ?", name, utils.Slugify(name)).Limit(1).Find(&brand).Error; err != nil {
+			return err
+		}
+		if brand.ID == 0 {
+			return errUnknownBrand
+		}
+	}
+
+	brandID := int(brand.ID)
+	product.BrandID = &brandID
+	product.Brand = brand.Name
+	return nil
+}
+
+// sendBrandError responds to a product whose brand couldn't be resolved
+func sendBrandError(ctx *gin.Context, err error) {
+	if errors.Is(err, errUnknownBrand) {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid brand", err)
+		return
+	}
+	respondWithError(ctx, http.StatusInternalServerError, "Failed to validate brand", err)
+}
+
+// checkBrandSlug sets a brand's slug from its name unless one is given, and makes sure no
+// other brand uses it
+func checkBrandSlug(db *gorm.DB, brand *models.Brand) error {
+	if brand.Slug == "" {
+		brand.Slug = brand.Name
+	}
+	brand.Slug = utils.Slugify(brand.Slug)
+	if brand.Slug == "" {
+		return errors.New("the name needs letters or digits to make a slug")
+	}
+
+	var count int64
+	if err := db.Model(&models.Brand{}).Where("slug = ? AND id != ?", brand.Slug, brand.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("brand %s already exists", brand.Slug)
+	}
+	return nil
+}
+
+// renameBrandReferences updates the copies of a brand's name kept on its products
+func renameBrandReferences(tx *gorm.DB, brandID uint, newName string) error {
+	return tx.Unscoped().Model(&models.Product{}).Where("brand_id = ?", brandID).Update("brand", newName).Error
+}
+
+// mergeBrandReferences points coupons limited to a brand at the one it is merged into
+func mergeBrandReferences(tx *gorm.DB, brand, target models.Brand) error {
+	var coupons []models.Coupon
+	if err := tx.Where("JSON_CONTAINS(brand_ids, ?)", strconv.Itoa(int(brand.ID))).Find(&coupons).Error; err != nil {
+		return err
+	}
+	for _, coupon := range coupons {
+		brandIDs := slices.DeleteFunc(coupon.BrandIDs, func(id uint) bool {
+			return id == brand.ID || id == target.ID
+		})
+		brandIDs = append(brandIDs, target.ID)
+		if err := tx.Model(&coupon).Update("brand_ids", brandIDs).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findBrand(ctx *gin.Context) (models.Brand, bool) {
+	var brand models.Brand
+
+	brandId, err := strconv.Atoi(ctx.Param("brandId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid brand ID", err)
+		return brand, false
+	}
+	if err := initializers.DB.First(&brand, brandId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendErrorResponse(ctx, http.StatusNotFound, msgBrandNotFound)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch brand", err)
+		}
+		return brand, false
+	}
+	return brand, true
+}
+
+// GetBrands lists brands by name with their product counts. Brands without products are
+// left out unless all=true.
+func GetBrands(ctx *gin.Context) {
+	query := brandsWithProductCount(initializers.DB).Order("brands.name asc")
+	if search := ctx.Query("search"); search != "" {
+		query = query.Where("brands.name LIKE ?", "%"+search+"%")
+	}
+	if ctx.Query("all") != "true" {
+		query = query.Having("product_count > 0")
+	}
+
+	var brands []models.Brand
+	if err := query.Find(&brands).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch brands", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"brands": brands})
+}
+
+// GetBrand returns a brand by id or slug with a page of its products, for brand landing pages
+func GetBrand(ctx *gin.Context) {
+	brand, err := findBrandByRef(initializers.DB, ctx.Param("brandId"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendErrorResponse(ctx, http.StatusNotFound, msgBrandNotFound)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch brand", err)
+		}
+		return
+	}
+
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "12"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 12
+	}
+
+	var products []models.Product
+	if err := initializers.DB.Preload("Images").Preload("Variants").
+		Where("brand_id = ?", brand.ID).
+		Order("created_at desc").
+		Limit(limit).Offset((page - 1) * limit).
+		Find(&products).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch products", err)
+		return
+	}
+
+	totalPages := int(math.Ceil(float64(brand.ProductCount) / float64(limit)))
+	previousPage := page - 1
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"brand":    brand,
+		"products": products,
+		"metadata": gin.H{
+			"total":        brand.ProductCount,
+			"currentPage":  page,
+			"limit":        limit,
+			"hasPrevPage":  previousPage > 0,
+			"hasNextPage":  page < totalPages,
+			"previousPage": previousPage,
+			"nextPage":     page + 1,
+		},
+	})
+}
+
+// CreateBrand adds a brand. The slug is made from the name unless one is given.
+func CreateBrand(ctx *gin.Context) {
+	var brand models.Brand
+	if err := ctx.ShouldBindJSON(&brand); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	brand.ID = 0
+	brand.Name = strings.TrimSpace(brand.Name)
+
+	if err := checkBrandSlug(initializers.DB, &brand); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid brand", err)
+		return
+	}
+
+	if err := initializers.DB.Create(&brand).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create brand", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, brand)
+}
+
+// UpdateBrand replaces a brand's details. Renaming it renames the brand of its products.
+func UpdateBrand(ctx *gin.Context) {
+	brand, ok := findBrand(ctx)
+	if !ok {
+		return
+	}
+	var updateData models.Brand
+	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	updateData.ID = brand.ID
+	updateData.Name = strings.TrimSpace(updateData.Name)
+	if updateData.Slug == "" {
+		updateData.Slug = brand.Slug
+	}
+	if err := checkBrandSlug(initializers.DB, &updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid brand", err)
+		return
+	}
+
+	oldName := brand.Name
+	brand.Name = updateData.Name
+	brand.Slug = updateData.Slug
+	brand.Description = updateData.Description
+	brand.LogoURL = updateData.LogoURL
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&brand).
+			Select("name", "slug", "description", "logo_url").
+			Updates(&brand).Error; err != nil {
+			return err
+		}
+		if brand.Name == oldName {
+			return nil
+		}
+		return renameBrandReferences(tx, brand.ID, brand.Name)
+	})
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to update brand", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Brand updated successfully", "brand": brand})
+}
+
+// DeleteBrand removes a brand without products. It is removed for good so its slug can be
+// used again.
+func DeleteBrand(ctx *gin.Context) {
+	brand, ok := findBrand(ctx)
+	if !ok {
+		return
+	}
+
+	var count int64
+	if err := initializers.DB.Model(&models.Product{}).Where("brand_id = ?", brand.ID).Count(&count).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to delete brand", err)
+		return
+	}
+	if count > 0 {
+		sendErrorResponse(ctx, http.StatusConflict, "Move its products to another brand first, or merge it into one")
+		return
+	}
+	if err := initializers.DB.Model(&models.Coupon{}).Where("JSON_CONTAINS(brand_ids, ?)", strconv.Itoa(int(brand.ID))).Count(&count).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to delete brand", err)
+		return
+	}
+	if count > 0 {
+		sendErrorResponse(ctx, http.StatusConflict, "Remove it from the coupons limited to it first, or merge it into another brand")
+		return
+	}
+
+	if err := initializers.DB.Unscoped().Delete(&brand).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to delete brand", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Brand deleted successfully"})
+}
+
+// MergeBrand moves the products and coupon restrictions of a brand to another one (intoId)
+// and deletes it, for duplicates the migration couldn't tell apart like "HP" and "Hewlett-Packard"
+func MergeBrand(ctx *gin.Context) {
+	brand, ok := findBrand(ctx)
+	if !ok {
+		return
+	}
+	var body struct {
+		IntoID int `json:"intoId" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if uint(body.IntoID) == brand.ID {
+		sendErrorResponse(ctx, http.StatusBadRequest, "A brand can't be merged into itself")
+		return
+	}
+
+	var target models.Brand
+	if err := initializers.DB.First(&target, body.IntoID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendErrorResponse(ctx, http.StatusNotFound, msgBrandNotFound)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch brand", err)
+		}
+		return
+	}
+
+	var moved int64
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&models.Product{}).
+			Where("brand_id = ?", brand.ID).
+			Updates(map[string]any{"brand_id": target.ID, "brand": target.Name, "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		moved = result.RowsAffected
+
+		if err := mergeBrandReferences(tx, brand, target); err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&brand).Error
+	})
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to merge brand", err)
+		return
+	}
+	log.Printf("Brand %d merged into %d, %d products moved", brand.ID, target.ID, moved)
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message":       "Brand merged successfully",
+		"brand":         target,
+		"movedProducts": moved,
+	})
+}
+
+// UploadBrandLogo uploads the "logo" form file and sets it as the brand's logo
+func UploadBrandLogo(ctx *gin.Context) {
+	brand, ok := findBrand(ctx)
+	if !ok {
+		return
+	}
+	file, err := ctx.FormFile("logo")
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "No logo uploaded", err)
+		return
+	}
+	if !strings.HasPrefix(file.Header.Get("Content-Type"), "image/") {
+		sendErrorResponse(ctx, http.StatusBadRequest, "The file must be an image")
+		return
+	}
+
+	uploader, err := getAWSUploader()
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to configure AWS", err)
+		return
+	}
+	key := fmt.Sprintf("brands/%d-%s-%s", brand.ID, time.Now().Format("20060102150405"), file.Filename)
+	location, err := uploadPublicFile(uploader, file, key)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to upload logo", err)
+		return
+	}
+
+	if err := initializers.DB.Model(&brand).Update("logo_url", location).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to save logo", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Logo uploaded", "brand": brand})
+}
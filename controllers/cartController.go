@@ -0,0 +1,616 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// Guests identify their cart with this header; it is also sent on login to merge the cart
+	cartTokenHeader = "X-Cart-Token"
+
+	msgFailedToCreateCart     = "failed to create cart"
+	msgFailedToCreateCartItem = "failed to create cart item"
+	msgFailedToFetchCart      = "failed to fetch cart"
+	msgCartNotFound           = "cart not found"
+	msgCartItemNotFound       = "cart item not found"
+	msgCartEmpty              = "cart is empty"
+	msgCartChanged            = "Some items in your cart have changed. Review your cart before checking out."
+	msgInsufficientStock      = "not enough stock for the requested quantity"
+)
+
+// findCart returns the cart of the authenticated user, or the guest cart named by the
+// cart token header. When create is true a missing cart is created.
+func findCart(ctx *gin.Context, create bool) (models.Cart, bool, error) {
+	var cart models.Cart
+	query := initializers.DB.Preload("CartItems")
+
+	userID, authenticated := getAuthenticatedUserID(ctx)
+	cartToken := ctx.GetHeader(cartTokenHeader)
+
+	var result *gorm.DB
+	if authenticated {
+		result = query.Where("user_id = ?", userID).Limit(1).Find(&cart)
+	} else if cartToken != "" {
+		result = query.Where("user_id = 0 AND guest_token = ?", cartToken).Limit(1).Find(&cart)
+	}
+
+	if result != nil {
+		if result.Error != nil {
+			return cart, false, result.Error
+		}
+		if result.RowsAffected > 0 {
+			return cart, true, nil
+		}
+	}
+
+	if !create {
+		return cart, false, nil
+	}
+
+	if authenticated {
+		cart.UserID = userID
+	} else {
+		guestToken, err := utils.GenerateCode(16)
+		if err != nil {
+			return cart, false, err
+		}
+		cart.GuestToken = guestToken
+	}
+
+	if err := initializers.DB.Create(&cart).Error; err != nil {
+		return cart, false, err
+	}
+	return cart, true, nil
+}
+
+func findProductsByID(ids []int) (map[int]models.Product, error) {
+	products := make(map[int]models.Product)
+	if len(ids) == 0 {
+		return products, nil
+	}
+
+	var found []models.Product
+	if err := initializers.DB.Preload("Variants").Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for _, product := range found {
+		products[int(product.ID)] = product
+	}
+	return products, nil
+}
+
+// revalidateCart refreshes item names and prices from the current products and variants and
+// flags items that are no longer available or exceed the stock on hand.
+// It reports whether the cart can be checked out as it stands.
+func revalidateCart(cart *models.Cart) (models.Money, bool, error) {
+	productIDs := make([]int, 0, len(cart.CartItems))
+	for _, item := range cart.CartItems {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	products, err := findProductsByID(productIDs)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var subtotal models.Money
+	valid := true
+	for i := range cart.CartItems {
+		item := &cart.CartItems[i]
+
+		// Items whose variant was removed, or of products that have since gained variants,
+		// have to be chosen again
+		product, exists := products[item.ProductID]
+		if !exists {
+			item.Available = false
+			valid = false
+			continue
+		}
+		variant, err := findVariant(product, item.VariantID)
+		if err != nil {
+			item.Available = false
+			valid = false
+			continue
+		}
+		item.Available = true
+
+		price := unitPrice(product, variant)
+		sku, variantName := describeVariant(variant)
+		if price != item.Price || product.Name != item.Name || sku != item.SKU || variantName != item.VariantName {
+			if price != item.Price {
+				item.PriceChanged = true
+				item.PreviousPrice = item.Price
+				valid = false
+			}
+			item.Price = price
+			item.Name = product.Name
+			item.SKU = sku
+			item.VariantName = variantName
+			if err := initializers.DB.Model(item).Updates(map[string]any{
+				"price":        item.Price,
+				"name":         item.Name,
+				"sku":          item.SKU,
+				"variant_name": item.VariantName,
+			}).Error; err != nil {
+				return 0, false, err
+			}
+		}
+
+		if stock := unitStock(product, variant); stock != nil {
+			item.StockAvailable = stock
+			if *stock < item.Quantity {
+				valid = false
+			}
+		}
+
+		subtotal += item.Price.Mul(item.Quantity)
+	}
+
+	return subtotal, valid, nil
+}
+
+func sendCartResponse(ctx *gin.Context, status int, cart models.Cart) {
+	subtotal, valid, err := revalidateCart(&cart)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, msgFailedToFetchCart, err)
+		return
+	}
+
+	response := gin.H{
+		"cart":          cart,
+		"subtotal":      subtotal,
+		"readyCheckout": valid,
+	}
+	if cart.GuestToken != "" {
+		response["cartToken"] = cart.GuestToken
+	}
+	sendJSONResponse(ctx, status, response)
+}
+
+func touchCart(cart models.Cart) {
+	if err := initializers.DB.Model(&cart).Update("updated_at", time.Now()).Error; err != nil {
+		log.Println("Error updating cart timestamp:", err)
+	}
+}
+
+// checkStock verifies a product, or the chosen variant, can supply the requested quantity
+func checkStock(product models.Product, variant *models.ProductVariant, quantity int) bool {
+	stock := unitStock(product, variant)
+	return stock == nil || *stock >= quantity
+}
+
+func GetCart(ctx *gin.Context) {
+	cart, found, err := findCart(ctx, false)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, msgFailedToFetchCart, err)
+		return
+	}
+	if !found {
+		sendJSONResponse(ctx, http.StatusOK, gin.H{
+			"cart":          models.Cart{CartItems: []models.CartItem{}},
+			"subtotal":      models.Money(0),
+			"readyCheckout": false,
+		})
+		return
+	}
+
+	sendCartResponse(ctx, http.StatusOK, cart)
+}
+
+func AddCartItem(ctx *gin.Context) {
+	var itemData struct {
+		ProductID int `json:"productId" binding:"required"`
+		VariantID int `json:"variantId"` // required for products with variants
+		Quantity  int `json:"quantity" binding:"required,min=1"`
+	}
+	if err := ctx.ShouldBindJSON(&itemData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	var product models.Product
+	if err := initializers.DB.Preload("Variants").First(&product, itemData.ProductID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, "Product not found", nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to validate product", err)
+		}
+		return
+	}
+	variant, err := findVariant(product, itemData.VariantID)
+	if err != nil {
+		sendErrorResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cart, _, err := findCart(ctx, true)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, msgFailedToCreateCart, err)
+		return
+	}
+
+	// Adding a product, or variant, already in the cart increases its quantity
+	for _, item := range cart.CartItems {
+		if item.ProductID != itemData.ProductID || item.VariantID != itemData.VariantID {
+			continue
+		}
+
+		quantity := item.Quantity + itemData.Quantity
+		if !checkStock(product, variant, quantity) {
+			sendErrorResponse(ctx, http.StatusConflict, msgInsufficientStock)
+			return
+		}
+		if err := initializers.DB.Model(&item).Update("quantity", quantity).Error; err != nil {
+			respondWithError(ctx, http.StatusInternalServerError, msgFailedToCreateCartItem, err)
+			return
+		}
+
+		touchCart(cart)
+		sendCartResponse(ctx, http.StatusOK, reloadCart(cart))
+		return
+	}
+
+	if !checkStock(product, variant, itemData.Quantity) {
+		sendErrorResponse(ctx, http.StatusConflict, msgInsufficientStock)
+		return
+	}
+
+	sku, variantName := describeVariant(variant)
+	cartItem := models.CartItem{
+		CartID:      int(cart.ID),
+		ProductID:   int(product.ID),
+		VariantID:   itemData.VariantID,
+		SKU:         sku,
+		Name:        product.Name,
+		VariantName: variantName,
+		Price:       unitPrice(product, variant),
+		Quantity:    itemData.Quantity,
+	}
+	if err := initializers.DB.Create(&cartItem).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, msgFailedToCreateCartItem, err)
+		return
+	}
+
+	touchCart(cart)
+	sendCartResponse(ctx, http.StatusCreated, reloadCart(cart))
+}
+
+// reloadCart fetches a cart and its items again after they were modified
+func reloadCart(cart models.Cart) models.Cart {
+	var reloaded models.Cart
+	if err := initializers.DB.Preload("CartItems").First(&reloaded, cart.ID).Error; err != nil {
+		log.Println("Error reloading cart:", err)
+		return cart
+	}
+	return reloaded
+}
+
+// findCartItem looks up an item by the itemId path parameter within the caller's cart
+func findCartItem(ctx *gin.Context) (models.Cart, models.CartItem, bool) {
+	var item models.CartItem
+
+	itemID, err := strconv.Atoi(ctx.Param("itemId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid cart item ID", err)
+		return models.Cart{}, item, false
+	}
+
+	cart, found, err := findCart(ctx, false)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, msgFailedToFetchCart, err)
+		return cart, item, false
+	}
+	if !found {
+		sendErrorResponse(ctx, http.StatusNotFound, msgCartNotFound)
+		return cart, item, false
+	}
+
+	for _, cartItem := range cart.CartItems {
+		if int(cartItem.ID) == itemID {
+			return cart, cartItem, true
+		}
+	}
+
+	sendErrorResponse(ctx, http.StatusNotFound, msgCartItemNotFound)
+	return cart, item, false
+}
+
+func UpdateCartItem(ctx *gin.Context) {
+	var itemData struct {
+		Quantity *int `json:"quantity" binding:"required,min=0"`
+	}
+	if err := ctx.ShouldBindJSON(&itemData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	cart, item, ok := findCartItem(ctx)
+	if !ok {
+		return
+	}
+
+	// A quantity of zero removes the item
+	if *itemData.Quantity == 0 {
+		if err := initializers.DB.Delete(&item).Error; err != nil {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to remove cart item", err)
+			return
+		}
+		touchCart(cart)
+		sendCartResponse(ctx, http.StatusOK, reloadCart(cart))
+		return
+	}
+
+	var product models.Product
+	if err := initializers.DB.Preload("Variants").First(&product, item.ProductID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, "Product not found", nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to validate product", err)
+		}
+		return
+	}
+	variant, err := findVariant(product, item.VariantID)
+	if err != nil {
+		sendErrorResponse(ctx, http.StatusConflict, err.Error())
+		return
+	}
+
+	if !checkStock(product, variant, *itemData.Quantity) {
+		sendErrorResponse(ctx, http.StatusConflict, msgInsufficientStock)
+		return
+	}
+
+	if err := initializers.DB.Model(&item).Update("quantity", *itemData.Quantity).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to update cart item", err)
+		return
+	}
+
+	touchCart(cart)
+	sendCartResponse(ctx, http.StatusOK, reloadCart(cart))
+}
+
+func RemoveCartItem(ctx *gin.Context) {
+	cart, item, ok := findCartItem(ctx)
+	if !ok {
+		return
+	}
+
+	if err := initializers.DB.Delete(&item).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to remove cart item", err)
+		return
+	}
+
+	touchCart(cart)
+	sendCartResponse(ctx, http.StatusOK, reloadCart(cart))
+}
+
+// CheckoutCart turns the caller's cart into an order and initiates payment.
+// Guests checking out their cart receive an order access token by email.
+func CheckoutCart(ctx *gin.Context) {
+	var checkoutData struct {
+		AddressID        int    `json:"addressId"`
+		FirstName        string `json:"firstName" binding:"required_without=AddressID"`
+		LastName         string `json:"lastName" binding:"required_without=AddressID"`
+		Email            string `json:"email" binding:"required,email"`
+		Phone            string `json:"phone" binding:"required_without=AddressID"`
+		DeliveryLocation string `json:"deliveryLocation" binding:"required_without=AddressID"`
+		DeliveryZoneID   int    `json:"deliveryZoneId"`
+		CouponCode       string `json:"couponCode"`
+	}
+	if err := ctx.ShouldBindJSON(&checkoutData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	// Guests have no user id and their order stays unclaimed until they sign up
+	var userID *int
+	if id, ok := getAuthenticatedUserID(ctx); ok {
+		userID = &id
+	}
+
+	cart, found, err := findCart(ctx, false)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, msgFailedToFetchCart, err)
+		return
+	}
+	if !found || len(cart.CartItems) == 0 {
+		sendErrorResponse(ctx, http.StatusBadRequest, msgCartEmpty)
+		return
+	}
+
+	subtotal, valid, err := revalidateCart(&cart)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, msgFailedToFetchCart, err)
+		return
+	}
+	if !valid {
+		sendJSONResponse(ctx, http.StatusConflict, gin.H{
+			"message":  msgCartChanged,
+			"cart":     cart,
+			"subtotal": subtotal,
+		})
+		return
+	}
+
+	orderInfo := models.Order{
+		UserID:           userID,
+		AddressID:        checkoutData.AddressID,
+		FirstName:        checkoutData.FirstName,
+		LastName:         checkoutData.LastName,
+		Email:            checkoutData.Email,
+		Phone:            checkoutData.Phone,
+		DeliveryLocation: checkoutData.DeliveryLocation,
+		DeliveryZoneID:   checkoutData.DeliveryZoneID,
+		CouponCode:       checkoutData.CouponCode,
+	}
+	for _, item := range cart.CartItems {
+		orderInfo.OrderItems = append(orderInfo.OrderItems, models.OrderItem{
+			ProductId: item.ProductID,
+			VariantID: item.VariantID,
+			Name:      item.Name,
+			Price:     item.Price,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	tx := initializers.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	order, err := createOrderRecord(tx, orderInfo)
+	if err != nil {
+		tx.Rollback()
+		sendOrderCreationError(ctx, err)
+		return
+	}
+
+	if err := tx.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error; err != nil {
+		tx.Rollback()
+		sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to clear cart")
+		return
+	}
+
+	if err := queueOrderNotifications(tx, orderEventPlaced, order); err != nil {
+		tx.Rollback()
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to save order", err)
+		return
+	}
+
+	if err := queueOrderWebhook(tx, models.WebhookEventOrderCreated, order.ID, nil); err != nil {
+		tx.Rollback()
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to save order", err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to save order")
+		return
+	}
+	publishOrderEvent(orderStreamCreated, order)
+
+	if order.IsGuest() {
+		requestOrderPayment(ctx, order, gin.H{"order_access_token": issueOrderAccessToken(order)})
+		return
+	}
+	requestOrderPayment(ctx, order, nil)
+}
+
+// mergeGuestCart moves the items of a guest cart into the user's cart after login
+func mergeGuestCart(userID int, cartToken string) error {
+	var guestCart models.Cart
+	result := initializers.DB.Preload("CartItems").
+		Where("user_id = 0 AND guest_token = ?", cartToken).
+		Limit(1).Find(&guestCart)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	var userCart models.Cart
+	result = initializers.DB.Preload("CartItems").Where("user_id = ?", userID).Limit(1).Find(&userCart)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// Without an existing cart the guest cart simply becomes the user's cart
+	if result.RowsAffected == 0 {
+		return initializers.DB.Model(&guestCart).Updates(map[string]any{
+			"user_id":     userID,
+			"guest_token": "",
+		}).Error
+	}
+
+	return initializers.DB.Transaction(func(tx *gorm.DB) error {
+		for _, guestItem := range guestCart.CartItems {
+			merged := false
+			for _, userItem := range userCart.CartItems {
+				if userItem.ProductID != guestItem.ProductID || userItem.VariantID != guestItem.VariantID {
+					continue
+				}
+				if err := tx.Model(&userItem).
+					Update("quantity", userItem.Quantity+guestItem.Quantity).Error; err != nil {
+					return err
+				}
+				if err := tx.Delete(&guestItem).Error; err != nil {
+					return err
+				}
+				merged = true
+				break
+			}
+
+			if !merged {
+				if err := tx.Model(&guestItem).Update("cart_id", userCart.ID).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Model(&userCart).Update("updated_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&guestCart).Error
+	})
+}
+
+// GetAbandonedCarts lists carts with items that have not been touched for a while
+func GetAbandonedCarts(ctx *gin.Context) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "15"))
+	offset := (page - 1) * limit
+
+	hours, err := strconv.Atoi(ctx.DefaultQuery("hours", "24"))
+	if err != nil || hours < 1 {
+		sendErrorResponse(ctx, http.StatusBadRequest, "Invalid hours")
+		return
+	}
+	cutoff := time.Now().Add(-time.Duration(hours) * time.Hour)
+
+	query := initializers.DB.Model(&models.Cart{}).
+		Where("carts.updated_at < ?", cutoff).
+		Where("EXISTS (SELECT 1 FROM cart_items WHERE cart_items.cart_id = carts.id AND cart_items.deleted_at IS NULL)")
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch carts", err)
+		return
+	}
+
+	var carts []models.Cart
+	if err := query.Preload("CartItems").
+		Order("carts.updated_at desc").
+		Limit(limit).Offset(offset).
+		Find(&carts).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch carts", err)
+		return
+	}
+
+	previousPage := page - 1
+	nextPage := page + 1
+	totalPages := math.Ceil(float64(count) / float64(limit))
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"carts": carts,
+		"metadata": gin.H{
+			"total":        count,
+			"currentPage":  page,
+			"limit":        limit,
+			"hasPrevPage":  previousPage > 0,
+			"hasNextPage":  int(totalPages) > page,
+			"previousPage": previousPage,
+			"nextPage":     nextPage,
+		},
+	})
+}
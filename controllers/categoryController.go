@@ -0,0 +1,538 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const msgCategoryNotFound = "Category not found"
+
+var errUnknownCategory = errors.New("unknown category, create it first")
+
+// categoryTree indexes all categories by id and by parent. The tree is small enough to load
+// whole, which is simpler than walking it with recursive queries.
+type categoryTree struct {
+	byID     map[uint]models.Category
+	children map[uint][]models.Category // top level categories are under 0
+}
+
+func loadCategoryTree(db *gorm.DB) (categoryTree, error) {
+	tree := categoryTree{byID: make(map[uint]models.Category), children: make(map[uint][]models.Category)}
+
+	var categories []models.Category
+	if err := db.Order("sort_order asc, name asc").Find(&categories).Error; err != nil {
+		return tree, err
+	}
+	for _, category := range categories {
+		tree.byID[category.ID] = category
+		parentID := uint(0)
+		if category.ParentID != nil {
+			parentID = uint(*category.ParentID)
+		}
+		tree.children[parentID] = append(tree.children[parentID], category)
+	}
+	return tree, nil
+}
+
+// nested returns the categories under parentID with their children filled in
+func (tree categoryTree) nested(parentID uint) []models.Category {
+	categories := make([]models.Category, 0, len(tree.children[parentID]))
+	for _, category := range tree.children[parentID] {
+		category.Children = tree.nested(category.ID)
+		categories = append(categories, category)
+	}
+	return categories
+}
+
+// descendantIDs lists a category and every category below it
+func (tree categoryTree) descendantIDs(id uint) []uint {
+	ids := []uint{id}
+	for _, child := range tree.children[id] {
+		ids = append(ids, tree.descendantIDs(child.ID)...)
+	}
+	return ids
+}
+
+// breadcrumb lists a category and its ancestors, starting from the top level
+func (tree categoryTree) breadcrumb(id uint) []models.Category {
+	var trail []models.Category
+	for category, exists := tree.byID[id]; exists && len(trail) <= len(tree.byID); {
+		trail = append([]models.Category{category}, trail...)
+		if category.ParentID == nil {
+			break
+		}
+		category, exists = tree.byID[uint(*category.ParentID)]
+	}
+	return trail
+}
+
+// findByRef finds a category by id or slug
+func (tree categoryTree) findByRef(ref string) (models.Category, bool) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		category, exists := tree.byID[uint(id)]
+		return category, exists
+	}
+	for _, category := range tree.byID {
+		if category.Slug == ref {
+			return category, true
+		}
+	}
+	return models.Category{}, false
+}
+
+// resolveProductCategory links a product to its category, chosen by categoryId or by the
+// name in category. Names are matched ignoring case and punctuation and the product keeps
+// the category's own spelling.
+func resolveProductCategory(db *gorm.DB, product *models.Product) error {
+	var category models.Category
+	if product.CategoryID != nil {
+		if err := db.First(&category, *product.CategoryID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errUnknownCategory
+			}
+			return err
+		}
+	} else {
+		name := strings.TrimSpace(product.Category)
+		if err := db.Where("name = ? OR slug = ?", name, utils.Slugify(name)).Limit(1).Find(&category).Error; err != nil {
+			return err
+		}
+		if category.ID == 0 {
+			return errUnknownCategory
+		}
+	}
+
+	categoryID := int(category.ID)
+	product.CategoryID = &categoryID
+	product.Category = category.Name
+	return nil
+}
+
+// uniqueCategorySlug picks a slug for a category that no other category uses. A name already
+// taken elsewhere in the tree is prefixed with the parent's slug, e.g. "phones-accessories".
+func uniqueCategorySlug(db *gorm.DB, category models.Category) (string, error) {
+	slug := category.Slug
+	if slug == "" {
+		slug = utils.Slugify(category.Name)
+	} else {
+		slug = utils.Slugify(slug)
+	}
+	if slug == "" {
+		return "", errors.New("the name needs letters or digits to make a slug")
+	}
+
+	taken := func(slug string) (bool, error) {
+		var count int64
+		err := db.Model(&models.Category{}).Where("slug = ? AND id != ?", slug, category.ID).Count(&count).Error
+		return count > 0, err
+	}
+
+	exists, err := taken(slug)
+	if err != nil || !exists {
+		return slug, err
+	}
+	if category.Slug != "" {
+		return "", fmt.Errorf("slug %s is already used", slug)
+	}
+	if category.ParentID != nil {
+		var parent models.Category
+		if err := db.Select("slug").First(&parent, *category.ParentID).Error; err == nil {
+			if exists, err := taken(parent.Slug + "-" + slug); err != nil || !exists {
+				return parent.Slug + "-" + slug, err
+			}
+		}
+	}
+	for i := 2; ; i++ {
+		candidate := slug + "-" + strconv.Itoa(i)
+		if exists, err := taken(candidate); err != nil || !exists {
+			return candidate, err
+		}
+	}
+}
+
+// checkCategoryParent makes sure a category's parent exists and is not the category itself or
+// one of its descendants, which would cut that branch off the tree
+func checkCategoryParent(tree categoryTree, category models.Category) error {
+	if category.ParentID == nil {
+		return nil
+	}
+	parentID := uint(*category.ParentID)
+	if _, exists := tree.byID[parentID]; !exists {
+		return errors.New("parent category not found")
+	}
+	if category.ID == 0 {
+		return nil
+	}
+	for _, id := range tree.descendantIDs(category.ID) {
+		if id == parentID {
+			return errors.New("a category can't be moved under itself")
+		}
+	}
+	return nil
+}
+
+// renameCategoryReferences updates the copies of a category's name kept on its products and
+// its tax class
+func renameCategoryReferences(tx *gorm.DB, categoryID uint, newName string) error {
+	if err := tx.Unscoped().Model(&models.Product{}).Where("category_id = ?", categoryID).Update("category", newName).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.CategoryTaxClass{}).Where("category_id = ?", categoryID).Update("category", newName).Error
+}
+
+// mergeCategoryReferences points coupons limited to a category at the one it is merged into.
+// The category's tax class is dropped, its products take the class of their new category.
+func mergeCategoryReferences(tx *gorm.DB, category, target models.Category) error {
+	if err := tx.Where("category_id = ?", category.ID).Delete(&models.CategoryTaxClass{}).Error; err != nil {
+		return err
+	}
+
+	var coupons []models.Coupon
+	if err := tx.Where("JSON_CONTAINS(category_ids, ?)", strconv.Itoa(int(category.ID))).Find(&coupons).Error; err != nil {
+		return err
+	}
+	for _, coupon := range coupons {
+		categoryIDs := slices.DeleteFunc(coupon.CategoryIDs, func(id uint) bool {
+			return id == category.ID || id == target.ID
+		})
+		categoryIDs = append(categoryIDs, target.ID)
+		if err := tx.Model(&coupon).Update("category_ids", categoryIDs).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendCategoryError responds to a product whose category couldn't be resolved
+func sendCategoryError(ctx *gin.Context, err error) {
+	if errors.Is(err, errUnknownCategory) {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid category", err)
+		return
+	}
+	respondWithError(ctx, http.StatusInternalServerError, "Failed to validate category", err)
+}
+
+func findCategory(ctx *gin.Context) (models.Category, bool) {
+	var category models.Category
+
+	categoryId, err := strconv.Atoi(ctx.Param("categoryId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid category ID", err)
+		return category, false
+	}
+	if err := initializers.DB.First(&category, categoryId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendErrorResponse(ctx, http.StatusNotFound, msgCategoryNotFound)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch category", err)
+		}
+		return category, false
+	}
+	return category, true
+}
+
+// GetCategories returns the category tree, or a flat list sorted by sort order with flat=true
+func GetCategories(ctx *gin.Context) {
+	tree, err := loadCategoryTree(initializers.DB)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch categories", err)
+		return
+	}
+
+	if ctx.Query("flat") == "true" {
+		categories := make([]models.Category, 0, len(tree.byID))
+		var appendBranch func(parentID uint)
+		appendBranch = func(parentID uint) {
+			for _, category := range tree.children[parentID] {
+				categories = append(categories, category)
+				appendBranch(category.ID)
+			}
+		}
+		appendBranch(0)
+		sendJSONResponse(ctx, http.StatusOK, gin.H{"categories": categories})
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"categories": tree.nested(0)})
+}
+
+// GetCategory returns a category by id or slug with its subcategories and breadcrumb
+func GetCategory(ctx *gin.Context) {
+	tree, err := loadCategoryTree(initializers.DB)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch categories", err)
+		return
+	}
+	category, exists := tree.findByRef(ctx.Param("categoryId"))
+	if !exists {
+		sendErrorResponse(ctx, http.StatusNotFound, msgCategoryNotFound)
+		return
+	}
+	category.Children = tree.nested(category.ID)
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"category":   category,
+		"breadcrumb": tree.breadcrumb(category.ID),
+	})
+}
+
+// CreateCategory adds a category. The slug is made from the name unless one is given.
+func CreateCategory(ctx *gin.Context) {
+	var category models.Category
+	if err := ctx.ShouldBindJSON(&category); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	category.ID = 0
+	category.Name = strings.TrimSpace(category.Name)
+
+	tree, err := loadCategoryTree(initializers.DB)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch categories", err)
+		return
+	}
+	if err := checkCategoryParent(tree, category); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid category", err)
+		return
+	}
+	if category.Slug, err = uniqueCategorySlug(initializers.DB, category); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid category", err)
+		return
+	}
+
+	if err := initializers.DB.Create(&category).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create category", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, category)
+}
+
+// UpdateCategory replaces a category's details. Moving it moves its subcategories with it, and
+// renaming it renames the category of its products.
+func UpdateCategory(ctx *gin.Context) {
+	category, ok := findCategory(ctx)
+	if !ok {
+		return
+	}
+	var updateData models.Category
+	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	updateData.ID = category.ID
+	updateData.Name = strings.TrimSpace(updateData.Name)
+	if updateData.Slug == "" {
+		updateData.Slug = category.Slug
+	}
+
+	tree, err := loadCategoryTree(initializers.DB)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch categories", err)
+		return
+	}
+	if err := checkCategoryParent(tree, updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid category", err)
+		return
+	}
+	if updateData.Slug, err = uniqueCategorySlug(initializers.DB, updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid category", err)
+		return
+	}
+
+	oldName := category.Name
+	category.ParentID = updateData.ParentID
+	category.Name = updateData.Name
+	category.Slug = updateData.Slug
+	category.Description = updateData.Description
+	category.ImageURL = updateData.ImageURL
+	category.SortOrder = updateData.SortOrder
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&category).
+			Select("parent_id", "name", "slug", "description", "image_url", "sort_order").
+			Updates(&category).Error; err != nil {
+			return err
+		}
+		if category.Name == oldName {
+			return nil
+		}
+		return renameCategoryReferences(tx, category.ID, category.Name)
+	})
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to update category", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Category updated successfully", "category": category})
+}
+
+// categoryInUse reports why a category can't be deleted, or "" when it can
+func categoryInUse(db *gorm.DB, categoryID uint) (string, error) {
+	var count int64
+	if err := db.Model(&models.Category{}).Where("parent_id = ?", categoryID).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "Move or delete its subcategories first", nil
+	}
+	if err := db.Model(&models.Product{}).Where("category_id = ?", categoryID).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "Move its products to another category first, or merge it into one", nil
+	}
+	if err := db.Model(&models.Coupon{}).Where("JSON_CONTAINS(category_ids, ?)", strconv.Itoa(int(categoryID))).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "Remove it from the coupons limited to it first, or merge it into another category", nil
+	}
+	return "", nil
+}
+
+// DeleteCategory removes a category without subcategories or products. It is removed for good
+// so its slug can be used again.
+func DeleteCategory(ctx *gin.Context) {
+	category, ok := findCategory(ctx)
+	if !ok {
+		return
+	}
+
+	reason, err := categoryInUse(initializers.DB, category.ID)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to delete category", err)
+		return
+	}
+	if reason != "" {
+		sendErrorResponse(ctx, http.StatusConflict, reason)
+		return
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("category_id = ?", category.ID).Delete(&models.CategoryTaxClass{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&category).Error
+	})
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to delete category", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Category deleted successfully"})
+}
+
+// MergeCategory moves the products, subcategories and coupon restrictions of a category into
+// another one (intoId) and deletes it, for duplicates like "Phones" and "Smartphones"
+func MergeCategory(ctx *gin.Context) {
+	category, ok := findCategory(ctx)
+	if !ok {
+		return
+	}
+	var body struct {
+		IntoID int `json:"intoId" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	tree, err := loadCategoryTree(initializers.DB)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch categories", err)
+		return
+	}
+	target, exists := tree.byID[uint(body.IntoID)]
+	if !exists {
+		sendErrorResponse(ctx, http.StatusNotFound, msgCategoryNotFound)
+		return
+	}
+	for _, id := range tree.descendantIDs(category.ID) {
+		if id == target.ID {
+			sendErrorResponse(ctx, http.StatusBadRequest, "A category can't be merged into itself or one of its subcategories")
+			return
+		}
+	}
+
+	var moved int64
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&models.Product{}).
+			Where("category_id = ?", category.ID).
+			Updates(map[string]any{"category_id": target.ID, "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		moved = result.RowsAffected
+
+		if err := renameCategoryReferences(tx, target.ID, target.Name); err != nil {
+			return err
+		}
+		if err := mergeCategoryReferences(tx, category, target); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Category{}).Where("parent_id = ?", category.ID).Update("parent_id", target.ID).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&category).Error
+	})
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to merge category", err)
+		return
+	}
+	log.Printf("Category %d merged into %d, %d products moved", category.ID, target.ID, moved)
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message":       "Category merged successfully",
+		"category":      target,
+		"movedProducts": moved,
+	})
+}
+
+// UploadCategoryImage uploads the "image" form file and sets it as the category's image
+func UploadCategoryImage(ctx *gin.Context) {
+	category, ok := findCategory(ctx)
+	if !ok {
+		return
+	}
+	file, err := ctx.FormFile("image")
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "No image uploaded", err)
+		return
+	}
+	if !strings.HasPrefix(file.Header.Get("Content-Type"), "image/") {
+		sendErrorResponse(ctx, http.StatusBadRequest, "The file must be an image")
+		return
+	}
+
+	uploader, err := getAWSUploader()
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to configure AWS", err)
+		return
+	}
+	key := fmt.Sprintf("categories/%d-%s-%s", category.ID, time.Now().Format("20060102150405"), file.Filename)
+	location, err := uploadPublicFile(uploader, file, key)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to upload image", err)
+		return
+	}
+
+	if err := initializers.DB.Model(&category).Update("image_url", location).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to save image", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Image uploaded", "category": category})
+}
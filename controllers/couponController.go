@@ -0,0 +1,428 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// couponError explains to the customer why a coupon cannot be applied
+type couponError struct {
+	message string
+}
+
+func (e *couponError) Error() string {
+	return e.message
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func findCouponByCode(db *gorm.DB, code string) (models.Coupon, error) {
+	var coupon models.Coupon
+	if err := db.Where("code = ?", normalizeCouponCode(code)).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return coupon, &couponError{"coupon code is not valid"}
+		}
+		return coupon, err
+	}
+	return coupon, nil
+}
+
+// checkCouponUsable verifies the coupon is active, within its validity window and under its usage limits
+func checkCouponUsable(db *gorm.DB, coupon models.Coupon, userID int, email string) error {
+	now := time.Now()
+	if !coupon.Active {
+		return &couponError{"coupon is no longer active"}
+	}
+	if coupon.StartsAt != nil && now.Before(*coupon.StartsAt) {
+		return &couponError{"coupon is not valid yet"}
+	}
+	if coupon.EndsAt != nil && now.After(*coupon.EndsAt) {
+		return &couponError{"coupon has expired"}
+	}
+	if coupon.UsageLimit > 0 && coupon.TimesUsed >= coupon.UsageLimit {
+		return &couponError{"coupon usage limit has been reached"}
+	}
+
+	return checkCouponPerUserLimit(db, coupon, userID, email)
+}
+
+// checkCouponPerUserLimit counts the customer's redemptions of the coupon, by account or email
+func checkCouponPerUserLimit(db *gorm.DB, coupon models.Coupon, userID int, email string) error {
+	if coupon.PerUserLimit == 0 {
+		return nil
+	}
+
+	query := db.Model(&models.CouponRedemption{}).Where("coupon_id = ?", coupon.ID)
+	if userID != 0 {
+		query = query.Where("user_id = ? OR email = ?", userID, email)
+	} else {
+		query = query.Where("email = ?", email)
+	}
+
+	var used int64
+	if err := query.Count(&used).Error; err != nil {
+		return err
+	}
+	if int(used) >= coupon.PerUserLimit {
+		return &couponError{"you have already used this coupon the maximum number of times"}
+	}
+	return nil
+}
+
+// couponAppliesTo reports whether the coupon's restrictions allow the product. Coupons limited
+// to a category also apply to products in its subcategories.
+func couponAppliesTo(coupon models.Coupon, categories categoryTree, product models.Product) bool {
+	if len(coupon.ProductIDs) > 0 && !slices.Contains(coupon.ProductIDs, int(product.ID)) {
+		return false
+	}
+	if len(coupon.CategoryIDs) > 0 {
+		if product.CategoryID == nil {
+			return false
+		}
+		if !slices.ContainsFunc(categories.breadcrumb(uint(*product.CategoryID)), func(category models.Category) bool {
+			return slices.Contains(coupon.CategoryIDs, category.ID)
+		}) {
+			return false
+		}
+	}
+	if len(coupon.BrandIDs) > 0 && (product.BrandID == nil || !slices.Contains(coupon.BrandIDs, uint(*product.BrandID))) {
+		return false
+	}
+	return true
+}
+
+// calculateCouponDiscount returns the discount for each item and the total discount.
+// The discount is spread over eligible items in proportion to their value.
+func calculateCouponDiscount(coupon models.Coupon, items []models.OrderItem) ([]models.Money, models.Money, error) {
+	productIDs := make([]int, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductId)
+	}
+	products, err := findProductsByID(productIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	var categories categoryTree
+	if len(coupon.CategoryIDs) > 0 {
+		if categories, err = loadCategoryTree(initializers.DB); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var subtotal, eligibleSubtotal models.Money
+	eligibleTotals := make([]models.Money, len(items))
+	for i, item := range items {
+		lineTotal := item.Price.Mul(item.Quantity)
+		subtotal += lineTotal
+		if product, exists := products[item.ProductId]; exists && couponAppliesTo(coupon, categories, product) {
+			eligibleTotals[i] = lineTotal
+			eligibleSubtotal += lineTotal
+		}
+	}
+
+	if subtotal < coupon.MinOrderValue {
+		return nil, 0, &couponError{"coupon requires a minimum order of " + coupon.MinOrderValue.Format(models.DefaultCurrency)}
+	}
+	if eligibleSubtotal == 0 {
+		return nil, 0, &couponError{"coupon does not apply to any items in this order"}
+	}
+
+	var discount models.Money
+	switch coupon.Type {
+	case models.CouponTypePercentage:
+		discount = eligibleSubtotal.Percent(coupon.Value)
+		if coupon.MaxDiscount > 0 {
+			discount = min(discount, coupon.MaxDiscount)
+		}
+	case models.CouponTypeFixed:
+		discount = min(coupon.Amount, eligibleSubtotal)
+	}
+
+	return discount.Allocate(eligibleTotals), discount, nil
+}
+
+// applyCoupon validates the coupon named on the order and calculates its discount
+func applyCoupon(db *gorm.DB, orderInfo models.Order) (models.Coupon, []models.Money, models.Money, error) {
+	coupon, err := findCouponByCode(db, orderInfo.CouponCode)
+	if err != nil {
+		return coupon, nil, 0, err
+	}
+	if err := checkCouponUsable(db, coupon, orderInfo.CustomerID(), orderInfo.Email); err != nil {
+		return coupon, nil, 0, err
+	}
+
+	itemDiscounts, discount, err := calculateCouponDiscount(coupon, orderInfo.OrderItems)
+	return coupon, itemDiscounts, discount, err
+}
+
+// redeemCoupon records a coupon use against an order within tx
+func redeemCoupon(tx *gorm.DB, coupon models.Coupon, order models.Order) error {
+	result := tx.Model(&models.Coupon{}).
+		Where("id = ? AND (usage_limit = 0 OR times_used < usage_limit)", coupon.ID).
+		Update("times_used", gorm.Expr("times_used + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &couponError{"coupon usage limit has been reached"}
+	}
+
+	// The update above locks the coupon until tx ends, so concurrent checkouts by the same
+	// customer are counted one after the other. The locking read sees redemptions committed since.
+	if err := checkCouponPerUserLimit(tx.Clauses(clause.Locking{Strength: "UPDATE"}), coupon, order.CustomerID(), order.Email); err != nil {
+		return err
+	}
+
+	return tx.Create(&models.CouponRedemption{
+		CouponID: int(coupon.ID),
+		OrderID:  int(order.ID),
+		UserID:   order.CustomerID(),
+		Email:    order.Email,
+		Discount: order.Discount,
+	}).Error
+}
+
+// ValidateCoupon previews the discount a coupon gives on a set of items
+func ValidateCoupon(ctx *gin.Context) {
+	var couponData struct {
+		CouponCode string             `json:"couponCode" binding:"required"`
+		Email      string             `json:"email"`
+		OrderItems []models.OrderItem `json:"orderItems" binding:"required,min=1"`
+	}
+	if err := ctx.ShouldBindJSON(&couponData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	orderItems, _, err := priceOrderItems(couponData.OrderItems)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid order items", err)
+		return
+	}
+
+	var userID *int
+	if id, ok := getAuthenticatedUserID(ctx); ok {
+		userID = &id
+	}
+	coupon, itemDiscounts, discount, err := applyCoupon(initializers.DB, models.Order{
+		UserID:     userID,
+		Email:      couponData.Email,
+		CouponCode: couponData.CouponCode,
+		OrderItems: orderItems,
+	})
+	if err != nil {
+		var couponErr *couponError
+		if errors.As(err, &couponErr) {
+			sendErrorResponse(ctx, http.StatusBadRequest, couponErr.Error())
+			return
+		}
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to validate coupon", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"couponCode":    coupon.Code,
+		"type":          coupon.Type,
+		"discount":      discount,
+		"itemDiscounts": itemDiscounts,
+		"freeDelivery":  coupon.Type == models.CouponTypeFreeDelivery,
+	})
+}
+
+// validateCouponData checks the values an admin entered make sense for the coupon type
+func validateCouponData(coupon models.Coupon) error {
+	switch coupon.Type {
+	case models.CouponTypePercentage:
+		if coupon.Value <= 0 || coupon.Value > 100 {
+			return errors.New("percentage coupons need a value between 0 and 100")
+		}
+	case models.CouponTypeFixed:
+		if coupon.Amount <= 0 {
+			return errors.New("fixed coupons need an amount greater than 0")
+		}
+	}
+	if coupon.StartsAt != nil && coupon.EndsAt != nil && coupon.EndsAt.Before(*coupon.StartsAt) {
+		return errors.New("endsAt must be after startsAt")
+	}
+	if len(coupon.CategoryIDs) > 0 {
+		var found int64
+		if err := initializers.DB.Model(&models.Category{}).Where("id IN ?", []uint(coupon.CategoryIDs)).Count(&found).Error; err != nil {
+			return err
+		}
+		if int(found) != len(slices.Compact(slices.Sorted(slices.Values(coupon.CategoryIDs)))) {
+			return errors.New("categoryIds contains a category that doesn't exist")
+		}
+	}
+	if len(coupon.BrandIDs) > 0 {
+		var found int64
+		if err := initializers.DB.Model(&models.Brand{}).Where("id IN ?", []uint(coupon.BrandIDs)).Count(&found).Error; err != nil {
+			return err
+		}
+		if int(found) != len(slices.Compact(slices.Sorted(slices.Values(coupon.BrandIDs)))) {
+			return errors.New("brandIds contains a brand that doesn't exist")
+		}
+	}
+	return nil
+}
+
+func CreateCoupon(ctx *gin.Context) {
+	coupon := models.Coupon{Active: true} // unless the body says otherwise
+	if err := ctx.ShouldBindJSON(&coupon); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := validateCouponData(coupon); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid coupon", err)
+		return
+	}
+
+	coupon.Code = normalizeCouponCode(coupon.Code)
+	coupon.TimesUsed = 0
+
+	if err := initializers.DB.Create(&coupon).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create coupon", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, coupon)
+}
+
+func GetCoupons(ctx *gin.Context) {
+	var coupons []models.Coupon
+
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "15"))
+	offset := (page - 1) * limit
+
+	query := initializers.DB.Model(&models.Coupon{})
+	if search := ctx.Query("search"); search != "" {
+		query = query.Where("code LIKE ?", "%"+normalizeCouponCode(search)+"%")
+	}
+
+	var count int64
+	query.Count(&count)
+
+	if result := query.Order("created_at desc").Limit(limit).Offset(offset).Find(&coupons); result.Error != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch coupons", result.Error)
+		return
+	}
+
+	previousPage := page - 1
+	nextPage := page + 1
+	totalPages := math.Ceil(float64(count) / float64(limit))
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"coupons": coupons,
+		"metadata": gin.H{
+			"total":        count,
+			"currentPage":  page,
+			"limit":        limit,
+			"hasPrevPage":  previousPage > 0,
+			"hasNextPage":  int(totalPages) > page,
+			"previousPage": previousPage,
+			"nextPage":     nextPage,
+		},
+	})
+}
+
+func GetCoupon(ctx *gin.Context) {
+	couponId, err := strconv.Atoi(ctx.Param("couponId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid coupon ID", err)
+		return
+	}
+
+	var coupon models.Coupon
+	if err := initializers.DB.First(&coupon, couponId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, "Coupon not found", nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Unable to retrieve coupon", err)
+		}
+		return
+	}
+
+	var redemptions []models.CouponRedemption
+	if err := initializers.DB.Where("coupon_id = ?", couponId).Order("created_at desc").Find(&redemptions).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to retrieve coupon redemptions", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"coupon":      coupon,
+		"redemptions": redemptions,
+	})
+}
+
+func UpdateCoupon(ctx *gin.Context) {
+	couponId, err := strconv.Atoi(ctx.Param("couponId"))
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Unable to parse couponId")
+		return
+	}
+
+	var coupon models.Coupon
+	if err := initializers.DB.First(&coupon, couponId).Error; err != nil {
+		sendErrorResponse(ctx, http.StatusNotFound, "Coupon not found")
+		return
+	}
+
+	var updateData models.Coupon
+	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := validateCouponData(updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid coupon", err)
+		return
+	}
+	updateData.Code = normalizeCouponCode(updateData.Code)
+
+	// Every field is written so coupons can be deactivated and restrictions cleared
+	if err := initializers.DB.Model(&coupon).
+		Select("*").
+		Omit("ID", "CreatedAt", "DeletedAt", "TimesUsed").
+		Updates(updateData).Error; err != nil {
+		log.Println("Failed to update coupon:", err)
+		sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to update coupon")
+		return
+	}
+
+	initializers.DB.First(&coupon, couponId)
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message": "Coupon updated successfully",
+		"coupon":  coupon,
+	})
+}
+
+func DeleteCoupon(ctx *gin.Context) {
+	couponId, err := strconv.Atoi(ctx.Param("couponId"))
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Unable to parse couponId")
+		return
+	}
+
+	if result := initializers.DB.Delete(&models.Coupon{}, couponId); result.Error != nil {
+		log.Println(result.Error)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to delete coupon.")
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Coupon deleted successfully."})
+}
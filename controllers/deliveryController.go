@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errInvalidDeliveryZone  = errors.New("selected delivery zone is not available")
+	errDeliveryZoneRequired = errors.New("choose a delivery zone")
+)
+
+// deliveryFee returns the fee for delivering an order of the given value to a zone
+func deliveryFee(zone models.DeliveryZone, orderValue models.Money) models.Money {
+	if zone.FreeDeliveryThreshold > 0 && orderValue >= zone.FreeDeliveryThreshold {
+		return 0
+	}
+	return zone.Fee
+}
+
+// deliveryWindow returns the earliest and latest expected delivery dates for a zone
+func deliveryWindow(zone models.DeliveryZone, from time.Time) (time.Time, time.Time) {
+	return from.AddDate(0, 0, zone.MinLeadDays), from.AddDate(0, 0, zone.MaxLeadDays)
+}
+
+func findActiveDeliveryZone(db *gorm.DB, zoneID int) (models.DeliveryZone, error) {
+	var zone models.DeliveryZone
+	if err := db.Where("active = ?", true).First(&zone, zoneID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return zone, errInvalidDeliveryZone
+		}
+		return zone, err
+	}
+	return zone, nil
+}
+
+// applyDeliveryZone stores the selected zone, fee and delivery window on the order
+func applyDeliveryZone(db *gorm.DB, order *models.Order, zoneID int) error {
+	zone, err := findActiveDeliveryZone(db, zoneID)
+	if err != nil {
+		return err
+	}
+
+	deliveryFrom, deliveryBy := deliveryWindow(zone, time.Now())
+	order.DeliveryZoneID = int(zone.ID)
+	order.DeliveryZoneName = zone.Name
+	order.DeliveryFrom = &deliveryFrom
+	order.DeliveryBy = &deliveryBy
+
+	if order.FreeDelivery {
+		order.DeliveryFee = 0
+	} else {
+		order.DeliveryFee = deliveryFee(zone, order.Total)
+	}
+	order.Total += order.DeliveryFee
+	return nil
+}
+
+// GetDeliveryZones lists the zones customers can choose from. Admins see inactive zones too
+// with all=true.
+func GetDeliveryZones(ctx *gin.Context) {
+	var zones []models.DeliveryZone
+
+	query := initializers.DB.Order("sort_order asc, name asc")
+	if ctx.Query("all") != "true" || !isAdminUser(ctx) {
+		query = query.Where("active = ?", true)
+	}
+
+	if result := query.Find(&zones); result.Error != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch delivery zones", result.Error)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"deliveryZones": zones})
+}
+
+// GetDeliveryQuote prices delivery of the caller's cart to each active zone
+func GetDeliveryQuote(ctx *gin.Context) {
+	var subtotal models.Money
+
+	cart, found, err := findCart(ctx, false)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, msgFailedToFetchCart, err)
+		return
+	}
+	if found {
+		cartSubtotal, _, err := revalidateCart(&cart)
+		if err != nil {
+			respondWithError(ctx, http.StatusInternalServerError, msgFailedToFetchCart, err)
+			return
+		}
+		subtotal = cartSubtotal
+	}
+
+	query := initializers.DB.Where("active = ?", true).Order("sort_order asc, name asc")
+	if zoneID := ctx.Query("zoneId"); zoneID != "" {
+		query = query.Where("id = ?", zoneID)
+	}
+
+	var zones []models.DeliveryZone
+	if err := query.Find(&zones).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch delivery zones", err)
+		return
+	}
+
+	now := time.Now()
+	quotes := make([]gin.H, 0, len(zones))
+	for _, zone := range zones {
+		fee := deliveryFee(zone, subtotal)
+		deliveryFrom, deliveryBy := deliveryWindow(zone, now)
+
+		quote := gin.H{
+			"deliveryZoneId": zone.ID,
+			"name":           zone.Name,
+			"isPickupPoint":  zone.IsPickupPoint,
+			"fee":            fee,
+			"total":          subtotal + fee,
+			"deliveryFrom":   deliveryFrom,
+			"deliveryBy":     deliveryBy,
+		}
+		if zone.FreeDeliveryThreshold > 0 && fee > 0 {
+			quote["amountToFreeDelivery"] = zone.FreeDeliveryThreshold - subtotal
+		}
+		quotes = append(quotes, quote)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"subtotal": subtotal,
+		"quotes":   quotes,
+	})
+}
+
+func validateDeliveryZoneData(zone models.DeliveryZone) error {
+	if zone.Fee < 0 || zone.FreeDeliveryThreshold < 0 {
+		return errors.New("fees and thresholds cannot be negative")
+	}
+	if zone.MinLeadDays < 0 || zone.MaxLeadDays < zone.MinLeadDays {
+		return errors.New("maxLeadDays must be greater than or equal to minLeadDays")
+	}
+	return nil
+}
+
+func CreateDeliveryZone(ctx *gin.Context) {
+	zone := models.DeliveryZone{Active: true} // unless the body says otherwise
+	if err := ctx.ShouldBindJSON(&zone); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := validateDeliveryZoneData(zone); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid delivery zone", err)
+		return
+	}
+
+	if err := initializers.DB.Create(&zone).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create delivery zone", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, zone)
+}
+
+func UpdateDeliveryZone(ctx *gin.Context) {
+	zoneId, err := strconv.Atoi(ctx.Param("zoneId"))
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Unable to parse zoneId")
+		return
+	}
+
+	var zone models.DeliveryZone
+	if err := initializers.DB.First(&zone, zoneId).Error; err != nil {
+		sendErrorResponse(ctx, http.StatusNotFound, "Delivery zone not found")
+		return
+	}
+
+	var updateData models.DeliveryZone
+	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := validateDeliveryZoneData(updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid delivery zone", err)
+		return
+	}
+
+	// Every field is written so zones can be deactivated and thresholds removed
+	if err := initializers.DB.Model(&zone).
+		Select("*").
+		Omit("ID", "CreatedAt", "DeletedAt").
+		Updates(updateData).Error; err != nil {
+		log.Println("Failed to update delivery zone:", err)
+		sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to update delivery zone")
+		return
+	}
+
+	initializers.DB.First(&zone, zoneId)
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message":      "Delivery zone updated successfully",
+		"deliveryZone": zone,
+	})
+}
+
+func DeleteDeliveryZone(ctx *gin.Context) {
+	zoneId, err := strconv.Atoi(ctx.Param("zoneId"))
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Unable to parse zoneId")
+		return
+	}
+
+	if result := initializers.DB.Delete(&models.DeliveryZone{}, zoneId); result.Error != nil {
+		log.Println(result.Error)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to delete delivery zone.")
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Delivery zone deleted successfully."})
+}
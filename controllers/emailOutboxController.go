@@ -0,0 +1,304 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	outboxPollInterval     = 5 * time.Second
+	outboxBatchSize        = 20
+	outboxMaxAttempts      = 8
+	outboxBaseBackoff      = 30 * time.Second
+	outboxMaxBackoff       = time.Hour
+	outboxSendingLease     = 5 * time.Minute
+	msgOutboxEmailNotFound = "Email not found"
+)
+
+// queueEmail adds a message to the outbox within tx. It is delivered by the outbox worker
+// once tx commits, so the email is only sent if the change that caused it is saved.
+func queueEmail(tx *gorm.DB, recipient, subject, htmlBody, textBody string, attachments ...utils.EmailAttachment) error {
+	email := models.OutboxEmail{
+		Recipient:     recipient,
+		Subject:       subject,
+		HTMLBody:      htmlBody,
+		TextBody:      textBody,
+		Status:        models.OutboxStatusPending,
+		NextAttemptAt: time.Now(),
+		MaxAttempts:   outboxMaxAttempts,
+	}
+	for _, attachment := range attachments {
+		email.Attachments = append(email.Attachments, models.OutboxEmailAttachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Content:     attachment.Content,
+		})
+	}
+	return tx.Create(&email).Error
+}
+
+// queueTemplatedEmail renders one of the single button email templates and adds it to the outbox
+func queueTemplatedEmail(tx *gorm.DB, recipient, subject string, data utils.EmailData, templatePath string) error {
+	htmlBody, _, err := utils.RenderEmail(data, templatePath, "")
+	if err != nil {
+		return err
+	}
+	return queueEmail(tx, recipient, subject, htmlBody, "")
+}
+
+// outboxBackoff is the wait before the next attempt, doubling after each failure
+func outboxBackoff(attempts int) time.Duration {
+	return retryBackoff(outboxBaseBackoff, outboxMaxBackoff, attempts)
+}
+
+// retryBackoff doubles base after each failed attempt, up to max
+func retryBackoff(base, max time.Duration, attempts int) time.Duration {
+	backoff := base << (attempts - 1)
+	if backoff <= 0 || backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// claimOutboxEmails takes due messages for this worker. Claimed messages are leased rather
+// than locked, so a message is retried if the worker stops while sending it.
+func claimOutboxEmails() ([]models.OutboxEmail, error) {
+	var emails []models.OutboxEmail
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ?", []string{models.OutboxStatusPending, models.OutboxStatusSending}, time.Now()).
+			Order("next_attempt_at asc").
+			Limit(outboxBatchSize).
+			Find(&emails).Error; err != nil {
+			return err
+		}
+		if len(emails) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(emails))
+		for _, email := range emails {
+			ids = append(ids, email.ID)
+		}
+		return tx.Model(&models.OutboxEmail{}).Where("id IN ?", ids).Updates(map[string]any{
+			"status":          models.OutboxStatusSending,
+			"next_attempt_at": time.Now().Add(outboxSendingLease),
+		}).Error
+	})
+	return emails, err
+}
+
+// deliverOutboxEmail makes one delivery attempt and records its outcome
+func deliverOutboxEmail(email models.OutboxEmail) {
+	var attachments []models.OutboxEmailAttachment
+	err := initializers.DB.Where("outbox_email_id = ?", email.ID).Find(&attachments).Error
+	if err == nil {
+		files := make([]utils.EmailAttachment, 0, len(attachments))
+		for _, attachment := range attachments {
+			files = append(files, utils.EmailAttachment{
+				Filename:    attachment.Filename,
+				ContentType: attachment.ContentType,
+				Content:     attachment.Content,
+			})
+		}
+		err = utils.SendMessage(email.Recipient, email.Subject, email.HTMLBody, email.TextBody, files...)
+	}
+
+	attempt := models.OutboxEmailAttempt{
+		OutboxEmailID: int(email.ID),
+		Attempt:       email.Attempts + 1,
+		Succeeded:     err == nil,
+	}
+	updates := map[string]any{"attempts": attempt.Attempt}
+
+	switch {
+	case err == nil:
+		now := time.Now()
+		updates["status"] = models.OutboxStatusSent
+		updates["sent_at"] = &now
+		updates["last_error"] = ""
+	case attempt.Attempt >= email.MaxAttempts:
+		attempt.Error = err.Error()
+		updates["status"] = models.OutboxStatusFailed
+		updates["last_error"] = attempt.Error
+		log.Printf("Giving up on email %d to %s after %d attempts: %v", email.ID, email.Recipient, attempt.Attempt, err)
+	default:
+		attempt.Error = err.Error()
+		updates["status"] = models.OutboxStatusPending
+		updates["next_attempt_at"] = time.Now().Add(outboxBackoff(attempt.Attempt))
+		updates["last_error"] = attempt.Error
+		log.Printf("Error sending email %d to %s, will retry: %v", email.ID, email.Recipient, err)
+	}
+
+	if err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.OutboxEmail{}).Where("id = ?", email.ID).Updates(updates).Error
+	}); err != nil {
+		log.Println("Error recording email delivery attempt:", err)
+	}
+}
+
+func processOutbox() {
+	emails, err := claimOutboxEmails()
+	if err != nil {
+		log.Println("Error claiming outbox emails:", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, email := range emails {
+		wg.Add(1)
+		go func(email models.OutboxEmail) {
+			defer wg.Done()
+			deliverOutboxEmail(email)
+		}(email)
+	}
+	wg.Wait()
+}
+
+// StartEmailOutboxWorker delivers queued emails in the background until the process exits
+func StartEmailOutboxWorker() {
+	go func() {
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			processOutbox()
+		}
+	}()
+}
+
+// GetOutboxEmails lists outbox emails, optionally filtered by status, without their bodies
+func GetOutboxEmails(ctx *gin.Context) {
+	var emails []models.OutboxEmail
+
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "15"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 15
+	}
+	offset := (page - 1) * limit
+
+	query := initializers.DB.Model(&models.OutboxEmail{})
+	if status := ctx.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch emails", err)
+		return
+	}
+
+	if err := query.Omit("html_body", "text_body").
+		Order("created_at desc").
+		Limit(limit).
+		Offset(offset).
+		Find(&emails).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch emails", err)
+		return
+	}
+
+	previousPage := page - 1
+	nextPage := page + 1
+	totalPages := math.Ceil(float64(count) / float64(limit))
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"emails": emails,
+		"metadata": gin.H{
+			"total":        count,
+			"currentPage":  page,
+			"limit":        limit,
+			"hasPrevPage":  previousPage > 0,
+			"hasNextPage":  int(totalPages) > page,
+			"previousPage": previousPage,
+			"nextPage":     nextPage,
+		},
+	})
+}
+
+func findOutboxEmail(ctx *gin.Context) (models.OutboxEmail, bool) {
+	var email models.OutboxEmail
+
+	emailId, err := strconv.Atoi(ctx.Param("emailId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid email ID", err)
+		return email, false
+	}
+
+	if err := initializers.DB.Preload("Attachments").Preload("AttemptLog").First(&email, emailId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, msgOutboxEmailNotFound, nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Unable to retrieve email", err)
+		}
+		return email, false
+	}
+	return email, true
+}
+
+// emailTokenPattern matches the token of links in emails, such as password reset, account
+// activation and order tracking links
+var emailTokenPattern = regexp.MustCompile(`([?&]token=)[^"'&\s<>]+`)
+
+// redactEmailTokens hides link tokens so whoever reads an email in the outbox can't use them
+func redactEmailTokens(body string) string {
+	return emailTokenPattern.ReplaceAllString(body, "${1}redacted")
+}
+
+// GetOutboxEmail shows an outbox email with its delivery attempts. Link tokens in the body are
+// redacted since they would let the reader reset passwords or activate accounts.
+func GetOutboxEmail(ctx *gin.Context) {
+	email, ok := findOutboxEmail(ctx)
+	if !ok {
+		return
+	}
+	email.HTMLBody = redactEmailTokens(email.HTMLBody)
+	email.TextBody = redactEmailTokens(email.TextBody)
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"email": email})
+}
+
+// ResendOutboxEmail queues a failed or already sent email for delivery again
+func ResendOutboxEmail(ctx *gin.Context) {
+	email, ok := findOutboxEmail(ctx)
+	if !ok {
+		return
+	}
+
+	if email.Status == models.OutboxStatusPending || email.Status == models.OutboxStatusSending {
+		sendErrorResponse(ctx, http.StatusConflict, "Email is already waiting to be sent")
+		return
+	}
+
+	// Attempts start again from zero, earlier ones stay in the attempt log
+	if err := initializers.DB.Model(&email).Updates(map[string]any{
+		"status":          models.OutboxStatusPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to resend email", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Email queued for delivery."})
+}
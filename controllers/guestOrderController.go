@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Purpose of the signed tokens that give guests access to a single order
+	orderAccessTokenPurpose = "order-access"
+	orderAccessTokenTTL     = 180 * 24 * time.Hour
+
+	msgInvalidOrderAccessToken = "Invalid or expired order link"
+)
+
+func issueOrderAccessToken(order models.Order) string {
+	return utils.SignToken(orderAccessTokenPurpose, strconv.Itoa(int(order.ID)), orderAccessTokenTTL)
+}
+
+// findGuestOrder loads the order named by the token query parameter
+func findGuestOrder(ctx *gin.Context) (models.Order, bool) {
+	var order models.Order
+
+	orderID, err := utils.VerifyToken(orderAccessTokenPurpose, ctx.Query("token"))
+	if err != nil {
+		sendErrorResponse(ctx, http.StatusUnauthorized, msgInvalidOrderAccessToken)
+		return order, false
+	}
+
+	if err := initializers.DB.Preload("OrderItems").Preload("DeliveryAddress").Preload("TaxLines").Preload("Invoice").First(&order, orderID).Error; err != nil {
+		sendErrorResponse(ctx, http.StatusNotFound, "Order not found")
+		return order, false
+	}
+	return order, true
+}
+
+// priceOrderItems builds order items from the current product names and product or variant
+// prices. Products with active variants must be bought by one of them.
+func priceOrderItems(requested []models.OrderItem) ([]models.OrderItem, models.Money, error) {
+	productIDs := make([]int, 0, len(requested))
+	for _, item := range requested {
+		productIDs = append(productIDs, item.ProductId)
+	}
+
+	products, err := findProductsByID(productIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var items []models.OrderItem
+	var total models.Money
+	for _, item := range requested {
+		product, exists := products[item.ProductId]
+		if !exists {
+			return nil, 0, errors.New("product " + strconv.Itoa(item.ProductId) + " not found")
+		}
+		if item.Quantity < 1 {
+			return nil, 0, errors.New("invalid quantity for product " + strconv.Itoa(item.ProductId))
+		}
+
+		variant, err := findVariant(product, item.VariantID)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", product.Name, err)
+		}
+
+		price := unitPrice(product, variant)
+		sku, variantName := describeVariant(variant)
+		items = append(items, models.OrderItem{
+			ProductId:   item.ProductId,
+			VariantID:   item.VariantID,
+			SKU:         sku,
+			Name:        product.Name,
+			VariantName: variantName,
+			Price:       price,
+			Quantity:    item.Quantity,
+		})
+		total += price.Mul(item.Quantity)
+	}
+	return items, total, nil
+}
+
+// CreateGuestOrder creates an order for a buyer without an account and initiates payment
+func CreateGuestOrder(ctx *gin.Context) {
+	var guestOrderData struct {
+		FirstName        string             `json:"firstName" binding:"required"`
+		LastName         string             `json:"lastName" binding:"required"`
+		Email            string             `json:"email" binding:"required,email"`
+		Phone            string             `json:"phone" binding:"required"`
+		DeliveryLocation string             `json:"deliveryLocation" binding:"required"`
+		DeliveryZoneID   int                `json:"deliveryZoneId"`
+		CouponCode       string             `json:"couponCode"`
+		OrderItems       []models.OrderItem `json:"orderItems" binding:"required,min=1"`
+	}
+	if err := ctx.ShouldBindJSON(&guestOrderData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	orderInfo := models.Order{
+		FirstName:        guestOrderData.FirstName,
+		LastName:         guestOrderData.LastName,
+		Email:            guestOrderData.Email,
+		Phone:            guestOrderData.Phone,
+		DeliveryLocation: guestOrderData.DeliveryLocation,
+		DeliveryZoneID:   guestOrderData.DeliveryZoneID,
+		CouponCode:       guestOrderData.CouponCode,
+		OrderItems:       guestOrderData.OrderItems,
+	}
+
+	tx := initializers.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	order, err := createOrderRecord(tx, orderInfo)
+	if err != nil {
+		tx.Rollback()
+		sendOrderCreationError(ctx, err)
+		return
+	}
+
+	if err := queueOrderNotifications(tx, orderEventPlaced, order); err != nil {
+		tx.Rollback()
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to save order", err)
+		return
+	}
+
+	if err := queueOrderWebhook(tx, models.WebhookEventOrderCreated, order.ID, nil); err != nil {
+		tx.Rollback()
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to save order", err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to save order")
+		return
+	}
+	publishOrderEvent(orderStreamCreated, order)
+
+	requestOrderPayment(ctx, order, gin.H{"order_access_token": issueOrderAccessToken(order)})
+}
+
+// GetGuestOrder returns an order and its payment status to the holder of its access token
+func GetGuestOrder(ctx *gin.Context) {
+	order, ok := findGuestOrder(ctx)
+	if !ok {
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"order": order,
+	})
+}
+
+// PayGuestOrder retries payment for an unpaid guest order
+func PayGuestOrder(ctx *gin.Context) {
+	order, ok := findGuestOrder(ctx)
+	if !ok {
+		return
+	}
+
+	if order.PaymentStatus == "Completed" {
+		sendErrorResponse(ctx, http.StatusBadRequest, "Order already paid")
+		return
+	}
+	if order.Status == "Cancelled" {
+		sendErrorResponse(ctx, http.StatusBadRequest, "Cannot pay for cancelled order")
+		return
+	}
+
+	requestOrderPayment(ctx, order, nil)
+}
+
+// claimGuestOrders assigns orders placed as a guest with the given email to a user
+func claimGuestOrders(userID int, email string) (int64, error) {
+	result := initializers.DB.Model(&models.Order{}).
+		Where("user_id IS NULL AND email = ?", email).
+		Update("user_id", userID)
+	return result.RowsAffected, result.Error
+}
+
+// ClaimGuestOrders adds guest orders placed with the user's email to their account
+func ClaimGuestOrders(ctx *gin.Context) {
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var user models.User
+	if err := initializers.DB.First(&user, userID).Error; err != nil {
+		sendErrorResponse(ctx, http.StatusNotFound, "User not found")
+		return
+	}
+
+	// Only verified email addresses can claim orders
+	if !user.AccountActivated {
+		sendErrorResponse(ctx, http.StatusForbidden, msgAccountNotActivated)
+		return
+	}
+
+	claimed, err := claimGuestOrders(int(user.ID), user.Email)
+	if err != nil {
+		log.Println("Error claiming guest orders:", err)
+		sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to claim orders")
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message":       "Orders claimed successfully.",
+		"claimedOrders": claimed,
+	})
+}
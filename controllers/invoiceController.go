@@ -0,0 +1,348 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const msgInvoiceNotIssued = "An invoice is issued once the order has been paid"
+
+// invoiceSeries is the prefix of invoice numbers, e.g. INV-000123
+func invoiceSeries() string {
+	if series := os.Getenv("INVOICE_PREFIX"); series != "" {
+		return series
+	}
+	return "INV"
+}
+
+// issueInvoice gives a paid order the next invoice number within tx. The counter row is locked
+// and updated in the same transaction as the invoice is created, so numbers have no gaps even
+// when payment notifications arrive at the same time. The boolean is false when the order
+// already had an invoice.
+func issueInvoice(tx *gorm.DB, orderID uint, paymentMethod, confirmationCode string) (models.Invoice, bool, error) {
+	var invoice models.Invoice
+	created := false
+
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("order_id = ?", orderID).Limit(1).Find(&invoice)
+		if result.Error != nil || result.RowsAffected > 0 {
+			return result.Error
+		}
+
+		series := invoiceSeries()
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.InvoiceCounter{Series: series}).Error; err != nil {
+			return err
+		}
+		var counter models.InvoiceCounter
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&counter, "series = ?", series).Error; err != nil {
+			return err
+		}
+		counter.LastNumber++
+		if err := tx.Model(&counter).Update("last_number", counter.LastNumber).Error; err != nil {
+			return err
+		}
+
+		invoice = models.Invoice{
+			OrderID:          int(order.ID),
+			Series:           series,
+			Sequence:         counter.LastNumber,
+			Number:           fmt.Sprintf("%s-%06d", series, counter.LastNumber),
+			IssuedAt:         time.Now(),
+			PaymentReference: order.PesapalTrackingId,
+			PaymentMethod:    paymentMethod,
+			ConfirmationCode: confirmationCode,
+		}
+		created = true
+		return tx.Create(&invoice).Error
+	})
+	return invoice, created, err
+}
+
+type storeDetails struct {
+	Name, Address, Phone, Email, TaxPIN string
+}
+
+// invoiceStore reads the seller details printed on invoices from the environment
+func invoiceStore() storeDetails {
+	store := storeDetails{
+		Name:    os.Getenv("STORE_NAME"),
+		Address: os.Getenv("STORE_ADDRESS"),
+		Phone:   os.Getenv("STORE_PHONE"),
+		Email:   os.Getenv("STORE_EMAIL"),
+		TaxPIN:  os.Getenv("STORE_TAX_PIN"),
+	}
+	if store.Name == "" {
+		store.Name = "Amexan"
+	}
+	if store.Email == "" {
+		store.Email = os.Getenv("FROM_EMAIL")
+	}
+	return store
+}
+
+// fitText shortens text with an ellipsis so it fits in width
+func fitText(text string, width, size float64, bold bool) string {
+	if utils.TextWidth(text, size, bold) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && utils.TextWidth(string(runes)+"...", size, bold) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// renderInvoicePDF lays out the invoice for an order loaded with its items and tax lines
+func renderInvoicePDF(order models.Order, invoice models.Invoice) []byte {
+	const (
+		left       = 40.0
+		right      = utils.PDFPageWidth - 40
+		pageBottom = utils.PDFPageHeight - 60
+	)
+
+	store := invoiceStore()
+	currency := order.Currency
+	if currency == "" {
+		currency = models.DefaultCurrency
+	}
+
+	pdf := utils.NewPDFDocument()
+
+	// Seller and invoice details
+	pdf.Text(left, 60, 20, true, store.Name)
+	y := 78.0
+	for _, line := range []string{store.Address, store.Phone, store.Email} {
+		if line != "" {
+			pdf.Text(left, y, 9, false, line)
+			y += 12
+		}
+	}
+	if store.TaxPIN != "" {
+		pdf.Text(left, y, 9, false, "PIN: "+store.TaxPIN)
+	}
+
+	pdf.TextRight(right, 60, 14, true, "TAX INVOICE / RECEIPT")
+	pdf.TextRight(right, 78, 9, false, "Invoice No: "+invoice.Number)
+	pdf.TextRight(right, 90, 9, false, "Date: "+invoice.IssuedAt.Format("02 Jan 2006"))
+	pdf.TextRight(right, 102, 9, false, "Order: #"+strconv.Itoa(int(order.ID)))
+	pdf.TextRight(right, 114, 9, true, "PAID")
+
+	// Buyer details
+	y = 150
+	pdf.Text(left, y, 10, true, "Billed to")
+	y += 14
+	for _, line := range []string{order.FirstName + " " + order.LastName, order.Email, order.Phone, order.DeliveryLocation} {
+		if line != "" && line != " " {
+			pdf.Text(left, y, 9, false, fitText(line, 300, 9, false))
+			y += 12
+		}
+	}
+
+	// Line items
+	columns := []struct {
+		title string
+		x     float64
+	}{
+		{"Qty", 300}, {"Unit price", 375}, {"Discount", 440}, {"VAT %", 485}, {"Amount (" + currency + ")", right - 5},
+	}
+	itemsHeader := func(y float64) {
+		pdf.FillRect(left, y-12, right-left, 18, 0.9)
+		pdf.Text(left+5, y, 9, true, "Item")
+		for _, column := range columns {
+			pdf.TextRight(column.x, y, 9, true, column.title)
+		}
+	}
+
+	y += 20
+	itemsHeader(y)
+	y += 20
+	for _, item := range order.OrderItems {
+		if y > pageBottom {
+			pdf.AddPage()
+			y = 60
+			itemsHeader(y)
+			y += 20
+		}
+		amount := item.Price.Mul(item.Quantity) - item.Discount
+		pdf.Text(left+5, y, 9, false, fitText(orderItemName(item), 220, 9, false))
+		pdf.TextRight(columns[0].x, y, 9, false, strconv.Itoa(item.Quantity))
+		pdf.TextRight(columns[1].x, y, 9, false, item.Price.String())
+		pdf.TextRight(columns[2].x, y, 9, false, item.Discount.String())
+		pdf.TextRight(columns[3].x, y, 9, false, strconv.FormatFloat(item.TaxRate, 'f', -1, 64))
+		pdf.TextRight(columns[4].x, y, 9, false, amount.String())
+		pdf.Line(left, y+6, right, y+6, 0.5, 0.85)
+		y += 18
+	}
+
+	// Totals
+	totals := [][2]string{{"Subtotal", order.Subtotal.Format(currency)}}
+	if order.Discount > 0 {
+		label := "Discount"
+		if order.CouponCode != "" {
+			label += " (" + order.CouponCode + ")"
+		}
+		totals = append(totals, [2]string{label, "-" + order.Discount.Format(currency)})
+	}
+	if order.DeliveryZoneName != "" || order.DeliveryFee > 0 {
+		totals = append(totals, [2]string{"Delivery " + order.DeliveryZoneName, order.DeliveryFee.Format(currency)})
+	}
+	if order.PricesIncludeTax {
+		totals = append(totals, [2]string{"VAT included", order.TaxTotal.Format(currency)})
+	} else {
+		totals = append(totals, [2]string{"VAT", order.TaxTotal.Format(currency)})
+	}
+
+	if y+float64(len(totals)+1)*16 > pageBottom {
+		pdf.AddPage()
+		y = 60
+	}
+	y += 10
+	for _, total := range totals {
+		pdf.Text(340, y, 9, false, fitText(total[0], 120, 9, false))
+		pdf.TextRight(right-5, y, 9, false, total[1])
+		y += 16
+	}
+	pdf.Line(340, y-10, right, y-10, 0.75, 0)
+	y += 4
+	pdf.Text(340, y, 11, true, "Total paid")
+	pdf.TextRight(right-5, y, 11, true, order.Total.Format(currency))
+
+	// VAT breakdown
+	y += 36
+	if y+float64(len(order.TaxLines)+2)*16+70 > pageBottom {
+		pdf.AddPage()
+		y = 60
+	}
+	pdf.Text(left, y, 10, true, "VAT summary")
+	y += 18
+	pdf.FillRect(left, y-12, right-left, 18, 0.9)
+	pdf.Text(left+5, y, 9, true, "Tax class")
+	pdf.TextRight(300, y, 9, true, "Rate")
+	pdf.TextRight(440, y, 9, true, "Taxable amount")
+	pdf.TextRight(right-5, y, 9, true, "VAT")
+	y += 20
+	for _, line := range order.TaxLines {
+		pdf.Text(left+5, y, 9, false, fitText(line.Name, 200, 9, false))
+		pdf.TextRight(300, y, 9, false, strconv.FormatFloat(line.Rate, 'f', -1, 64)+"%")
+		pdf.TextRight(440, y, 9, false, line.TaxableAmount.String())
+		pdf.TextRight(right-5, y, 9, false, line.TaxAmount.String())
+		y += 16
+	}
+
+	// Payment
+	y += 20
+	pdf.Text(left, y, 10, true, "Payment")
+	y += 14
+	pdf.Text(left, y, 9, false, "Reference: "+invoice.PaymentReference)
+	if invoice.PaymentMethod != "" {
+		y += 12
+		pdf.Text(left, y, 9, false, "Method: "+invoice.PaymentMethod)
+	}
+	if invoice.ConfirmationCode != "" {
+		y += 12
+		pdf.Text(left, y, 9, false, "Confirmation code: "+invoice.ConfirmationCode)
+	}
+
+	pdf.Text(left, utils.PDFPageHeight-40, 8, false, "Thank you for shopping with "+store.Name+".")
+
+	return pdf.Bytes()
+}
+
+func invoiceFilename(invoice models.Invoice) string {
+	return invoice.Number + ".pdf"
+}
+
+// confirmOrderPayment issues the invoice for a paid order within tx and, the first time,
+// queues the order.paid webhook and the payment received notifications with the invoice
+// attached to the email
+func confirmOrderPayment(tx *gorm.DB, orderID uint, paymentMethod, confirmationCode string) error {
+	invoice, created, err := issueInvoice(tx, orderID, paymentMethod, confirmationCode)
+	if err != nil || !created {
+		return err
+	}
+
+	if err := queueOrderWebhook(tx, models.WebhookEventOrderPaid, orderID, nil); err != nil {
+		return err
+	}
+
+	var order models.Order
+	if err := tx.Preload("OrderItems").Preload("TaxLines").First(&order, orderID).Error; err != nil {
+		return err
+	}
+	return queueOrderNotifications(tx, orderEventPaymentReceived, order, utils.EmailAttachment{
+		Filename:    invoiceFilename(invoice),
+		ContentType: "application/pdf",
+		Content:     renderInvoicePDF(order, invoice),
+	})
+}
+
+func sendInvoicePDF(ctx *gin.Context, order models.Order) {
+	if order.Invoice == nil {
+		sendErrorResponse(ctx, http.StatusNotFound, msgInvoiceNotIssued)
+		return
+	}
+
+	ctx.Header("Content-Disposition", `attachment; filename="`+invoiceFilename(*order.Invoice)+`"`)
+	ctx.Data(http.StatusOK, "application/pdf", renderInvoicePDF(order, *order.Invoice))
+}
+
+// GetOrderInvoice downloads the invoice of an order for its owner or an admin
+func GetOrderInvoice(ctx *gin.Context) {
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	orderId, err := strconv.Atoi(ctx.Param("orderId"))
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to parse orderId")
+		return
+	}
+
+	var order models.Order
+	if err := initializers.DB.Preload("OrderItems").Preload("TaxLines").Preload("Invoice").First(&order, orderId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendErrorResponse(ctx, http.StatusNotFound, "Order not found")
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch order.", err)
+		}
+		return
+	}
+
+	// Other customers' orders are reported as missing rather than forbidden
+	if !order.PlacedBy(userID) && !isAdminUser(ctx) {
+		sendErrorResponse(ctx, http.StatusNotFound, "Order not found")
+		return
+	}
+
+	sendInvoicePDF(ctx, order)
+}
+
+// GetGuestOrderInvoice downloads the invoice of a guest order using its access token
+func GetGuestOrderInvoice(ctx *gin.Context) {
+	order, ok := findGuestOrder(ctx)
+	if !ok {
+		return
+	}
+
+	sendInvoicePDF(ctx, order)
+}
@@ -0,0 +1,945 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	newsletterConfirmTokenPurpose     = "newsletter-confirm"
+	newsletterConfirmTokenTTL         = 7 * 24 * time.Hour
+	newsletterConfirmResendInterval   = 10 * time.Minute
+	newsletterUnsubscribeTokenPurpose = "newsletter-unsubscribe"
+	newsletterUnsubscribeTokenTTL     = 5 * 365 * 24 * time.Hour
+
+	defaultNewsletterBatchSize     = 50
+	defaultNewsletterBatchInterval = time.Minute
+	newsletterSendingLease         = 10 * time.Minute
+
+	msgCampaignNotFound      = "Campaign not found"
+	msgNewsletterCheckEmail  = "Please check your email to confirm your subscription."
+	msgInvalidNewsletterLink = "Invalid or expired link"
+)
+
+// newsletterBatchSize is how many campaign emails are sent per batch, from NEWSLETTER_BATCH_SIZE
+func newsletterBatchSize() int {
+	if value := os.Getenv("NEWSLETTER_BATCH_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err == nil && size > 0 {
+			return size
+		}
+		log.Println("Invalid NEWSLETTER_BATCH_SIZE, using default:", value)
+	}
+	return defaultNewsletterBatchSize
+}
+
+// newsletterBatchInterval is the pause between batches, from NEWSLETTER_BATCH_INTERVAL, e.g. "1m"
+func newsletterBatchInterval() time.Duration {
+	if value := os.Getenv("NEWSLETTER_BATCH_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err == nil && interval > 0 {
+			return interval
+		}
+		log.Println("Invalid NEWSLETTER_BATCH_INTERVAL, using default:", value)
+	}
+	return defaultNewsletterBatchInterval
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// subscribeToNewsletter records a subscription request within tx. New and unsubscribed
+// addresses become pending until confirmed, confirmed subscribers are left as they are.
+func subscribeToNewsletter(tx *gorm.DB, email, name string, userID int, source string) (models.NewsletterSubscriber, error) {
+	var subscriber models.NewsletterSubscriber
+
+	result := tx.Where("email = ?", normalizeEmail(email)).Limit(1).Find(&subscriber)
+	if result.Error != nil {
+		return subscriber, result.Error
+	}
+
+	if subscriber.Name == "" {
+		subscriber.Name = name
+	}
+	if subscriber.UserID == 0 {
+		subscriber.UserID = userID
+	}
+	if subscriber.Status != models.SubscriberStatusSubscribed {
+		subscriber.Status = models.SubscriberStatusPending
+		subscriber.Source = source
+	}
+
+	if result.RowsAffected == 0 {
+		subscriber.Email = normalizeEmail(email)
+		return subscriber, tx.Create(&subscriber).Error
+	}
+	return subscriber, tx.Save(&subscriber).Error
+}
+
+// queueNewsletterConfirmation emails a pending subscriber a link to confirm their address
+func queueNewsletterConfirmation(tx *gorm.DB, subscriber models.NewsletterSubscriber) error {
+	token := utils.SignToken(newsletterConfirmTokenPurpose, strconv.Itoa(int(subscriber.ID)), newsletterConfirmTokenTTL)
+
+	emailData := utils.EmailData{
+		Name:            subscriber.Name,
+		Message:         "Please confirm that you would like to receive news and offers from Amexan by clicking the button below.",
+		VerificationURL: os.Getenv("FRONTEND_URL") + "/newsletter/confirm?token=" + url.QueryEscape(token),
+		LogoURL:         "https://www.amexan.store/images/logo.jpg",
+	}
+
+	templatePath := filepath.Join("templates", "newsletter_confirm.html")
+	return queueTemplatedEmail(tx, subscriber.Email, "Confirm your Amexan newsletter subscription", emailData, templatePath)
+}
+
+// confirmSubscriber marks a subscriber as confirmed and keeps the user's preference in step
+func confirmSubscriber(tx *gorm.DB, subscriber *models.NewsletterSubscriber) error {
+	now := time.Now()
+	subscriber.Status = models.SubscriberStatusSubscribed
+	subscriber.ConfirmedAt = &now
+	subscriber.UnsubscribedAt = nil
+	subscriber.UnsubscribedCampaignID = 0
+	if err := tx.Save(subscriber).Error; err != nil {
+		return err
+	}
+
+	if subscriber.UserID != 0 {
+		return tx.Model(&models.User{}).Where("id = ?", subscriber.UserID).Update("subscribe_to_news", true).Error
+	}
+	return nil
+}
+
+// confirmSignupSubscriber confirms the subscription a user asked for at signup. Activating the
+// account proves they own the email address, so no separate confirmation is needed.
+func confirmSignupSubscriber(user models.User) error {
+	return initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var subscriber models.NewsletterSubscriber
+		result := tx.Where("email = ? AND status = ?", normalizeEmail(user.Email), models.SubscriberStatusPending).Limit(1).Find(&subscriber)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		subscriber.UserID = int(user.ID)
+		return confirmSubscriber(tx, &subscriber)
+	})
+}
+
+// SubscribeToNewsletter starts a double opt-in subscription for any email address
+func SubscribeToNewsletter(ctx *gin.Context) {
+	var subscribeData struct {
+		Email string `json:"email" binding:"required,email"`
+		Name  string `json:"name"`
+	}
+	if err := ctx.ShouldBindJSON(&subscribeData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userID, _ := getAuthenticatedUserID(ctx)
+	if userID != 0 {
+		var user models.User
+		if err := initializers.DB.Select("id", "email").First(&user, userID).Error; err != nil || normalizeEmail(user.Email) != normalizeEmail(subscribeData.Email) {
+			userID = 0
+		}
+	}
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var existing models.NewsletterSubscriber
+		if err := tx.Where("email = ?", normalizeEmail(subscribeData.Email)).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+
+		subscriber, err := subscribeToNewsletter(tx, subscribeData.Email, subscribeData.Name, userID, "form")
+		if err != nil || subscriber.Status == models.SubscriberStatusSubscribed {
+			return err
+		}
+		// Pending addresses only get another confirmation once in a while, so the form can't
+		// be used to flood someone's inbox
+		if existing.Status == models.SubscriberStatusPending && time.Since(existing.UpdatedAt) < newsletterConfirmResendInterval {
+			return nil
+		}
+		return queueNewsletterConfirmation(tx, subscriber)
+	})
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to subscribe", err)
+		return
+	}
+
+	// The response is the same for existing subscribers so it doesn't reveal who is subscribed
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": msgNewsletterCheckEmail})
+}
+
+// ConfirmNewsletterSubscription completes a subscription from the link in the confirmation email
+func ConfirmNewsletterSubscription(ctx *gin.Context) {
+	subscriberID, err := utils.VerifyToken(newsletterConfirmTokenPurpose, ctx.Query("token"))
+	if err != nil {
+		sendErrorResponse(ctx, http.StatusBadRequest, msgInvalidNewsletterLink)
+		return
+	}
+
+	var subscriber models.NewsletterSubscriber
+	if err := initializers.DB.First(&subscriber, subscriberID).Error; err != nil {
+		sendErrorResponse(ctx, http.StatusBadRequest, msgInvalidNewsletterLink)
+		return
+	}
+
+	if subscriber.Status != models.SubscriberStatusSubscribed {
+		if err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+			return confirmSubscriber(tx, &subscriber)
+		}); err != nil {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to confirm subscription", err)
+			return
+		}
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Your subscription is confirmed."})
+}
+
+// unsubscribeToken identifies the subscriber and, for campaign emails, the campaign they unsubscribed from
+func unsubscribeToken(subscriberID uint, campaignID uint) string {
+	return utils.SignToken(newsletterUnsubscribeTokenPurpose, fmt.Sprintf("%d:%d", subscriberID, campaignID), newsletterUnsubscribeTokenTTL)
+}
+
+// findUnsubscribingSubscriber reads an unsubscribe link's token, returning the subscriber and
+// the campaign the link was in
+func findUnsubscribingSubscriber(ctx *gin.Context) (models.NewsletterSubscriber, int, bool) {
+	var subscriber models.NewsletterSubscriber
+
+	value, err := utils.VerifyToken(newsletterUnsubscribeTokenPurpose, ctx.Query("token"))
+	if err != nil {
+		sendErrorResponse(ctx, http.StatusBadRequest, msgInvalidNewsletterLink)
+		return subscriber, 0, false
+	}
+
+	subscriberPart, campaignPart, _ := strings.Cut(value, ":")
+	subscriberID, _ := strconv.Atoi(subscriberPart)
+	campaignID, _ := strconv.Atoi(campaignPart)
+
+	if err := initializers.DB.First(&subscriber, subscriberID).Error; err != nil {
+		sendErrorResponse(ctx, http.StatusBadRequest, msgInvalidNewsletterLink)
+		return subscriber, 0, false
+	}
+	return subscriber, campaignID, true
+}
+
+// GetNewsletterUnsubscribe shows who an unsubscribe link is for so the storefront can ask
+// them to confirm. It changes nothing, as link scanners and mail prefetchers open links.
+func GetNewsletterUnsubscribe(ctx *gin.Context) {
+	subscriber, _, ok := findUnsubscribingSubscriber(ctx)
+	if !ok {
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"email":        subscriber.Email,
+		"unsubscribed": subscriber.Status == models.SubscriberStatusUnsubscribed,
+		"message":      "Confirm to stop receiving our newsletter.",
+	})
+}
+
+// UnsubscribeFromNewsletter unsubscribes the holder of an unsubscribe link, once they confirm
+// on the storefront or with one-click unsubscribe from the mail client
+func UnsubscribeFromNewsletter(ctx *gin.Context) {
+	subscriber, campaignID, ok := findUnsubscribingSubscriber(ctx)
+	if !ok {
+		return
+	}
+
+	if subscriber.Status != models.SubscriberStatusUnsubscribed {
+		err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&subscriber).Updates(map[string]any{
+				"status":                   models.SubscriberStatusUnsubscribed,
+				"unsubscribed_at":          time.Now(),
+				"unsubscribed_campaign_id": campaignID,
+			}).Error; err != nil {
+				return err
+			}
+			if subscriber.UserID != 0 {
+				return tx.Model(&models.User{}).Where("id = ?", subscriber.UserID).Update("subscribe_to_news", false).Error
+			}
+			return nil
+		})
+		if err != nil {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to unsubscribe", err)
+			return
+		}
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "You have been unsubscribed."})
+}
+
+func GetNewsletterSubscribers(ctx *gin.Context) {
+	var subscribers []models.NewsletterSubscriber
+
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "15"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 15
+	}
+	offset := (page - 1) * limit
+
+	query := initializers.DB.Model(&models.NewsletterSubscriber{})
+	if status := ctx.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if search := ctx.Query("search"); search != "" {
+		query = query.Where("email LIKE ?", "%"+search+"%")
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch subscribers", err)
+		return
+	}
+	if err := query.Order("created_at desc").Limit(limit).Offset(offset).Find(&subscribers).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch subscribers", err)
+		return
+	}
+
+	previousPage := page - 1
+	nextPage := page + 1
+	totalPages := math.Ceil(float64(count) / float64(limit))
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"subscribers": subscribers,
+		"metadata": gin.H{
+			"total":        count,
+			"currentPage":  page,
+			"limit":        limit,
+			"hasPrevPage":  previousPage > 0,
+			"hasNextPage":  int(totalPages) > page,
+			"previousPage": previousPage,
+			"nextPage":     nextPage,
+		},
+	})
+}
+
+// campaignAudience selects the confirmed subscribers a campaign is sent to
+func campaignAudience(db *gorm.DB, campaign models.NewsletterCampaign) *gorm.DB {
+	query := db.Model(&models.NewsletterSubscriber{}).Where("status = ?", models.SubscriberStatusSubscribed)
+
+	// A subscriber's orders are those placed with their email or by their account
+	const paidOrders = `SELECT 1 FROM orders
+		WHERE orders.payment_status = 'Completed' AND orders.deleted_at IS NULL
+		AND (orders.email = newsletter_subscribers.email
+			OR (newsletter_subscribers.user_id <> 0 AND orders.user_id = newsletter_subscribers.user_id))`
+	const paidOrderProducts = `SELECT 1 FROM orders
+		JOIN order_items ON order_items.order_id = orders.id AND order_items.deleted_at IS NULL
+		JOIN products ON products.id = order_items.product_id
+		WHERE orders.payment_status = 'Completed' AND orders.deleted_at IS NULL
+		AND (orders.email = newsletter_subscribers.email
+			OR (newsletter_subscribers.user_id <> 0 AND orders.user_id = newsletter_subscribers.user_id))`
+
+	switch campaign.Segment {
+	case models.SegmentCustomers:
+		query = query.Where("EXISTS (" + paidOrders + ")")
+	case models.SegmentCategory:
+		tree, err := loadCategoryTree(db)
+		if err != nil {
+			query.AddError(err)
+			return query
+		}
+		category, exists := findSegmentCategory(tree, campaign.SegmentValue)
+		if !exists {
+			query.AddError(fmt.Errorf("category %s not found", campaign.SegmentValue))
+			return query
+		}
+		query = query.Where("EXISTS ("+paidOrderProducts+" AND products.category_id IN ?)", tree.descendantIDs(category.ID))
+	case models.SegmentBrand:
+		brand, err := findSegmentBrand(db, campaign.SegmentValue)
+		if err != nil {
+			query.AddError(err)
+			return query
+		}
+		query = query.Where("EXISTS ("+paidOrderProducts+" AND products.brand_id = ?)", brand.ID)
+	}
+	return query
+}
+
+// findSegmentCategory finds the category of a category segment by id, slug or, for campaigns
+// saved before categories had ids, name
+func findSegmentCategory(tree categoryTree, value string) (models.Category, bool) {
+	if category, exists := tree.findByRef(value); exists {
+		return category, true
+	}
+	for _, category := range tree.byID {
+		if strings.EqualFold(category.Name, value) {
+			return category, true
+		}
+	}
+	return models.Category{}, false
+}
+
+// findSegmentBrand finds the brand of a brand segment by id, slug or name
+func findSegmentBrand(db *gorm.DB, value string) (models.Brand, error) {
+	brand, err := findBrandByRef(db, value)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.Where("name = ?", value).First(&brand).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return brand, fmt.Errorf("brand %s not found", value)
+	}
+	return brand, err
+}
+
+type newsletterTemplateData struct {
+	Name           string
+	Email          string
+	UnsubscribeURL string
+}
+
+type newsletterLayoutData struct {
+	Subject        string
+	Content        template.HTML
+	Email          string
+	UnsubscribeURL string
+	LogoURL        string
+}
+
+// campaignTemplates are a campaign's parsed HTML and optional plain text templates
+type campaignTemplates struct {
+	html *template.Template
+	text *texttemplate.Template
+}
+
+func parseCampaign(campaign models.NewsletterCampaign) (campaignTemplates, error) {
+	var templates campaignTemplates
+	var err error
+
+	if templates.html, err = template.New("body").Parse(campaign.Body); err != nil {
+		return templates, err
+	}
+	if campaign.TextBody != "" {
+		if templates.text, err = texttemplate.New("text").Parse(campaign.TextBody); err != nil {
+			return templates, err
+		}
+	}
+	return templates, nil
+}
+
+// renderCampaign renders a campaign for one subscriber, wrapped in the newsletter layout
+func renderCampaign(campaign models.NewsletterCampaign, templates campaignTemplates, subscriber models.NewsletterSubscriber, unsubscribeURL string) (string, string, error) {
+	data := newsletterTemplateData{
+		Name:           subscriber.Name,
+		Email:          subscriber.Email,
+		UnsubscribeURL: unsubscribeURL,
+	}
+
+	var content bytes.Buffer
+	if err := templates.html.Execute(&content, data); err != nil {
+		return "", "", err
+	}
+
+	htmlBody, _, err := utils.RenderEmail(newsletterLayoutData{
+		Subject:        campaign.Subject,
+		Content:        template.HTML(content.String()),
+		Email:          subscriber.Email,
+		UnsubscribeURL: unsubscribeURL,
+		LogoURL:        "https://www.amexan.store/images/logo.jpg",
+	}, filepath.Join("templates", "newsletter.html"), "")
+	if err != nil {
+		return "", "", err
+	}
+
+	if templates.text == nil {
+		return htmlBody, "", nil
+	}
+	var textBody bytes.Buffer
+	if err := templates.text.Execute(&textBody, data); err != nil {
+		return "", "", err
+	}
+	textBody.WriteString("\n\nUnsubscribe: " + unsubscribeURL + "\n")
+	return htmlBody, textBody.String(), nil
+}
+
+func validateCampaignData(campaign *models.NewsletterCampaign) error {
+	if campaign.Segment == "" {
+		campaign.Segment = models.SegmentAll
+	}
+	if (campaign.Segment == models.SegmentCategory || campaign.Segment == models.SegmentBrand) && campaign.SegmentValue == "" {
+		return errors.New("segmentValue is required for the " + campaign.Segment + " segment")
+	}
+
+	// Segments are saved by id so they survive renames
+	switch campaign.Segment {
+	case models.SegmentCategory:
+		tree, err := loadCategoryTree(initializers.DB)
+		if err != nil {
+			return err
+		}
+		category, exists := findSegmentCategory(tree, campaign.SegmentValue)
+		if !exists {
+			return fmt.Errorf("category %s not found", campaign.SegmentValue)
+		}
+		campaign.SegmentValue = strconv.Itoa(int(category.ID))
+	case models.SegmentBrand:
+		brand, err := findSegmentBrand(initializers.DB, campaign.SegmentValue)
+		if err != nil {
+			return err
+		}
+		campaign.SegmentValue = strconv.Itoa(int(brand.ID))
+	}
+	if _, err := parseCampaign(*campaign); err != nil {
+		return err
+	}
+	return nil
+}
+
+// campaignStats counts each campaign's deliveries by status and the unsubscribes it caused
+func campaignStats(campaignIDs []uint) (map[uint]gin.H, error) {
+	stats := make(map[uint]gin.H, len(campaignIDs))
+	for _, id := range campaignIDs {
+		stats[id] = gin.H{
+			"recipients":   int64(0),
+			"pending":      int64(0),
+			"sent":         int64(0),
+			"failed":       int64(0),
+			"skipped":      int64(0),
+			"unsubscribed": int64(0),
+		}
+	}
+	if len(campaignIDs) == 0 {
+		return stats, nil
+	}
+
+	var deliveryCounts []struct {
+		CampaignID uint
+		Status     string
+		Count      int64
+	}
+	if err := initializers.DB.Model(&models.NewsletterDelivery{}).
+		Select("campaign_id, status, COUNT(*) AS count").
+		Where("campaign_id IN ?", campaignIDs).
+		Group("campaign_id, status").
+		Scan(&deliveryCounts).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range deliveryCounts {
+		status := row.Status
+		if status == models.DeliveryStatusSending {
+			status = models.DeliveryStatusPending
+		}
+		stats[row.CampaignID][status] = stats[row.CampaignID][status].(int64) + row.Count
+		stats[row.CampaignID]["recipients"] = stats[row.CampaignID]["recipients"].(int64) + row.Count
+	}
+
+	var unsubscribeCounts []struct {
+		UnsubscribedCampaignID uint
+		Count                  int64
+	}
+	if err := initializers.DB.Model(&models.NewsletterSubscriber{}).
+		Select("unsubscribed_campaign_id, COUNT(*) AS count").
+		Where("unsubscribed_campaign_id IN ?", campaignIDs).
+		Group("unsubscribed_campaign_id").
+		Scan(&unsubscribeCounts).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range unsubscribeCounts {
+		stats[row.UnsubscribedCampaignID]["unsubscribed"] = row.Count
+	}
+
+	return stats, nil
+}
+
+func findCampaign(ctx *gin.Context) (models.NewsletterCampaign, bool) {
+	var campaign models.NewsletterCampaign
+
+	campaignId, err := strconv.Atoi(ctx.Param("campaignId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid campaign ID", err)
+		return campaign, false
+	}
+
+	if err := initializers.DB.First(&campaign, campaignId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, msgCampaignNotFound, nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Unable to retrieve campaign", err)
+		}
+		return campaign, false
+	}
+	return campaign, true
+}
+
+func GetNewsletterCampaigns(ctx *gin.Context) {
+	var campaigns []models.NewsletterCampaign
+	if err := initializers.DB.Order("created_at desc").Find(&campaigns).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch campaigns", err)
+		return
+	}
+
+	ids := make([]uint, 0, len(campaigns))
+	for _, campaign := range campaigns {
+		ids = append(ids, campaign.ID)
+	}
+	stats, err := campaignStats(ids)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch campaign stats", err)
+		return
+	}
+
+	results := make([]gin.H, 0, len(campaigns))
+	for _, campaign := range campaigns {
+		results = append(results, gin.H{"campaign": campaign, "stats": stats[campaign.ID]})
+	}
+	ctx.JSON(http.StatusOK, gin.H{"campaigns": results})
+}
+
+func GetNewsletterCampaign(ctx *gin.Context) {
+	campaign, ok := findCampaign(ctx)
+	if !ok {
+		return
+	}
+
+	stats, err := campaignStats([]uint{campaign.ID})
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch campaign stats", err)
+		return
+	}
+
+	response := gin.H{"campaign": campaign, "stats": stats[campaign.ID]}
+	if campaign.Status == models.CampaignStatusDraft {
+		var audienceSize int64
+		if err := campaignAudience(initializers.DB, campaign).Count(&audienceSize).Error; err != nil {
+			respondWithError(ctx, http.StatusInternalServerError, "Unable to count audience", err)
+			return
+		}
+		response["audienceSize"] = audienceSize
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func CreateNewsletterCampaign(ctx *gin.Context) {
+	var campaign models.NewsletterCampaign
+	if err := ctx.ShouldBindJSON(&campaign); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := validateCampaignData(&campaign); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid campaign", err)
+		return
+	}
+	campaign.ID = 0
+	campaign.Status = models.CampaignStatusDraft
+	campaign.StartedAt = nil
+	campaign.CompletedAt = nil
+
+	if err := initializers.DB.Create(&campaign).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create campaign", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, campaign)
+}
+
+func UpdateNewsletterCampaign(ctx *gin.Context) {
+	campaign, ok := findCampaign(ctx)
+	if !ok {
+		return
+	}
+	if campaign.Status != models.CampaignStatusDraft {
+		sendErrorResponse(ctx, http.StatusConflict, "Only draft campaigns can be edited")
+		return
+	}
+
+	var updateData models.NewsletterCampaign
+	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if err := validateCampaignData(&updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid campaign", err)
+		return
+	}
+
+	if err := initializers.DB.Model(&campaign).
+		Select("Subject", "Body", "TextBody", "Segment", "SegmentValue").
+		Updates(updateData).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to update campaign", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message":  "Campaign updated successfully",
+		"campaign": campaign,
+	})
+}
+
+func DeleteNewsletterCampaign(ctx *gin.Context) {
+	campaign, ok := findCampaign(ctx)
+	if !ok {
+		return
+	}
+	if campaign.Status == models.CampaignStatusSending {
+		sendErrorResponse(ctx, http.StatusConflict, "A campaign cannot be deleted while it is being sent")
+		return
+	}
+
+	if err := initializers.DB.Delete(&campaign).Error; err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to delete campaign.")
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Campaign deleted successfully."})
+}
+
+// PreviewNewsletterCampaign renders a campaign for a sample subscriber without sending it
+func PreviewNewsletterCampaign(ctx *gin.Context) {
+	campaign, ok := findCampaign(ctx)
+	if !ok {
+		return
+	}
+
+	templates, err := parseCampaign(campaign)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid campaign", err)
+		return
+	}
+
+	subscriber := models.NewsletterSubscriber{Name: "Jane", Email: "jane@example.com"}
+	htmlBody, textBody, err := renderCampaign(campaign, templates, subscriber, os.Getenv("FRONTEND_URL")+"/newsletter/unsubscribe?token=preview")
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Failed to render campaign", err)
+		return
+	}
+
+	if ctx.Query("format") == "text" {
+		ctx.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(textBody))
+		return
+	}
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(htmlBody))
+}
+
+// SendNewsletterCampaign queues a draft campaign for its audience. The newsletter worker then
+// sends it in batches.
+func SendNewsletterCampaign(ctx *gin.Context) {
+	campaign, ok := findCampaign(ctx)
+	if !ok {
+		return
+	}
+
+	var recipients int64
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the campaign so it can't be queued twice
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&campaign, campaign.ID).Error; err != nil {
+			return err
+		}
+		if campaign.Status != models.CampaignStatusDraft {
+			return errCampaignAlreadySent
+		}
+
+		var batch []models.NewsletterSubscriber
+		err := campaignAudience(tx, campaign).Select("id", "email").FindInBatches(&batch, 500, func(_ *gorm.DB, _ int) error {
+			deliveries := make([]models.NewsletterDelivery, 0, len(batch))
+			for _, subscriber := range batch {
+				deliveries = append(deliveries, models.NewsletterDelivery{
+					CampaignID:   int(campaign.ID),
+					SubscriberID: int(subscriber.ID),
+					Email:        subscriber.Email,
+					Status:       models.DeliveryStatusPending,
+				})
+			}
+			recipients += int64(len(deliveries))
+			return tx.Create(&deliveries).Error
+		}).Error
+		if err != nil {
+			return err
+		}
+		if recipients == 0 {
+			return errEmptyAudience
+		}
+
+		now := time.Now()
+		campaign.Status = models.CampaignStatusSending
+		campaign.StartedAt = &now
+		return tx.Model(&campaign).Updates(map[string]any{"status": campaign.Status, "started_at": now}).Error
+	})
+	switch {
+	case errors.Is(err, errCampaignAlreadySent):
+		sendErrorResponse(ctx, http.StatusConflict, "Campaign has already been sent")
+		return
+	case errors.Is(err, errEmptyAudience):
+		sendErrorResponse(ctx, http.StatusBadRequest, "No subscribers match this campaign's audience")
+		return
+	case err != nil:
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to send campaign", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message":    "Campaign is being sent.",
+		"recipients": recipients,
+	})
+}
+
+var (
+	errCampaignAlreadySent = errors.New("campaign already sent")
+	errEmptyAudience       = errors.New("campaign audience is empty")
+)
+
+// claimNewsletterDeliveries takes the next batch of a sending campaign's emails. Claims expire
+// after a while so emails are retried if the worker stops part way through a batch.
+func claimNewsletterDeliveries(campaignID uint) ([]models.NewsletterDelivery, error) {
+	var deliveries []models.NewsletterDelivery
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("campaign_id = ?", campaignID).
+			Where("status = ? OR (status = ? AND updated_at < ?)", models.DeliveryStatusPending, models.DeliveryStatusSending, time.Now().Add(-newsletterSendingLease)).
+			Order("id asc").
+			Limit(newsletterBatchSize()).
+			Find(&deliveries).Error; err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+		return tx.Model(&models.NewsletterDelivery{}).Where("id IN ?", ids).Update("status", models.DeliveryStatusSending).Error
+	})
+	return deliveries, err
+}
+
+// newsletterUnsubscribeHeaders lets mail clients offer one-click unsubscribe. One-click needs
+// the API's own URL in API_URL, without it the header points at the storefront page.
+func newsletterUnsubscribeHeaders(token, unsubscribeURL string) map[string]string {
+	if apiURL := os.Getenv("API_URL"); apiURL != "" {
+		return map[string]string{
+			"List-Unsubscribe":      "<" + apiURL + "/newsletter/unsubscribe?token=" + url.QueryEscape(token) + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+	return map[string]string{"List-Unsubscribe": "<" + unsubscribeURL + ">"}
+}
+
+// sendNewsletterBatch sends the next batch of the oldest campaign being sent
+func sendNewsletterBatch() {
+	var campaign models.NewsletterCampaign
+	result := initializers.DB.Where("status = ?", models.CampaignStatusSending).Order("started_at asc").Limit(1).Find(&campaign)
+	if result.Error != nil {
+		log.Println("Error finding newsletter campaign:", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	deliveries, err := claimNewsletterDeliveries(campaign.ID)
+	if err != nil {
+		log.Println("Error claiming newsletter deliveries:", err)
+		return
+	}
+
+	if len(deliveries) == 0 {
+		var remaining int64
+		if err := initializers.DB.Model(&models.NewsletterDelivery{}).
+			Where("campaign_id = ? AND status IN ?", campaign.ID, []string{models.DeliveryStatusPending, models.DeliveryStatusSending}).
+			Count(&remaining).Error; err != nil || remaining > 0 {
+			return
+		}
+		initializers.DB.Model(&campaign).Updates(map[string]any{
+			"status":       models.CampaignStatusSent,
+			"completed_at": time.Now(),
+		})
+		log.Printf("Newsletter campaign %d sent", campaign.ID)
+		return
+	}
+
+	templates, err := parseCampaign(campaign)
+	if err != nil {
+		log.Printf("Newsletter campaign %d has invalid templates: %v", campaign.ID, err)
+		return
+	}
+
+	subscriberIDs := make([]int, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		subscriberIDs = append(subscriberIDs, delivery.SubscriberID)
+	}
+	var subscribers []models.NewsletterSubscriber
+	if err := initializers.DB.Where("id IN ?", subscriberIDs).Find(&subscribers).Error; err != nil {
+		log.Println("Error loading newsletter subscribers:", err)
+		return
+	}
+	subscribersByID := make(map[int]models.NewsletterSubscriber, len(subscribers))
+	for _, subscriber := range subscribers {
+		subscribersByID[int(subscriber.ID)] = subscriber
+	}
+
+	for _, delivery := range deliveries {
+		updates := map[string]any{}
+
+		// People who unsubscribed after the campaign was queued are skipped
+		subscriber, exists := subscribersByID[delivery.SubscriberID]
+		if !exists || subscriber.Status != models.SubscriberStatusSubscribed {
+			updates["status"] = models.DeliveryStatusSkipped
+		} else if err := sendCampaignEmail(campaign, templates, subscriber); err != nil {
+			log.Printf("Error sending campaign %d to %s: %v", campaign.ID, subscriber.Email, err)
+			updates["status"] = models.DeliveryStatusFailed
+			updates["error"] = err.Error()
+		} else {
+			updates["status"] = models.DeliveryStatusSent
+			updates["sent_at"] = time.Now()
+		}
+
+		if err := initializers.DB.Model(&models.NewsletterDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error; err != nil {
+			log.Println("Error recording newsletter delivery:", err)
+		}
+	}
+}
+
+func sendCampaignEmail(campaign models.NewsletterCampaign, templates campaignTemplates, subscriber models.NewsletterSubscriber) error {
+	token := unsubscribeToken(subscriber.ID, campaign.ID)
+	unsubscribeURL := os.Getenv("FRONTEND_URL") + "/newsletter/unsubscribe?token=" + url.QueryEscape(token)
+
+	htmlBody, textBody, err := renderCampaign(campaign, templates, subscriber, unsubscribeURL)
+	if err != nil {
+		return err
+	}
+
+	return utils.DefaultMailer().Send(utils.EmailMessage{
+		To:       subscriber.Email,
+		Subject:  campaign.Subject,
+		HTMLBody: htmlBody,
+		TextBody: textBody,
+		Headers:  newsletterUnsubscribeHeaders(token, unsubscribeURL),
+	})
+}
+
+// StartNewsletterWorker sends campaigns in throttled batches in the background
+func StartNewsletterWorker() {
+	go func() {
+		ticker := time.NewTicker(newsletterBatchInterval())
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sendNewsletterBatch()
+		}
+	}()
+}
@@ -2,18 +2,21 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Kariqs/amexan-api/initializers"
 	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/go-resty/resty/v2"
+	"gorm.io/gorm"
 )
 
 func GetPesapalAccessToken() (string, error) {
@@ -57,76 +60,187 @@ func GetPesapalAccessToken() (string, error) {
 	return token, nil
 }
 
-func CreateOrder(ctx *gin.Context) {
-	var orderInfo models.Order
-	if err := ctx.ShouldBindJSON(&orderInfo); err != nil {
-		sendErrorResponse(ctx, http.StatusBadRequest, "Invalid request body")
-		return
+var (
+	errInsufficientStock = errors.New("insufficient stock")
+	errInvalidOrderItems = errors.New("invalid order items")
+)
+
+// createOrderRecord saves a new pending order and its items within tx, applying the
+// order's coupon, delivery fee and taxes and reserving stock for products that track inventory.
+// Items are priced from the catalogue, the prices and total sent by the client are ignored.
+// orderInfo.UserID must be the signed in user, or nil for guests, never a value from the body.
+func createOrderRecord(tx *gorm.DB, orderInfo models.Order) (models.Order, error) {
+	order := models.Order{
+		UserID:           orderInfo.UserID,
+		FirstName:        orderInfo.FirstName,
+		LastName:         orderInfo.LastName,
+		Email:            orderInfo.Email,
+		Phone:            orderInfo.Phone,
+		DeliveryLocation: orderInfo.DeliveryLocation,
+		Currency:         models.DefaultCurrency,
+		Status:           "Pending",
+		PaymentStatus:    "Pending",
+	}
+
+	if len(orderInfo.OrderItems) == 0 {
+		return order, fmt.Errorf("%w: the order has no items", errInvalidOrderItems)
+	}
+	items, subtotal, err := priceOrderItems(orderInfo.OrderItems)
+	if err != nil {
+		return order, fmt.Errorf("%w: %v", errInvalidOrderItems, err)
+	}
+	orderInfo.OrderItems = items // the coupon is worked out on the catalogue prices
+	order.Subtotal = subtotal
+	order.Total = order.Subtotal
+
+	// A saved address replaces the contact and delivery details sent with the order
+	var address models.Address
+	if orderInfo.AddressID != 0 {
+		var err error
+		if address, err = applyAddress(tx, &order, orderInfo.CustomerID(), orderInfo.AddressID); err != nil {
+			return order, err
+		}
 	}
 
-	var order models.Order
+	// Phone numbers are stored in E.164 where possible so orders can be found by them
+	if phone, err := utils.NormalizeKenyanPhone(order.Phone); err == nil {
+		order.Phone = phone
+	}
 
-	if orderInfo.ID != 0 {
-		// Handle existing order
-		if err := initializers.DB.First(&order, orderInfo.ID).Error; err != nil {
-			sendErrorResponse(ctx, http.StatusNotFound, "Order not found")
-			return
+	var coupon models.Coupon
+	if orderInfo.CouponCode != "" {
+		var itemDiscounts []models.Money
+		var discount models.Money
+		var err error
+		coupon, itemDiscounts, discount, err = applyCoupon(tx, orderInfo)
+		if err != nil {
+			return order, err
 		}
-		if order.UserID != orderInfo.UserID {
-			sendErrorResponse(ctx, http.StatusForbidden, "Access denied")
-			return
+		for i := range items {
+			items[i].Discount = itemDiscounts[i]
 		}
-		if order.PaymentStatus == "Completed" {
-			sendErrorResponse(ctx, http.StatusBadRequest, "Order already paid")
-			return
-		}
-		if order.Status == "Cancelled" {
-			sendErrorResponse(ctx, http.StatusBadRequest, "Cannot pay for cancelled order")
-			return
+
+		order.CouponCode = coupon.Code
+		order.Discount = discount
+		order.FreeDelivery = coupon.Type == models.CouponTypeFreeDelivery
+		order.Total = max(order.Total-discount, 0)
+	}
+
+	// Every order is delivered to a zone, free delivery coupons only waive its fee
+	if orderInfo.DeliveryZoneID == 0 {
+		return order, errDeliveryZoneRequired
+	}
+	if err := applyDeliveryZone(tx, &order, orderInfo.DeliveryZoneID); err != nil {
+		return order, err
+	}
+
+	taxLines, err := calculateOrderTaxes(tx, &order, items)
+	if err != nil {
+		return order, err
+	}
+
+	if err := tx.Create(&order).Error; err != nil {
+		return order, err
+	}
+
+	if address.ID != 0 {
+		if err := saveOrderAddress(tx, &order, address); err != nil {
+			return order, err
 		}
-	} else {
-		// Create new order in transaction
-		tx := initializers.DB.Begin()
-		defer func() {
-			if r := recover(); r != nil {
-				tx.Rollback()
-			}
-		}()
+	}
 
-		order = models.Order{
-			UserID:           orderInfo.UserID,
-			FirstName:        orderInfo.FirstName,
-			LastName:         orderInfo.LastName,
-			Email:            orderInfo.Email,
-			Phone:            orderInfo.Phone,
-			DeliveryLocation: orderInfo.DeliveryLocation,
-			Total:            orderInfo.Total,
-			Status:           "Pending",
-			PaymentStatus:    "Pending",
+	for _, item := range items {
+		if err := reserveStock(tx, item.ProductId, item.VariantID, item.Quantity); err != nil {
+			return order, err
 		}
 
-		if err := tx.Create(&order).Error; err != nil {
-			tx.Rollback()
-			sendErrorResponse(ctx, http.StatusBadRequest, "Failed to create order")
-			return
+		item.ID = 0
+		item.OrderID = int(order.ID)
+		if err := tx.Create(&item).Error; err != nil {
+			return order, err
 		}
+		order.OrderItems = append(order.OrderItems, item)
+	}
 
-		for _, item := range orderInfo.OrderItems {
-			item.OrderID = int(order.ID)
-			if err := tx.Create(&item).Error; err != nil {
-				tx.Rollback()
-				sendErrorResponse(ctx, http.StatusBadRequest, "Failed to create order items")
-				return
-			}
+	for _, taxLine := range taxLines {
+		taxLine.OrderID = int(order.ID)
+		if err := tx.Create(&taxLine).Error; err != nil {
+			return order, err
 		}
+		order.TaxLines = append(order.TaxLines, taxLine)
+	}
 
-		if err := tx.Commit().Error; err != nil {
-			sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to save order")
-			return
+	if coupon.ID != 0 {
+		if err := redeemCoupon(tx, coupon, order); err != nil {
+			return order, err
 		}
 	}
 
-	// Prepare and send payment request to Pesapal
+	return order, nil
+}
+
+// sendOrderCreationError responds with the reason createOrderRecord failed
+func sendOrderCreationError(ctx *gin.Context, err error) {
+	var couponErr *couponError
+	switch {
+	case errors.Is(err, errInsufficientStock):
+		sendErrorResponse(ctx, http.StatusConflict, "Some items are out of stock")
+	case errors.As(err, &couponErr):
+		sendErrorResponse(ctx, http.StatusBadRequest, couponErr.Error())
+	case errors.Is(err, errInvalidOrderItems), errors.Is(err, errInvalidDeliveryZone), errors.Is(err, errDeliveryZoneRequired),
+		errors.Is(err, errAddressNotFound), errors.Is(err, errVariantNotFound):
+		sendErrorResponse(ctx, http.StatusBadRequest, err.Error())
+	default:
+		log.Println("Order creation error:", err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to create order")
+	}
+}
+
+// stockHolder is the model that keeps the stock of an item: its variant when it was bought by
+// variant, otherwise its product
+func stockHolder(productID, variantID int) (any, int) {
+	if variantID != 0 {
+		return &models.ProductVariant{}, variantID
+	}
+	return &models.Product{}, productID
+}
+
+// reserveStock decrements stock for products, or variants, that track inventory
+func reserveStock(tx *gorm.DB, productID, variantID, quantity int) error {
+	model, id := stockHolder(productID, variantID)
+
+	var tracked int64
+	if err := tx.Model(model).Where("id = ? AND stock IS NOT NULL", id).Count(&tracked).Error; err != nil {
+		return err
+	}
+	if tracked == 0 {
+		return nil
+	}
+
+	result := tx.Model(model).
+		Where("id = ? AND stock >= ?", id, quantity).
+		Update("stock", gorm.Expr("stock - ?", quantity))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errInsufficientStock
+	}
+	return nil
+}
+
+// releaseStock puts stock back for a product, or variant, that tracks inventory, reporting whether it did
+func releaseStock(tx *gorm.DB, productID, variantID, quantity int) (bool, error) {
+	model, id := stockHolder(productID, variantID)
+	result := tx.Model(model).
+		Where("id = ? AND stock IS NOT NULL", id).
+		Update("stock", gorm.Expr("stock + ?", quantity))
+	return result.RowsAffected > 0, result.Error
+}
+
+// requestOrderPayment submits the order to Pesapal and responds with the payment redirect.
+// Any extra fields are added to the success response.
+func requestOrderPayment(ctx *gin.Context, order models.Order, extra gin.H) {
 	token, err := GetPesapalAccessToken()
 	if err != nil {
 		sendErrorResponse(ctx, http.StatusInternalServerError, "Payment authentication failed")
@@ -139,9 +253,14 @@ func CreateOrder(ctx *gin.Context) {
 		return
 	}
 
+	currency := order.Currency
+	if currency == "" {
+		currency = models.DefaultCurrency
+	}
+
 	pesapalOrder := map[string]any{
 		"id":              fmt.Sprintf("ORDER-%d", order.ID),
-		"currency":        "KES",
+		"currency":        currency,
 		"amount":          order.Total,
 		"description":     fmt.Sprintf("Payment for order #%d", order.ID),
 		"callback_url":    "https://amexan.store/paymentstatus",
@@ -193,14 +312,92 @@ func CreateOrder(ctx *gin.Context) {
 		"updated_at":          time.Now(),
 	}).Error
 
-	sendJSONResponse(ctx, http.StatusOK, gin.H{
+	response := gin.H{
 		"message":           "Order processed successfully. Redirect user to payment.",
 		"redirect_url":      redirectURL,
 		"order_id":          order.ID,
 		"order_tracking_id": orderTrackingID,
-	})
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	sendJSONResponse(ctx, http.StatusOK, response)
 }
 
+func CreateOrder(ctx *gin.Context) {
+	var orderInfo models.Order
+	if err := ctx.ShouldBindJSON(&orderInfo); err != nil {
+		sendErrorResponse(ctx, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// The order belongs to the signed in user, whatever userId the body names
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	orderInfo.UserID = &userID
+
+	var order models.Order
+
+	if orderInfo.ID != 0 {
+		// Handle existing order
+		if err := initializers.DB.First(&order, orderInfo.ID).Error; err != nil {
+			sendErrorResponse(ctx, http.StatusNotFound, "Order not found")
+			return
+		}
+		if !order.PlacedBy(userID) {
+			sendErrorResponse(ctx, http.StatusForbidden, "Access denied")
+			return
+		}
+		if order.PaymentStatus == "Completed" {
+			sendErrorResponse(ctx, http.StatusBadRequest, "Order already paid")
+			return
+		}
+		if order.Status == "Cancelled" {
+			sendErrorResponse(ctx, http.StatusBadRequest, "Cannot pay for cancelled order")
+			return
+		}
+	} else {
+		// Create new order in transaction
+		tx := initializers.DB.Begin()
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+			}
+		}()
+
+		var err error
+		order, err = createOrderRecord(tx, orderInfo)
+		if err != nil {
+			tx.Rollback()
+			sendOrderCreationError(ctx, err)
+			return
+		}
+
+		if err := queueOrderNotifications(tx, orderEventPlaced, order); err != nil {
+			tx.Rollback()
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to save order", err)
+			return
+		}
+
+		if err := queueOrderWebhook(tx, models.WebhookEventOrderCreated, order.ID, nil); err != nil {
+			tx.Rollback()
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to save order", err)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to save order")
+			return
+		}
+		publishOrderEvent(orderStreamCreated, order)
+	}
+
+	// Prepare and send payment request to Pesapal
+	requestOrderPayment(ctx, order, nil)
+}
 
 func HandlePesapalIPN(ctx *gin.Context) {
 	var trackingId, merchantRef string
@@ -272,13 +469,50 @@ func HandlePesapalIPN(ctx *gin.Context) {
 	// Extract payment status description
 	statusDesc := fmt.Sprint(statusResp["payment_status_description"])
 
-	// Update the local order record with the new payment status
-	if err := initializers.DB.Model(&models.Order{}).
-		Where("pesapal_tracking_id = ?", trackingId).
-		Update("payment_status", statusDesc).Error; err != nil {
+	var order models.Order
+	if err := initializers.DB.Where("pesapal_tracking_id = ?", trackingId).Limit(1).Find(&order).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
 		return
 	}
+	previousStatus := order.PaymentStatus
+
+	// Update the local order record with the new payment status. Pesapal repeats notifications,
+	// so customers are only emailed when the status changes. Completed payments are always
+	// confirmed as the invoice and its email are only issued once.
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Order{}).
+			Where("pesapal_tracking_id = ?", trackingId).
+			Update("payment_status", statusDesc).Error; err != nil {
+			return err
+		}
+		if order.ID == 0 {
+			return nil
+		}
+
+		switch {
+		case statusDesc == "Completed":
+			return confirmOrderPayment(tx, order.ID, pesapalString(statusResp["payment_method"]), pesapalString(statusResp["confirmation_code"]))
+		case statusDesc == previousStatus:
+			return nil
+		case statusDesc == "Failed" || statusDesc == "Invalid":
+			return queueOrderNotificationsByID(tx, orderEventPaymentFailed, order.ID)
+		case statusDesc == "Reversed":
+			if err := queueOrderWebhook(tx, models.WebhookEventOrderRefunded, order.ID, nil); err != nil {
+				return err
+			}
+			return queueOrderNotificationsByID(tx, orderEventRefunded, order.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error updating payment status:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+		return
+	}
+	if order.ID != 0 && statusDesc != previousStatus {
+		order.PaymentStatus = statusDesc
+		publishOrderEvent(orderStreamPaymentStatus, order)
+	}
 
 	// Return the expected response for a successful IPN notification
 	ctx.JSON(http.StatusOK, gin.H{
@@ -289,6 +523,14 @@ func HandlePesapalIPN(ctx *gin.Context) {
 	})
 }
 
+// pesapalString reads an optional string field from a Pesapal response
+func pesapalString(value any) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
 func CheckPaymentStatus(ctx *gin.Context) {
 	trackingId := ctx.Query("OrderTrackingId")
 
@@ -314,57 +556,6 @@ func CheckPaymentStatus(ctx *gin.Context) {
 
 }
 
-func GetOrders(ctx *gin.Context) {
-	var orders []models.Order
-
-	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "15"))
-	offset := (page - 1) * limit
-
-	sortOrder := ctx.DefaultQuery("sort", "desc")
-	if sortOrder != "asc" && sortOrder != "desc" {
-		sortOrder = "desc"
-	}
-
-	query := initializers.DB.Preload("OrderItems")
-
-	if search := ctx.Query("search"); search != "" {
-		query = query.Where("ID LIKE ?", "%"+search+"%")
-	}
-
-	query = query.Order("created_at " + sortOrder)
-
-	result := query.Limit(limit).Offset(offset).Find(&orders)
-	if result.Error != nil {
-		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch orders", result.Error)
-		return
-	}
-
-	var count int64
-	countQuery := initializers.DB.Model(&models.Order{})
-	if search := ctx.Query("search"); search != "" {
-		countQuery = countQuery.Where("id LIKE ?", "%"+search+"%")
-	}
-	countQuery.Count(&count)
-
-	previousPage := page - 1
-	nextPage := page + 1
-	totalPages := math.Ceil(float64(count) / float64(limit))
-
-	ctx.JSON(http.StatusOK, gin.H{
-		"orders": orders,
-		"metadata": gin.H{
-			"total":        count,
-			"currentPage":  page,
-			"limit":        limit,
-			"hasPrevPage":  previousPage > 0,
-			"hasNextPage":  int(totalPages) > page,
-			"previousPage": previousPage,
-			"nextPage":     nextPage,
-		},
-	})
-}
-
 func GetOderByCustomerId(ctx *gin.Context) {
 	userId, err := strconv.Atoi(ctx.Param("userId"))
 	if err != nil {
@@ -378,7 +569,7 @@ func GetOderByCustomerId(ctx *gin.Context) {
 		sortOrder = "desc"
 	}
 
-	query := initializers.DB.Preload("OrderItems").Where("user_id = ?", userId)
+	query := initializers.DB.Preload("OrderItems").Preload("DeliveryAddress").Preload("TaxLines").Preload("Invoice").Where("user_id = ?", userId)
 
 	if search := ctx.Query("search"); search != "" {
 		query = query.Where("id LIKE ?", "%"+search+"%")
@@ -405,7 +596,7 @@ func GetOderById(ctx *gin.Context) {
 	}
 
 	var order models.Order
-	if result := initializers.DB.Preload("OrderItems").Where("id = ?", orderId).Find(&order); result.Error != nil {
+	if result := initializers.DB.Preload("OrderItems").Preload("DeliveryAddress").Preload("TaxLines").Preload("Invoice").Where("id = ?", orderId).Find(&order); result.Error != nil {
 		log.Println(result.Error)
 		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to fetch order.")
 		return
@@ -433,11 +624,50 @@ func UpdateOrderStatus(ctx *gin.Context) {
 		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to parse orderId")
 		return
 	}
-	if result := initializers.DB.Model(&models.Order{}).Where("id = ?", orderId).Update("status", orderStatusData.Status); result.Error != nil {
-		log.Println(result.Error)
+
+	var order models.Order
+	if err := initializers.DB.First(&order, orderId).Error; err != nil {
+		sendErrorResponse(ctx, http.StatusNotFound, "Order not found")
+		return
+	}
+	previousStatus := order.Status
+
+	event, hasEvent := orderStatusEvent(orderStatusData.Status)
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]any{"status": orderStatusData.Status}
+		// The delivery date starts the return window
+		if event == orderEventDelivered && order.DeliveredAt == nil {
+			updates["delivered_at"] = time.Now()
+		}
+		if err := tx.Model(&order).Updates(updates).Error; err != nil {
+			return err
+		}
+		if strings.EqualFold(previousStatus, orderStatusData.Status) {
+			return nil
+		}
+
+		if err := queueOrderWebhook(tx, models.WebhookEventOrderStatusChanged, order.ID, gin.H{"previousStatus": previousStatus}); err != nil {
+			return err
+		}
+		if !hasEvent {
+			return nil
+		}
+		if event == orderEventRefunded {
+			if err := queueOrderWebhook(tx, models.WebhookEventOrderRefunded, order.ID, nil); err != nil {
+				return err
+			}
+		}
+		return queueOrderNotificationsByID(tx, event, order.ID)
+	})
+	if err != nil {
+		log.Println(err)
 		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to update order status")
 		return
 	}
+	if !strings.EqualFold(previousStatus, orderStatusData.Status) {
+		publishOrderEvent(orderStreamStatus, order)
+	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Order status updated successfully.",
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Order lifecycle events that customers are emailed about
+const (
+	orderEventPlaced          = "order_placed"
+	orderEventPaymentReceived = "payment_received"
+	orderEventPaymentFailed   = "payment_failed"
+	orderEventDispatched      = "order_dispatched"
+	orderEventDelivered       = "order_delivered"
+	orderEventCancelled       = "order_cancelled"
+	orderEventRefunded        = "order_refunded"
+	orderEventReturnApproved  = "return_approved"
+	orderEventReturnRejected  = "return_rejected"
+	orderEventReturnRefunded  = "return_refunded"
+)
+
+type orderEmail struct {
+	Subject    string
+	Heading    string
+	Message    string // formatted with the order number
+	ButtonText string
+}
+
+var orderEmails = map[string]orderEmail{
+	orderEventPlaced: {
+		Subject:    "We've received your order #%d",
+		Heading:    "Thank you for your order",
+		Message:    "We have received your order #%d and will start preparing it as soon as payment is confirmed.",
+		ButtonText: "Track Order",
+	},
+	orderEventPaymentReceived: {
+		Subject:    "Payment received for order #%d",
+		Heading:    "Payment received",
+		Message:    "We have received your payment for order #%d. Your invoice is attached to this email.",
+		ButtonText: "View Order",
+	},
+	orderEventPaymentFailed: {
+		Subject:    "Payment for order #%d was not successful",
+		Heading:    "Payment not successful",
+		Message:    "We could not complete the payment for order #%d. You have not been charged, you can try paying again from your order.",
+		ButtonText: "Retry Payment",
+	},
+	orderEventDispatched: {
+		Subject:    "Order #%d is on its way",
+		Heading:    "Your order is on its way",
+		Message:    "Good news! Order #%d has been dispatched and is on its way to you.",
+		ButtonText: "Track Order",
+	},
+	orderEventDelivered: {
+		Subject:    "Order #%d has been delivered",
+		Heading:    "Your order has been delivered",
+		Message:    "Order #%d has been delivered. We hope you enjoy your purchase.",
+		ButtonText: "View Order",
+	},
+	orderEventCancelled: {
+		Subject:    "Order #%d has been cancelled",
+		Heading:    "Your order has been cancelled",
+		Message:    "Order #%d has been cancelled. If you have already paid, your refund will be processed shortly.",
+		ButtonText: "View Order",
+	},
+	orderEventRefunded: {
+		Subject:    "Refund for order #%d",
+		Heading:    "Your refund has been processed",
+		Message:    "The payment for order #%d has been refunded. It may take a few days to reflect in your account.",
+		ButtonText: "View Order",
+	},
+	orderEventReturnApproved: {
+		Subject:    "Your return for order #%d has been approved",
+		Heading:    "Return approved",
+		Message:    "Your return request for order #%d has been approved. Please send the items back or hand them to our rider, you will be refunded once we have checked them.",
+		ButtonText: "View Order",
+	},
+	orderEventReturnRejected: {
+		Subject:    "Your return for order #%d was not approved",
+		Heading:    "Return not approved",
+		Message:    "We are sorry, your return request for order #%d was not approved. You can see the reason on your order.",
+		ButtonText: "View Order",
+	},
+	orderEventReturnRefunded: {
+		Subject:    "Refund for your return on order #%d",
+		Heading:    "Your return has been refunded",
+		Message:    "We have refunded the items you returned from order #%d. It may take a few days to reflect in your account.",
+		ButtonText: "View Order",
+	},
+}
+
+// orderStatusEvent maps an order status set by an admin to the email it triggers
+func orderStatusEvent(status string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "dispatched", "shipped":
+		return orderEventDispatched, true
+	case "delivered", "completed":
+		return orderEventDelivered, true
+	case "cancelled", "canceled":
+		return orderEventCancelled, true
+	case "refunded":
+		return orderEventRefunded, true
+	}
+	return "", false
+}
+
+type orderEmailLine struct {
+	Name     string
+	Quantity int
+	Amount   string
+}
+
+type orderEmailTotal struct {
+	Label  string
+	Amount string
+}
+
+type orderEmailData struct {
+	Name       string
+	Heading    string
+	Message    string
+	Note       string
+	ButtonURL  string
+	ButtonText string
+	LogoURL    string
+	OrderID    uint
+	Items      []orderEmailLine
+	Totals     []orderEmailTotal
+	Total      string
+}
+
+// orderURL links to the order on the storefront. Guests get a link with an access token.
+func orderURL(order models.Order) string {
+	if order.IsGuest() {
+		return os.Getenv("FRONTEND_URL") + "/orders/track?token=" + url.QueryEscape(issueOrderAccessToken(order))
+	}
+	return os.Getenv("FRONTEND_URL") + "/orders/" + strconv.Itoa(int(order.ID))
+}
+
+// buildOrderEmail fills in the subject and template data of an event email for an order loaded with its items
+func buildOrderEmail(event string, order models.Order) (string, orderEmailData, error) {
+	email, exists := orderEmails[event]
+	if !exists {
+		return "", orderEmailData{}, fmt.Errorf("unknown order email %q", event)
+	}
+
+	currency := order.Currency
+	data := orderEmailData{
+		Name:       order.FirstName,
+		Heading:    email.Heading,
+		Message:    fmt.Sprintf(email.Message, order.ID),
+		ButtonURL:  orderURL(order),
+		ButtonText: email.ButtonText,
+		LogoURL:    "https://www.amexan.store/images/logo.jpg",
+		OrderID:    order.ID,
+		Total:      order.Total.Format(currency),
+	}
+	if order.IsGuest() && event == orderEventPlaced {
+		data.Note = "Keep this email, the link above is the only way to view this order without an account. Sign up with this email address to add the order to your account."
+	}
+
+	for _, item := range order.OrderItems {
+		data.Items = append(data.Items, orderEmailLine{
+			Name:     orderItemName(item),
+			Quantity: item.Quantity,
+			Amount:   (item.Price.Mul(item.Quantity) - item.Discount).Format(currency),
+		})
+	}
+
+	data.Totals = append(data.Totals, orderEmailTotal{"Subtotal", order.Subtotal.Format(currency)})
+	if order.Discount > 0 {
+		data.Totals = append(data.Totals, orderEmailTotal{"Discount", "-" + order.Discount.Format(currency)})
+	}
+	if order.DeliveryZoneName != "" || order.DeliveryFee > 0 {
+		data.Totals = append(data.Totals, orderEmailTotal{"Delivery", order.DeliveryFee.Format(currency)})
+	}
+	if order.PricesIncludeTax {
+		data.Totals = append(data.Totals, orderEmailTotal{"VAT included", order.TaxTotal.Format(currency)})
+	} else {
+		data.Totals = append(data.Totals, orderEmailTotal{"VAT", order.TaxTotal.Format(currency)})
+	}
+
+	return fmt.Sprintf(email.Subject, order.ID), data, nil
+}
+
+func renderOrderEmail(data orderEmailData) (string, string, error) {
+	return utils.RenderEmail(data, filepath.Join("templates", "order_email.html"), filepath.Join("templates", "order_email.txt"))
+}
+
+// orderRecipient is the email address on the order, or that of the customer who placed it
+func orderRecipient(db *gorm.DB, order models.Order) string {
+	if order.Email != "" || order.IsGuest() {
+		return order.Email
+	}
+
+	var user models.User
+	if err := db.Select("email").First(&user, *order.UserID).Error; err != nil {
+		return ""
+	}
+	return user.Email
+}
+
+// queueOrderEmail adds the email for an event on an order loaded with its items to the outbox within tx
+func queueOrderEmail(tx *gorm.DB, event string, order models.Order, attachments ...utils.EmailAttachment) error {
+	recipient := orderRecipient(tx, order)
+	if recipient == "" {
+		log.Printf("Not sending %s email for order %d, it has no email address", event, order.ID)
+		return nil
+	}
+
+	subject, data, err := buildOrderEmail(event, order)
+	if err != nil {
+		return err
+	}
+	htmlBody, textBody, err := renderOrderEmail(data)
+	if err != nil {
+		return err
+	}
+
+	return queueEmail(tx, recipient, subject, htmlBody, textBody, attachments...)
+}
+
+// queueOrderNotifications queues the email and, for events that have one, the text message
+// for an event on an order loaded with its items
+func queueOrderNotifications(tx *gorm.DB, event string, order models.Order, attachments ...utils.EmailAttachment) error {
+	if err := queueOrderEmail(tx, event, order, attachments...); err != nil {
+		return err
+	}
+	return queueOrderSMS(tx, event, order)
+}
+
+// queueOrderNotificationsByID loads an order with its items within tx and queues its notifications
+func queueOrderNotificationsByID(tx *gorm.DB, event string, orderID uint) error {
+	var order models.Order
+	if err := tx.Preload("OrderItems").First(&order, orderID).Error; err != nil {
+		return err
+	}
+	return queueOrderNotifications(tx, event, order)
+}
+
+// sampleOrder is used to preview emails when no order is given
+func sampleOrder() models.Order {
+	order := models.Order{
+		FirstName:        "Jane",
+		LastName:         "Doe",
+		Email:            "jane@example.com",
+		Currency:         models.DefaultCurrency,
+		DeliveryZoneName: "Nairobi CBD",
+		DeliveryFee:      models.NewMoney(200),
+		Subtotal:         models.NewMoney(4500),
+		Discount:         models.NewMoney(450),
+		TaxTotal:         models.NewMoney(586),
+		PricesIncludeTax: true,
+		Total:            models.NewMoney(4250),
+		OrderItems: []models.OrderItem{
+			{Name: "Sample product", Price: models.NewMoney(1500), Quantity: 2, Discount: models.NewMoney(300)},
+			{Name: "Another sample product", Price: models.NewMoney(1500), Quantity: 1, Discount: models.NewMoney(150)},
+		},
+	}
+	userID := 1
+	order.ID = 1001
+	order.UserID = &userID
+	return order
+}
+
+// PreviewOrderEmail renders an order email without sending it, using orderId or a sample order
+func PreviewOrderEmail(ctx *gin.Context) {
+	order := sampleOrder()
+	if orderId := ctx.Query("orderId"); orderId != "" {
+		if err := initializers.DB.Preload("OrderItems").First(&order, orderId).Error; err != nil {
+			sendErrorResponse(ctx, http.StatusNotFound, "Order not found")
+			return
+		}
+	}
+
+	subject, data, err := buildOrderEmail(ctx.Param("event"), order)
+	if err != nil {
+		sendErrorResponse(ctx, http.StatusNotFound, "Unknown email event")
+		return
+	}
+	htmlBody, textBody, err := renderOrderEmail(data)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to render email", err)
+		return
+	}
+
+	ctx.Header("X-Email-Subject", subject)
+	if ctx.Query("format") == "text" {
+		ctx.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(textBody))
+		return
+	}
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(htmlBody))
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	exportBatchSize  = 500
+	exportTimeFormat = "2006-01-02 15:04:05"
+
+	exportRowsOrders = "orders" // one row per order
+	exportRowsItems  = "items"  // one row per order item, repeating its order's columns
+)
+
+// exportColumn is a column that can be exported. item is nil when exporting one row per order.
+type exportColumn struct {
+	Header   string
+	ItemOnly bool
+	Value    func(order models.Order, item *models.OrderItem) any
+}
+
+func orderInvoice(order models.Order) models.Invoice {
+	if order.Invoice == nil {
+		return models.Invoice{}
+	}
+	return *order.Invoice
+}
+
+func orderCustomerName(order models.Order) string {
+	return strings.TrimSpace(order.FirstName + " " + order.LastName)
+}
+
+var exportColumns = map[string]exportColumn{
+	"orderId":          {"Order ID", false, func(o models.Order, _ *models.OrderItem) any { return o.ID }},
+	"createdAt":        {"Date", false, func(o models.Order, _ *models.OrderItem) any { return o.CreatedAt }},
+	"status":           {"Status", false, func(o models.Order, _ *models.OrderItem) any { return o.Status }},
+	"paymentStatus":    {"Payment Status", false, func(o models.Order, _ *models.OrderItem) any { return o.PaymentStatus }},
+	"customer":         {"Customer", false, func(o models.Order, _ *models.OrderItem) any { return orderCustomerName(o) }},
+	"email":            {"Email", false, func(o models.Order, _ *models.OrderItem) any { return o.Email }},
+	"phone":            {"Phone", false, func(o models.Order, _ *models.OrderItem) any { return o.Phone }},
+	"deliveryZone":     {"Delivery Zone", false, func(o models.Order, _ *models.OrderItem) any { return o.DeliveryZoneName }},
+	"currency":         {"Currency", false, func(o models.Order, _ *models.OrderItem) any { return o.Currency }},
+	"subtotal":         {"Subtotal", false, func(o models.Order, _ *models.OrderItem) any { return o.Subtotal }},
+	"discount":         {"Discount", false, func(o models.Order, _ *models.OrderItem) any { return o.Discount }},
+	"couponCode":       {"Coupon", false, func(o models.Order, _ *models.OrderItem) any { return o.CouponCode }},
+	"deliveryFee":      {"Delivery Fee", false, func(o models.Order, _ *models.OrderItem) any { return o.DeliveryFee }},
+	"taxTotal":         {"VAT", false, func(o models.Order, _ *models.OrderItem) any { return o.TaxTotal }},
+	"total":            {"Total", false, func(o models.Order, _ *models.OrderItem) any { return o.Total }},
+	"invoiceNumber":    {"Invoice", false, func(o models.Order, _ *models.OrderItem) any { return orderInvoice(o).Number }},
+	"paymentMethod":    {"Payment Method", false, func(o models.Order, _ *models.OrderItem) any { return orderInvoice(o).PaymentMethod }},
+	"paymentReference": {"Payment Reference", false, func(o models.Order, _ *models.OrderItem) any { return orderInvoice(o).ConfirmationCode }},
+	"productId":        {"Product ID", true, func(_ models.Order, i *models.OrderItem) any { return i.ProductId }},
+	"item":             {"Item", true, func(_ models.Order, i *models.OrderItem) any { return i.Name }},
+	"variantId":        {"Variant ID", true, func(_ models.Order, i *models.OrderItem) any { return i.VariantID }},
+	"sku":              {"SKU", true, func(_ models.Order, i *models.OrderItem) any { return i.SKU }},
+	"variant":          {"Variant", true, func(_ models.Order, i *models.OrderItem) any { return i.VariantName }},
+	"quantity":         {"Quantity", true, func(_ models.Order, i *models.OrderItem) any { return i.Quantity }},
+	"unitPrice":        {"Unit Price", true, func(_ models.Order, i *models.OrderItem) any { return i.Price }},
+	"itemDiscount":     {"Item Discount", true, func(_ models.Order, i *models.OrderItem) any { return i.Discount }},
+	"taxRate":          {"VAT Rate", true, func(_ models.Order, i *models.OrderItem) any { return i.TaxRate }},
+	"itemTax":          {"Item VAT", true, func(_ models.Order, i *models.OrderItem) any { return i.TaxAmount }},
+	"lineTotal":        {"Line Total", true, func(_ models.Order, i *models.OrderItem) any { return i.Price.Mul(i.Quantity) - i.Discount }},
+}
+
+var defaultExportColumns = map[string][]string{
+	exportRowsOrders: {"orderId", "createdAt", "invoiceNumber", "customer", "email", "status", "paymentStatus", "subtotal", "discount", "deliveryFee", "taxTotal", "total"},
+	exportRowsItems:  {"orderId", "createdAt", "invoiceNumber", "customer", "productId", "sku", "item", "variant", "quantity", "unitPrice", "itemDiscount", "itemTax", "lineTotal"},
+}
+
+// exportWriter writes rows in one of the export formats
+type exportWriter interface {
+	WriteHeader(titles []string) error
+	WriteRow(values []any) error
+	Flush() error
+	Close() error
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvExportWriter) WriteHeader(titles []string) error {
+	return w.writer.Write(titles)
+}
+
+func (w *csvExportWriter) WriteRow(values []any) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = csvValue(value)
+	}
+	return w.writer.Write(record)
+}
+
+func (w *csvExportWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvExportWriter) Close() error {
+	return w.Flush()
+}
+
+// csvValue formats a cell, times in the shop's time zone like the date filters
+func csvValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		// Values that a spreadsheet would run as a formula are prefixed with a quote
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) && !isSignedNumber(v) {
+			return "'" + v
+		}
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.In(eastAfricaTime).Format(exportTimeFormat)
+	case models.Money:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// isSignedNumber reports whether a value like a phone number starts with + or - followed by digits
+func isSignedNumber(value string) bool {
+	if len(value) < 2 || (value[0] != '+' && value[0] != '-') {
+		return false
+	}
+	return strings.Trim(value[1:], "0123456789 .") == ""
+}
+
+// xlsxExportWriter writes amounts as numbers so they can be added up in the spreadsheet, and
+// times in the shop's time zone like the date filters
+type xlsxExportWriter struct {
+	*utils.XLSXWriter
+}
+
+func (w xlsxExportWriter) WriteRow(values []any) error {
+	for i, value := range values {
+		switch v := value.(type) {
+		case models.Money:
+			values[i] = float64(v) / 100
+		case time.Time:
+			values[i] = v.In(eastAfricaTime)
+		}
+	}
+	return w.XLSXWriter.WriteRow(values)
+}
+
+// ExportOrders streams the orders matching the order list filters as CSV or XLSX (format).
+// rows is "items" (the default) for a row per order item or "orders" for a row per order, and
+// columns is a comma separated list of column names to pick which columns are exported.
+func ExportOrders(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		sendErrorResponse(ctx, http.StatusBadRequest, "format must be csv or xlsx")
+		return
+	}
+	rows := ctx.DefaultQuery("rows", exportRowsItems)
+	if rows != exportRowsItems && rows != exportRowsOrders {
+		sendErrorResponse(ctx, http.StatusBadRequest, "rows must be items or orders")
+		return
+	}
+
+	columnNames := queryList(ctx, "columns")
+	if len(columnNames) == 0 {
+		columnNames = defaultExportColumns[rows]
+	}
+	columns := make([]exportColumn, 0, len(columnNames))
+	headers := make([]string, 0, len(columnNames))
+	for _, name := range columnNames {
+		column, exists := exportColumns[name]
+		if !exists {
+			sendErrorResponse(ctx, http.StatusBadRequest, "Unknown column "+name)
+			return
+		}
+		if column.ItemOnly && rows == exportRowsOrders {
+			sendErrorResponse(ctx, http.StatusBadRequest, "Column "+name+" can only be exported with rows=items")
+			return
+		}
+		columns = append(columns, column)
+		headers = append(headers, column.Header)
+	}
+
+	query, err := filterOrders(ctx, initializers.DB.Model(&models.Order{}))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid filter", err)
+		return
+	}
+
+	filename := "orders-" + time.Now().Format("20060102-150405") + "." + format
+	ctx.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	ctx.Header("Cache-Control", "no-store")
+
+	var writer exportWriter
+	if format == "xlsx" {
+		ctx.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		xlsx, err := utils.NewXLSXWriter(ctx.Writer, "Orders")
+		if err != nil {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to start export", err)
+			return
+		}
+		writer = xlsxExportWriter{xlsx}
+	} else {
+		ctx.Header("Content-Type", "text/csv; charset=utf-8")
+		writer = &csvExportWriter{writer: csv.NewWriter(ctx.Writer)}
+	}
+	ctx.Status(http.StatusOK)
+
+	if err := writer.WriteHeader(headers); err != nil {
+		log.Println("Error writing order export:", err)
+		return
+	}
+
+	// Orders are loaded a batch at a time and written out before the next batch is read.
+	// Once the response has started errors can only be logged, the file will be cut short.
+	var batch []models.Order
+	result := query.Preload("OrderItems").Preload("Invoice").FindInBatches(&batch, exportBatchSize, func(_ *gorm.DB, _ int) error {
+		for _, order := range batch {
+			if rows == exportRowsOrders {
+				if err := writer.WriteRow(exportRow(columns, order, nil)); err != nil {
+					return err
+				}
+				continue
+			}
+			for i := range order.OrderItems {
+				if err := writer.WriteRow(exportRow(columns, order, &order.OrderItems[i])); err != nil {
+					return err
+				}
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		ctx.Writer.Flush()
+		return nil
+	})
+	if result.Error != nil {
+		log.Println("Error writing order export:", result.Error)
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Println("Error finishing order export:", err)
+	}
+}
+
+func exportRow(columns []exportColumn, order models.Order, item *models.OrderItem) []any {
+	values := make([]any, len(columns))
+	for i, column := range columns {
+		values[i] = column.Value(order, item)
+	}
+	return values
+}
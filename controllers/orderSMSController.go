@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"gorm.io/gorm"
+)
+
+// orderSMS holds the text message sent for order events, only the most important ones have one
+var orderSMS = map[string]*template.Template{
+	orderEventPlaced: template.Must(template.New(orderEventPlaced).Parse(
+		"Amexan: Hi {{.Name}}, we've received your order #{{.OrderID}} of {{.Total}}. Track it at {{.URL}}")),
+	orderEventPaymentReceived: template.Must(template.New(orderEventPaymentReceived).Parse(
+		"Amexan: Payment of {{.Total}} received for order #{{.OrderID}}. Thank you for shopping with us!")),
+	orderEventDispatched: template.Must(template.New(orderEventDispatched).Parse(
+		"Amexan: Your order #{{.OrderID}} has been dispatched and is on its way. Track it at {{.URL}}")),
+}
+
+type orderSMSData struct {
+	Name    string
+	OrderID uint
+	Total   string
+	URL     string
+}
+
+func renderOrderSMS(event string, order models.Order) (string, bool, error) {
+	smsTemplate, exists := orderSMS[event]
+	if !exists {
+		return "", false, nil
+	}
+
+	var body strings.Builder
+	err := smsTemplate.Execute(&body, orderSMSData{
+		Name:    order.FirstName,
+		OrderID: order.ID,
+		Total:   order.Total.Format(order.Currency),
+		URL:     orderURL(order),
+	})
+	return body.String(), true, err
+}
+
+// orderSMSRecipient is the phone number on the order, or that of the customer who placed it,
+// in E.164 format. It is empty when the customer opted out of SMS or the number is not valid.
+func orderSMSRecipient(db *gorm.DB, order models.Order) string {
+	phone := order.Phone
+	if !order.IsGuest() {
+		var user models.User
+		if err := db.Select("phone", "sms_opt_out").First(&user, *order.UserID).Error; err != nil {
+			return ""
+		}
+		if user.SMSOptOut {
+			return ""
+		}
+		if phone == "" {
+			phone = user.Phone
+		}
+	}
+	if phone == "" {
+		return ""
+	}
+
+	normalized, err := utils.NormalizeKenyanPhone(phone)
+	if err != nil {
+		log.Printf("Not sending SMS for order %d, invalid phone number %q", order.ID, phone)
+		return ""
+	}
+	return normalized
+}
+
+// queueOrderSMS adds the text message for an event on an order to the SMS outbox within tx.
+// Events without a text message are ignored.
+func queueOrderSMS(tx *gorm.DB, event string, order models.Order) error {
+	if !utils.SMSEnabled() {
+		return nil
+	}
+
+	body, exists, err := renderOrderSMS(event, order)
+	if err != nil || !exists {
+		return err
+	}
+
+	recipient := orderSMSRecipient(tx, order)
+	if recipient == "" {
+		return nil
+	}
+	return queueSMS(tx, recipient, body)
+}
@@ -0,0 +1,328 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultOrderSearchLimit = 15
+	maxOrderSearchLimit     = 100
+)
+
+// Fields admins can sort orders by and their columns. Ties are broken by id.
+var orderSortColumns = map[string]string{
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+	"total":     "total",
+	"id":        "id",
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// orderCursor marks the last order of a page: its value of the sort column and its id
+type orderCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    uint   `json:"id"`
+}
+
+func encodeOrderCursor(sortBy string, order models.Order) string {
+	cursor := orderCursor{Sort: sortBy, ID: order.ID}
+	switch sortBy {
+	case "createdAt":
+		cursor.Value = order.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "updatedAt":
+		cursor.Value = order.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	case "total":
+		cursor.Value = strconv.FormatInt(int64(order.Total), 10)
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeOrderCursor reads a cursor and the sort value it holds, which must be for sortBy
+func decodeOrderCursor(encoded, sortBy string) (orderCursor, any, error) {
+	var cursor orderCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, nil, errInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sortBy || cursor.ID == 0 {
+		return cursor, nil, errInvalidCursor
+	}
+
+	switch sortBy {
+	case "createdAt", "updatedAt":
+		value, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return cursor, nil, errInvalidCursor
+		}
+		return cursor, value, nil
+	case "total":
+		value, err := strconv.ParseInt(cursor.Value, 10, 64)
+		if err != nil {
+			return cursor, nil, errInvalidCursor
+		}
+		return cursor, value, nil
+	}
+	return cursor, nil, nil
+}
+
+// parseOrderDate reads a date ("2006-01-02", in East Africa Time) or time (RFC 3339). With
+// endOfDay a date includes the whole day, so it is returned as the start of the next one.
+func parseOrderDate(value string, endOfDay bool) (time.Time, error) {
+	if date, err := time.ParseInLocation(time.DateOnly, value, eastAfricaTime); err == nil {
+		if endOfDay {
+			return date.AddDate(0, 0, 1), nil
+		}
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// queryList splits a comma separated query parameter
+func queryList(ctx *gin.Context, key string) []string {
+	var values []string
+	for _, value := range strings.Split(ctx.Query(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// phoneSearchValues lists the ways a phone number may have been saved on an order
+func phoneSearchValues(phone string) []string {
+	normalized, err := utils.NormalizeKenyanPhone(phone)
+	if err != nil {
+		return []string{strings.TrimSpace(phone)}
+	}
+	local := normalized[4:]
+	return []string{normalized, "0" + local, "254" + local, local}
+}
+
+// filterOrders applies the admin search filters in the query string to query
+func filterOrders(ctx *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+	if search := strings.TrimSpace(ctx.Query("search")); search != "" {
+		if id, err := strconv.Atoi(search); err == nil {
+			query = query.Where("orders.id = ?", id)
+		} else {
+			like := "%" + search + "%"
+			query = query.Where("orders.email LIKE ? OR orders.first_name LIKE ? OR orders.last_name LIKE ? OR orders.phone IN ?",
+				like, like, like, phoneSearchValues(search))
+		}
+	}
+
+	if statuses := queryList(ctx, "status"); len(statuses) > 0 {
+		query = query.Where("orders.status IN ?", statuses)
+	}
+	if paymentStatuses := queryList(ctx, "paymentStatus"); len(paymentStatuses) > 0 {
+		query = query.Where("orders.payment_status IN ?", paymentStatuses)
+	}
+
+	if from := ctx.Query("from"); from != "" {
+		date, err := parseOrderDate(from, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from date %q", from)
+		}
+		query = query.Where("orders.created_at >= ?", date)
+	}
+	if to := ctx.Query("to"); to != "" {
+		date, err := parseOrderDate(to, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to date %q", to)
+		}
+		query = query.Where("orders.created_at < ?", date)
+	}
+
+	// A full address is matched exactly so the email index is used
+	if email := strings.TrimSpace(ctx.Query("email")); email != "" {
+		if strings.Contains(email, "@") {
+			query = query.Where("orders.email = ?", email)
+		} else {
+			query = query.Where("orders.email LIKE ?", "%"+email+"%")
+		}
+	}
+	if phone := ctx.Query("phone"); phone != "" {
+		query = query.Where("orders.phone IN ?", phoneSearchValues(phone))
+	}
+	if name := strings.TrimSpace(ctx.Query("name")); name != "" {
+		like := "%" + name + "%"
+		query = query.Where("orders.first_name LIKE ? OR orders.last_name LIKE ? OR CONCAT(orders.first_name, ' ', orders.last_name) LIKE ?", like, like, like)
+	}
+	if userID := ctx.Query("userId"); userID != "" {
+		query = query.Where("orders.user_id = ?", userID)
+	}
+
+	if productID := ctx.Query("productId"); productID != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM order_items WHERE order_items.order_id = orders.id AND order_items.deleted_at IS NULL AND order_items.product_id = ?)", productID)
+	}
+	if product := strings.TrimSpace(ctx.Query("product")); product != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM order_items WHERE order_items.order_id = orders.id AND order_items.deleted_at IS NULL AND order_items.name LIKE ?)", "%"+product+"%")
+	}
+
+	if minTotal := ctx.Query("minTotal"); minTotal != "" {
+		amount, err := models.ParseMoney(minTotal)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minTotal %q", minTotal)
+		}
+		query = query.Where("orders.total >= ?", amount)
+	}
+	if maxTotal := ctx.Query("maxTotal"); maxTotal != "" {
+		amount, err := models.ParseMoney(maxTotal)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxTotal %q", maxTotal)
+		}
+		query = query.Where("orders.total <= ?", amount)
+	}
+
+	return query, nil
+}
+
+// GetOrders searches orders for admins. Filters are status and paymentStatus (comma separated),
+// from and to (creation dates), email, phone, name, userId, productId or product (item name),
+// minTotal and maxTotal, and search across the id, email, name and phone. Results are sorted by
+// sortBy (createdAt, updatedAt, total or id) in sort order (asc or desc).
+//
+// Pages are requested with page, or with cursor for large result sets: pass an empty cursor
+// for the first page and metadata.nextCursor for the next ones. Cursor pages are not counted.
+func GetOrders(ctx *gin.Context) {
+	var orders []models.Order
+
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultOrderSearchLimit)))
+	if limit < 1 {
+		limit = defaultOrderSearchLimit
+	}
+	if limit > maxOrderSearchLimit {
+		limit = maxOrderSearchLimit
+	}
+
+	sortOrder := ctx.DefaultQuery("sort", "desc")
+	if sortOrder != "asc" && sortOrder != "desc" {
+		sortOrder = "desc"
+	}
+	sortBy := ctx.DefaultQuery("sortBy", "createdAt")
+	sortColumn, ok := orderSortColumns[sortBy]
+	if !ok {
+		sendErrorResponse(ctx, http.StatusBadRequest, "sortBy must be one of createdAt, updatedAt, total or id")
+		return
+	}
+
+	query, err := filterOrders(ctx, initializers.DB.Model(&models.Order{}))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid filter", err)
+		return
+	}
+
+	cursorParam, useCursor := ctx.GetQuery("cursor")
+
+	var count int64
+	page := 1
+	if !useCursor {
+		page, _ = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+		if page < 1 {
+			page = 1
+		}
+		if err := query.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+			respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch orders", err)
+			return
+		}
+	}
+
+	// Cursor pages continue after the last order of the previous page
+	if useCursor && cursorParam != "" {
+		cursor, value, err := decodeOrderCursor(cursorParam, sortBy)
+		if err != nil {
+			respondWithError(ctx, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		comparison := "<"
+		if sortOrder == "asc" {
+			comparison = ">"
+		}
+		if sortColumn == "id" {
+			query = query.Where("orders.id "+comparison+" ?", cursor.ID)
+		} else {
+			query = query.Where(
+				fmt.Sprintf("orders.%[1]s %[2]s ? OR (orders.%[1]s = ? AND orders.id %[2]s ?)", sortColumn, comparison),
+				value, value, cursor.ID,
+			)
+		}
+	}
+
+	query = query.Preload("OrderItems").Preload("DeliveryAddress").Preload("TaxLines").Preload("Invoice")
+	if sortColumn != "id" {
+		query = query.Order("orders." + sortColumn + " " + sortOrder)
+	}
+	query = query.Order("orders.id " + sortOrder)
+
+	// One extra order is fetched to tell whether there is a next page
+	if useCursor {
+		query = query.Limit(limit + 1)
+	} else {
+		query = query.Limit(limit).Offset((page - 1) * limit)
+	}
+	if err := query.Find(&orders).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch orders", err)
+		return
+	}
+
+	if useCursor {
+		hasNextPage := len(orders) > limit
+		if hasNextPage {
+			orders = orders[:limit]
+		}
+		nextCursor := ""
+		if hasNextPage {
+			nextCursor = encodeOrderCursor(sortBy, orders[len(orders)-1])
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"orders": orders,
+			"metadata": gin.H{
+				"limit":       limit,
+				"hasNextPage": hasNextPage,
+				"nextCursor":  nextCursor,
+			},
+		})
+		return
+	}
+
+	previousPage := page - 1
+	nextPage := page + 1
+	totalPages := math.Ceil(float64(count) / float64(limit))
+
+	metadata := gin.H{
+		"total":        count,
+		"currentPage":  page,
+		"limit":        limit,
+		"hasPrevPage":  previousPage > 0,
+		"hasNextPage":  int(totalPages) > page,
+		"previousPage": previousPage,
+		"nextPage":     nextPage,
+	}
+	if int(totalPages) > page && len(orders) > 0 {
+		metadata["nextCursor"] = encodeOrderCursor(sortBy, orders[len(orders)-1])
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"orders":   orders,
+		"metadata": metadata,
+	})
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Server-sent event types of the order streams
+const (
+	orderStreamSnapshot      = "order.snapshot"
+	orderStreamCreated       = "order.created"
+	orderStreamPaymentStatus = "order.payment_status"
+	orderStreamStatus        = "order.status"
+	orderStreamPing          = "ping"
+
+	orderStreamHeartbeat = 25 * time.Second
+
+	// Topic of the admin stream, which receives the events of every order
+	allOrdersTopic = "orders"
+)
+
+// orderEventBus carries order changes to the open streams. It only reaches clients connected
+// to this instance of the API.
+var orderEventBus = utils.NewEventBus()
+
+type orderStreamEvent struct {
+	OrderID       uint         `json:"orderId"`
+	Status        string       `json:"status"`
+	PaymentStatus string       `json:"paymentStatus"`
+	Total         models.Money `json:"total"`
+	Currency      string       `json:"currency"`
+	CustomerName  string       `json:"customerName"`
+	At            time.Time    `json:"at"`
+}
+
+func newOrderStreamEvent(order models.Order) orderStreamEvent {
+	return orderStreamEvent{
+		OrderID:       order.ID,
+		Status:        order.Status,
+		PaymentStatus: order.PaymentStatus,
+		Total:         order.Total,
+		Currency:      order.Currency,
+		CustomerName:  strings.TrimSpace(order.FirstName + " " + order.LastName),
+		At:            time.Now().UTC(),
+	}
+}
+
+func orderTopic(orderID uint) string {
+	return "order:" + strconv.Itoa(int(orderID))
+}
+
+// publishOrderEvent tells the order's stream and the admin stream about a change. Call it
+// once the change is committed.
+func publishOrderEvent(eventType string, order models.Order) {
+	event := utils.Event{Type: eventType, Data: newOrderStreamEvent(order)}
+	orderEventBus.Publish(orderTopic(order.ID), event)
+	orderEventBus.Publish(allOrdersTopic, event)
+}
+
+// streamEvents writes the events of a topic to the client as server-sent events until it
+// disconnects, starting with snapshot when given
+func streamEvents(ctx *gin.Context, topic string, snapshot *utils.Event) {
+	events, unsubscribe := orderEventBus.Subscribe(topic)
+	defer unsubscribe()
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+
+	if snapshot != nil {
+		ctx.SSEvent(snapshot.Type, snapshot.Data)
+	} else {
+		ctx.SSEvent(orderStreamPing, time.Now().Unix())
+	}
+	ctx.Writer.Flush()
+
+	heartbeat := time.NewTicker(orderStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			ctx.SSEvent(event.Type, event.Data)
+			return true
+		case <-heartbeat.C:
+			ctx.SSEvent(orderStreamPing, time.Now().Unix())
+			return true
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func streamOrder(ctx *gin.Context, order models.Order) {
+	streamEvents(ctx, orderTopic(order.ID), &utils.Event{
+		Type: orderStreamSnapshot,
+		Data: newOrderStreamEvent(order),
+	})
+}
+
+// StreamOrderEvents pushes payment and status changes of an order to its owner or an admin,
+// replacing polling /paymentstatus after the payment redirect
+func StreamOrderEvents(ctx *gin.Context) {
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	orderId, err := strconv.Atoi(ctx.Param("orderId"))
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to parse orderId")
+		return
+	}
+
+	var order models.Order
+	if err := initializers.DB.First(&order, orderId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendErrorResponse(ctx, http.StatusNotFound, "Order not found")
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch order.", err)
+		}
+		return
+	}
+
+	// Other customers' orders are reported as missing rather than forbidden
+	if !order.PlacedBy(userID) && !isAdminUser(ctx) {
+		sendErrorResponse(ctx, http.StatusNotFound, "Order not found")
+		return
+	}
+
+	streamOrder(ctx, order)
+}
+
+// StreamGuestOrderEvents is StreamOrderEvents for the holder of a guest order's access token
+func StreamGuestOrderEvents(ctx *gin.Context) {
+	order, ok := findGuestOrder(ctx)
+	if !ok {
+		return
+	}
+
+	streamOrder(ctx, order)
+}
+
+// StreamAllOrderEvents pushes new orders and the payment and status changes of every order to admins
+func StreamAllOrderEvents(ctx *gin.Context) {
+	streamEvents(ctx, allOrdersTopic, nil)
+}
@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"math"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -42,11 +42,24 @@ func CreateProduct(ctx *gin.Context) {
 		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
+	if err := checkNewVariants(product.Variants); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid variants", err)
+		return
+	}
+	if err := resolveProductCategory(initializers.DB, &product); err != nil {
+		sendCategoryError(ctx, err)
+		return
+	}
+	if err := resolveProductBrand(initializers.DB, &product); err != nil {
+		sendBrandError(ctx, err)
+		return
+	}
 
 	if err := initializers.DB.Create(&product).Error; err != nil {
 		respondWithError(ctx, http.StatusInternalServerError, "Failed to create product", err)
 		return
 	}
+	notifyProductChanged(product.ID, "created")
 
 	ctx.JSON(http.StatusCreated, product)
 }
@@ -73,6 +86,7 @@ func CreateProductSpecs(ctx *gin.Context) {
 		respondWithError(ctx, http.StatusInternalServerError, "Failed to create product specifications", err)
 		return
 	}
+	notifyProductChanged(product.ID, "updated")
 
 	ctx.JSON(http.StatusCreated, gin.H{"message": "Product specs added successfully"})
 }
@@ -88,6 +102,27 @@ func getAWSUploader() (*manager.Uploader, error) {
 	return manager.NewUploader(client), nil
 }
 
+// uploadPublicFile uploads a form file to the bucket under key and returns its public URL
+func uploadPublicFile(uploader *manager.Uploader, file *multipart.FileHeader, key string) (string, error) {
+	f, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	result, err := uploader.Upload(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String("amexan"),
+		Key:         aws.String(key),
+		Body:        f,
+		ACL:         "public-read",
+		ContentType: aws.String(file.Header.Get("Content-Type")),
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.Location, nil
+}
+
 func UploadProductImages(ctx *gin.Context) {
 	// Get multipart form
 	form, err := ctx.MultipartForm()
@@ -126,6 +161,21 @@ func UploadProductImages(ctx *gin.Context) {
 		return
 	}
 
+	// Images can belong to one variant of the product
+	var variantId *int
+	if value := ctx.PostForm("variantId"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			respondWithError(ctx, http.StatusBadRequest, "Invalid variantId", err)
+			return
+		}
+		if err := initializers.DB.Where("product_id = ?", productId).First(&models.ProductVariant{}, id).Error; err != nil {
+			respondWithError(ctx, http.StatusNotFound, msgVariantNotFound, nil)
+			return
+		}
+		variantId = &id
+	}
+
 	// Get AWS uploader
 	uploader, err := getAWSUploader()
 	if err != nil {
@@ -138,37 +188,23 @@ func UploadProductImages(ctx *gin.Context) {
 
 	// Upload files and save to database
 	for _, file := range files {
-		f, openErr := file.Open()
-		if openErr != nil {
-			log.Printf("Error opening file %s: %v", file.Filename, openErr)
-			failedUploads = append(failedUploads, file.Filename)
-			continue
-		}
-
 		// Generate a unique filename to prevent overwrites
 		uniqueFilename := fmt.Sprintf("%d-%s-%s", productId, time.Now().Format("20060102150405"), file.Filename)
 
-		result, uploadErr := uploader.Upload(context.TODO(), &s3.PutObjectInput{
-			Bucket:      aws.String("amexan"),
-			Key:         aws.String(uniqueFilename),
-			Body:        f,
-			ACL:         "public-read",
-			ContentType: aws.String(file.Header.Get("Content-Type")),
-		})
-		f.Close() // Close file immediately after use
-
+		location, uploadErr := uploadPublicFile(uploader, file, uniqueFilename)
 		if uploadErr != nil {
 			log.Printf("Error uploading file %s: %v", file.Filename, uploadErr)
 			failedUploads = append(failedUploads, file.Filename)
 			continue
 		}
 
-		uploadedUrls = append(uploadedUrls, result.Location)
+		uploadedUrls = append(uploadedUrls, location)
 
 		// Create a ProductImage record
 		productImage := models.ProductImage{
-			Url:       result.Location,
+			Url:       location,
 			ProductID: productId,
+			VariantID: variantId,
 		}
 
 		if err := initializers.DB.Create(&productImage).Error; err != nil {
@@ -177,6 +213,10 @@ func UploadProductImages(ctx *gin.Context) {
 		}
 	}
 
+	if len(uploadedUrls) > 0 {
+		notifyProductChanged(product.ID, "updated")
+	}
+
 	response := gin.H{
 		"message": "Files processed",
 		"urls":    uploadedUrls,
@@ -189,66 +229,6 @@ func UploadProductImages(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
-func GetProducts(ctx *gin.Context) {
-	var products []models.Product
-
-	// Add pagination
-	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "4"))
-	offset := (page - 1) * limit
-
-	query := initializers.DB.Preload("Images")
-
-	// Add search by name if provided
-	if search := ctx.Query("search"); search != "" {
-		query = query.Where("name LIKE ?", "%"+search+"%")
-	}
-
-	// Execute the query with pagination
-	result := query.Limit(limit).Offset(offset).Find(&products)
-	if result.Error != nil {
-		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch products", result.Error)
-		return
-	}
-
-	// Get total count for pagination
-	var count int64
-	initializers.DB.Model(&models.Product{}).Count(&count)
-
-	previousPage := page - 1
-	currentPage := page
-	nextPage := page + 1
-
-	var hasNextPage bool
-	var hasPreviousPage bool
-
-	totalPages := math.Ceil(float64(count) / float64(limit))
-	if currentPage == int(totalPages) {
-		hasNextPage = false
-	} else {
-		hasNextPage = true
-	}
-
-	if previousPage == 0 {
-		hasPreviousPage = false
-	} else {
-		hasPreviousPage = true
-	}
-
-	ctx.JSON(http.StatusOK, gin.H{
-		"products": products,
-		"metadata": gin.H{
-			"total":        count,
-			"currentPage":  currentPage,
-			"limit":        limit,
-			"hasPrevPage":  hasPreviousPage,
-			"hasNextPage":  hasNextPage,
-			"previousPage": previousPage,
-			"nextPage":     nextPage,
-		},
-	})
-}
-
 func GetProduct(ctx *gin.Context) {
 	productId, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
@@ -257,7 +237,7 @@ func GetProduct(ctx *gin.Context) {
 	}
 
 	var product models.Product
-	result := initializers.DB.Preload("Specifications").Preload("Images").First(&product, productId)
+	result := initializers.DB.Preload("Specifications").Preload("Images").Preload("Variants.Images").First(&product, productId)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			respondWithError(ctx, http.StatusNotFound, "Product not found", nil)
@@ -267,6 +247,15 @@ func GetProduct(ctx *gin.Context) {
 		return
 	}
 
+	if product.CategoryID != nil {
+		tree, err := loadCategoryTree(initializers.DB)
+		if err != nil {
+			respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch categories", err)
+			return
+		}
+		product.Breadcrumb = tree.breadcrumb(uint(*product.CategoryID))
+	}
+
 	ctx.JSON(http.StatusOK, product)
 }
 
@@ -307,6 +296,18 @@ func UpdateProduct(ctx *gin.Context) {
 	}
 
 	updateData.ID = uint(productId)
+	if updateData.CategoryID != nil || updateData.Category != "" {
+		if err := resolveProductCategory(initializers.DB, &updateData); err != nil {
+			sendCategoryError(ctx, err)
+			return
+		}
+	}
+	if updateData.BrandID != nil || updateData.Brand != "" {
+		if err := resolveProductBrand(initializers.DB, &updateData); err != nil {
+			sendBrandError(ctx, err)
+			return
+		}
+	}
 
 	if err := initializers.DB.Model(&models.Product{}).
 		Where("id = ?", productId).
@@ -315,6 +316,7 @@ func UpdateProduct(ctx *gin.Context) {
 		sendErrorResponse(ctx, 500, "Failed to update product")
 		return
 	}
+	notifyProductChanged(uint(productId), "updated")
 
 	sendJSONResponse(ctx, 200, gin.H{
 		"message": "Product updated successfully",
@@ -391,11 +393,19 @@ func DeleteProduct(ctx *gin.Context) {
 		return
 	}
 
+	if result := initializers.DB.Where("product_id = ?", productId).Delete(&models.ProductVariant{}); result.Error != nil {
+		log.Println(result.Error)
+		sendErrorResponse(ctx, 400, "Unable to delete product variants.")
+		return
+	}
+
 	if result := initializers.DB.Delete(&models.Product{}, productId); result.Error != nil {
 		log.Println(result.Error)
 		sendErrorResponse(ctx, 400, "Unable to delete product.")
 		return
 	}
+	notifyProductChanged(uint(productId), "deleted")
+
 	sendJSONResponse(ctx, 200, gin.H{
 		"message": "Product was deleted successfully.",
 	})
@@ -0,0 +1,424 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultProductSearchLimit = 4
+	maxProductSearchLimit     = 100
+)
+
+// SQL for the values products are filtered and sorted by. A product with active variants is
+// sold from the price of its cheapest variant and is in stock while one of them is.
+const (
+	activeProductVariants = "product_variants.product_id = products.id AND product_variants.active AND product_variants.deleted_at IS NULL"
+
+	productPriceSQL = "COALESCE((SELECT MIN(COALESCE(product_variants.price, products.price)) FROM product_variants WHERE " +
+		activeProductVariants + "), products.price)"
+
+	productInStockSQL = "(CASE WHEN EXISTS (SELECT 1 FROM product_variants WHERE " + activeProductVariants + ")" +
+		" THEN EXISTS (SELECT 1 FROM product_variants WHERE " + activeProductVariants +
+		" AND (product_variants.stock IS NULL OR product_variants.stock > 0))" +
+		" ELSE products.stock IS NULL OR products.stock > 0 END)"
+
+	productUnitsSoldSQL = "(SELECT COALESCE(SUM(order_items.quantity), 0) FROM order_items" +
+		" JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL" +
+		" WHERE order_items.product_id = products.id AND order_items.deleted_at IS NULL AND orders.payment_status = '" +
+		paymentStatusCompleted + "')"
+
+	// colors of a product's active variants and the ones listed in products.colors
+	productColorsSQL = "SELECT product_variants.product_id, product_variants.color FROM product_variants" +
+		" WHERE product_variants.active AND product_variants.deleted_at IS NULL AND product_variants.color <> ''" +
+		" UNION SELECT products.id, product_colors.color FROM products," +
+		" JSON_TABLE(products.colors, '$[*]' COLUMNS (color VARCHAR(64) PATH '$')) AS product_colors" +
+		" WHERE product_colors.color <> ''"
+)
+
+// Fields products can be sorted by, their SQL and default order. Ties are broken by id.
+var productSortFields = map[string]struct {
+	Expr  string
+	Order string
+}{
+	"newest":     {"products.created_at", "desc"},
+	"price":      {productPriceSQL, "asc"},
+	"popularity": {productUnitsSoldSQL, "desc"},
+	"name":       {"products.name", "asc"},
+}
+
+// Facets of the product search, each one is counted without its own filter so every option
+// shows how many products choosing it would give
+const (
+	facetCategory = "category"
+	facetBrand    = "brand"
+	facetPrice    = "price"
+	facetColor    = "color"
+	facetSpec     = "spec"
+	facetInStock  = "inStock"
+)
+
+// productFilter holds the product search filters read from the query string
+type productFilter struct {
+	Search      string
+	Tree        categoryTree
+	CategoryIDs []uint // the chosen category and its subcategories
+	BrandIDs    []uint
+	MinPrice    *models.Money
+	MaxPrice    *models.Money
+	Colors      []string
+	Specs       map[string][]string // spec name to the values any of which match
+	InStock     bool
+}
+
+var errProductFilterNotFound = errors.New("not found")
+
+// parseProductFilter reads the filters: search (name), category (id or slug, with its
+// subcategories), brand (ids or slugs), minPrice and maxPrice, color, spec[Name] and
+// inStock=true. Lists are comma separated and match any of their values.
+func parseProductFilter(ctx *gin.Context) (productFilter, error) {
+	filter := productFilter{Search: strings.TrimSpace(ctx.Query("search")), Specs: make(map[string][]string)}
+
+	tree, err := loadCategoryTree(initializers.DB)
+	if err != nil {
+		return filter, err
+	}
+	filter.Tree = tree
+	if ref := ctx.Query("category"); ref != "" {
+		category, exists := tree.findByRef(ref)
+		if !exists {
+			return filter, fmt.Errorf("category %s %w", ref, errProductFilterNotFound)
+		}
+		filter.CategoryIDs = tree.descendantIDs(category.ID)
+	}
+
+	for _, ref := range queryList(ctx, "brand") {
+		brand, err := findBrandByRef(initializers.DB, ref)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return filter, fmt.Errorf("brand %s %w", ref, errProductFilterNotFound)
+		}
+		if err != nil {
+			return filter, err
+		}
+		filter.BrandIDs = append(filter.BrandIDs, brand.ID)
+	}
+
+	if minPrice := ctx.Query("minPrice"); minPrice != "" {
+		amount, err := models.ParseMoney(minPrice)
+		if err != nil {
+			return filter, fmt.Errorf("invalid minPrice %q", minPrice)
+		}
+		filter.MinPrice = &amount
+	}
+	if maxPrice := ctx.Query("maxPrice"); maxPrice != "" {
+		amount, err := models.ParseMoney(maxPrice)
+		if err != nil {
+			return filter, fmt.Errorf("invalid maxPrice %q", maxPrice)
+		}
+		filter.MaxPrice = &amount
+	}
+
+	filter.Colors = queryList(ctx, "color")
+	for name, values := range ctx.QueryMap("spec") {
+		for _, value := range strings.Split(values, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				filter.Specs[name] = append(filter.Specs[name], value)
+			}
+		}
+	}
+	filter.InStock = ctx.Query("inStock") == "true"
+
+	return filter, nil
+}
+
+// apply adds the filters to a query on products, leaving out the filters of skip. skipSpec
+// names the spec left out when skip is facetSpec.
+func (filter productFilter) apply(query *gorm.DB, skip, skipSpec string) *gorm.DB {
+	if filter.Search != "" {
+		query = query.Where("products.name LIKE ?", "%"+filter.Search+"%")
+	}
+	if len(filter.CategoryIDs) > 0 && skip != facetCategory {
+		query = query.Where("products.category_id IN ?", filter.CategoryIDs)
+	}
+	if len(filter.BrandIDs) > 0 && skip != facetBrand {
+		query = query.Where("products.brand_id IN ?", filter.BrandIDs)
+	}
+	if skip != facetPrice {
+		if filter.MinPrice != nil {
+			query = query.Where(productPriceSQL+" >= ?", *filter.MinPrice)
+		}
+		if filter.MaxPrice != nil {
+			query = query.Where(productPriceSQL+" <= ?", *filter.MaxPrice)
+		}
+	}
+	if len(filter.Colors) > 0 && skip != facetColor {
+		query = query.Where("products.id IN (SELECT product_id FROM ("+productColorsSQL+") AS colors WHERE colors.color IN ?)", filter.Colors)
+	}
+	for name, values := range filter.Specs {
+		if skip == facetSpec && name == skipSpec {
+			continue
+		}
+		query = query.Where("EXISTS (SELECT 1 FROM product_specs WHERE product_specs.product_id = products.id"+
+			" AND product_specs.deleted_at IS NULL AND product_specs.name = ? AND product_specs.value IN ?)", name, values)
+	}
+	if filter.InStock && skip != facetInStock {
+		query = query.Where(productInStockSQL)
+	}
+	return query
+}
+
+// filtered starts a query on the products matching the filters except those of skip
+func (filter productFilter) filtered(skip, skipSpec string) *gorm.DB {
+	return filter.apply(initializers.DB.Model(&models.Product{}), skip, skipSpec)
+}
+
+type categoryFacet struct {
+	ID       uint   `json:"id"`
+	ParentID *int   `json:"parentId"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Count    int64  `json:"count"` // includes the products of subcategories
+}
+
+type brandFacet struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int64  `json:"count"`
+}
+
+type valueFacet struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+type priceFacet struct {
+	Min models.Money `json:"min"`
+	Max models.Money `json:"max"`
+}
+
+type productFacets struct {
+	Categories []categoryFacet         `json:"categories"`
+	Brands     []brandFacet            `json:"brands"`
+	Price      priceFacet              `json:"price"`
+	Colors     []valueFacet            `json:"colors"`
+	Specs      map[string][]valueFacet `json:"specs"`
+	InStock    int64                   `json:"inStock"`
+}
+
+// countCategories counts the products in each category, adding the products of
+// subcategories to their parents
+func (filter productFilter) countCategories() ([]categoryFacet, error) {
+	var rows []struct {
+		CategoryID uint
+		Count      int64
+	}
+	if err := filter.filtered(facetCategory, "").
+		Select("products.category_id, COUNT(*) AS count").
+		Where("products.category_id IS NOT NULL").
+		Group("products.category_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	direct := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		direct[row.CategoryID] = row.Count
+	}
+
+	facets := []categoryFacet{}
+	var walk func(parentID uint) int64
+	walk = func(parentID uint) int64 {
+		var total int64
+		for _, category := range filter.Tree.children[parentID] {
+			index := len(facets)
+			facets = append(facets, categoryFacet{ID: category.ID, ParentID: category.ParentID, Name: category.Name, Slug: category.Slug})
+			count := direct[category.ID] + walk(category.ID)
+			facets[index].Count = count
+			total += count
+		}
+		return total
+	}
+	walk(0)
+
+	counted := facets[:0]
+	for _, facet := range facets {
+		if facet.Count > 0 {
+			counted = append(counted, facet)
+		}
+	}
+	return counted, nil
+}
+
+// countSpecs counts the products with each value of each spec
+func (filter productFilter) countSpecs() (map[string][]valueFacet, error) {
+	type specCount struct {
+		Name  string
+		Value string
+		Count int64
+	}
+	count := func(products *gorm.DB, name string) ([]specCount, error) {
+		query := initializers.DB.Table("product_specs").
+			Select("product_specs.name, product_specs.value, COUNT(DISTINCT product_specs.product_id) AS count").
+			Where("product_specs.deleted_at IS NULL AND product_specs.product_id IN (?)", products.Select("products.id"))
+		if name != "" {
+			query = query.Where("product_specs.name = ?", name)
+		}
+		var rows []specCount
+		err := query.Group("product_specs.name, product_specs.value").Order("product_specs.name, product_specs.value").Scan(&rows).Error
+		return rows, err
+	}
+
+	// Specs that are filtered on are counted without their own filter
+	rows, err := count(filter.filtered("", ""), "")
+	if err != nil {
+		return nil, err
+	}
+	facets := make(map[string][]valueFacet)
+	for _, row := range rows {
+		if _, filtered := filter.Specs[row.Name]; !filtered {
+			facets[row.Name] = append(facets[row.Name], valueFacet{row.Value, row.Count})
+		}
+	}
+	for name := range filter.Specs {
+		rows, err := count(filter.filtered(facetSpec, name), name)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			facets[row.Name] = append(facets[row.Name], valueFacet{row.Value, row.Count})
+		}
+	}
+	return facets, nil
+}
+
+// facets counts the products for each option of every filter
+func (filter productFilter) facets() (productFacets, error) {
+	facets := productFacets{Brands: []brandFacet{}, Colors: []valueFacet{}}
+
+	var err error
+	if facets.Categories, err = filter.countCategories(); err != nil {
+		return facets, err
+	}
+
+	if err := filter.filtered(facetBrand, "").
+		Joins("JOIN brands ON brands.id = products.brand_id AND brands.deleted_at IS NULL").
+		Select("brands.id, brands.name, brands.slug, COUNT(*) AS count").
+		Group("brands.id, brands.name, brands.slug").
+		Order("brands.name").
+		Scan(&facets.Brands).Error; err != nil {
+		return facets, err
+	}
+
+	if err := filter.filtered(facetPrice, "").
+		Select("COALESCE(MIN(" + productPriceSQL + "), 0) AS min, COALESCE(MAX(" + productPriceSQL + "), 0) AS max").
+		Scan(&facets.Price).Error; err != nil {
+		return facets, err
+	}
+
+	if err := initializers.DB.Table("("+productColorsSQL+") AS colors").
+		Select("colors.color AS value, COUNT(DISTINCT colors.product_id) AS count").
+		Where("colors.product_id IN (?)", filter.filtered(facetColor, "").Select("products.id")).
+		Group("colors.color").
+		Order("colors.color").
+		Scan(&facets.Colors).Error; err != nil {
+		return facets, err
+	}
+
+	if facets.Specs, err = filter.countSpecs(); err != nil {
+		return facets, err
+	}
+
+	if err := filter.filtered(facetInStock, "").Where(productInStockSQL).Count(&facets.InStock).Error; err != nil {
+		return facets, err
+	}
+	return facets, nil
+}
+
+// GetProducts searches products. See parseProductFilter for the filters. Results are sorted by
+// sortBy (newest, price, popularity or name) in sort order (asc or desc), popularity being the
+// units sold in paid orders. The response has facets with the number of products for each
+// category, brand, color and spec value, the price range and how many are in stock.
+func GetProducts(ctx *gin.Context) {
+	var products []models.Product
+
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultProductSearchLimit)))
+	if limit < 1 {
+		limit = defaultProductSearchLimit
+	}
+	if limit > maxProductSearchLimit {
+		limit = maxProductSearchLimit
+	}
+
+	sortBy := ctx.DefaultQuery("sortBy", "newest")
+	sortField, ok := productSortFields[sortBy]
+	if !ok {
+		sendErrorResponse(ctx, http.StatusBadRequest, "sortBy must be one of newest, price, popularity or name")
+		return
+	}
+	sortOrder := ctx.DefaultQuery("sort", sortField.Order)
+	if sortOrder != "asc" && sortOrder != "desc" {
+		sortOrder = sortField.Order
+	}
+
+	filter, err := parseProductFilter(ctx)
+	if errors.Is(err, errProductFilterNotFound) {
+		respondWithError(ctx, http.StatusNotFound, "Invalid filter", err)
+		return
+	}
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid filter", err)
+		return
+	}
+
+	var count int64
+	if err := filter.filtered("", "").Count(&count).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch products", err)
+		return
+	}
+
+	if err := filter.filtered("", "").
+		Preload("Images").Preload("Variants").
+		Order(sortField.Expr + " " + sortOrder).
+		Order("products.id " + sortOrder).
+		Limit(limit).Offset((page - 1) * limit).
+		Find(&products).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch products", err)
+		return
+	}
+
+	facets, err := filter.facets()
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to count products", err)
+		return
+	}
+
+	previousPage := page - 1
+	totalPages := int(math.Ceil(float64(count) / float64(limit)))
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"products": products,
+		"facets":   facets,
+		"metadata": gin.H{
+			"total":        count,
+			"currentPage":  page,
+			"limit":        limit,
+			"hasPrevPage":  previousPage > 0,
+			"hasNextPage":  page < totalPages,
+			"previousPage": previousPage,
+			"nextPage":     page + 1,
+		},
+	})
+}
@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const msgVariantNotFound = "Variant not found"
+
+var (
+	errVariantRequired = errors.New("choose a variant of this product")
+	errVariantNotFound = errors.New("the chosen variant is not available")
+)
+
+// findVariant picks the variant with variantID of a product loaded with its variants. Products
+// with active variants can only be bought by variant, for others variantID must be 0 and the
+// variant is nil.
+func findVariant(product models.Product, variantID int) (*models.ProductVariant, error) {
+	if variantID == 0 {
+		for _, variant := range product.Variants {
+			if variant.Active {
+				return nil, errVariantRequired
+			}
+		}
+		return nil, nil
+	}
+
+	for i, variant := range product.Variants {
+		if int(variant.ID) == variantID && variant.Active {
+			return &product.Variants[i], nil
+		}
+	}
+	return nil, errVariantNotFound
+}
+
+// unitPrice is the price of a product, or of the variant when there is one
+func unitPrice(product models.Product, variant *models.ProductVariant) models.Money {
+	if variant != nil {
+		return variant.PriceOf(product)
+	}
+	return product.Price
+}
+
+// unitStock is the stock of a product, or of the variant when there is one. nil when it isn't tracked.
+func unitStock(product models.Product, variant *models.ProductVariant) *int {
+	if variant != nil {
+		return variant.Stock
+	}
+	return product.Stock
+}
+
+// describeVariant fills in the SKU and variant name of a cart or order line
+func describeVariant(variant *models.ProductVariant) (sku, name string) {
+	if variant == nil {
+		return "", ""
+	}
+	return variant.SKU, variant.Label()
+}
+
+// orderItemName is the name of an order item including its variant, e.g. "Galaxy A15 (Black / 128GB)"
+func orderItemName(item models.OrderItem) string {
+	if item.VariantName == "" {
+		return item.Name
+	}
+	return item.Name + " (" + item.VariantName + ")"
+}
+
+// checkVariant validates a variant of productID before it is saved: it needs a SKU no other
+// variant uses and at least one attribute, and no other variant of the product may have the
+// same attributes
+func checkVariant(db *gorm.DB, productID int, variant models.ProductVariant) error {
+	if strings.TrimSpace(variant.SKU) == "" {
+		return errors.New("sku is required")
+	}
+	if variant.Label() == "" {
+		return errors.New("a variant needs a color, size or capacity")
+	}
+	if variant.Price != nil && *variant.Price < 0 {
+		return errors.New("price can't be negative")
+	}
+
+	var count int64
+	if err := db.Model(&models.ProductVariant{}).
+		Where("sku = ? AND id != ?", variant.SKU, variant.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("sku %s is already used", variant.SKU)
+	}
+
+	if err := db.Model(&models.ProductVariant{}).
+		Where("product_id = ? AND id != ? AND color = ? AND size = ? AND capacity = ?",
+			productID, variant.ID, variant.Color, variant.Size, variant.Capacity).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("the product already has a %s variant", variant.Label())
+	}
+	return nil
+}
+
+// checkNewVariants validates the variants sent with a new product
+func checkNewVariants(variants []models.ProductVariant) error {
+	skus := make(map[string]bool, len(variants))
+	labels := make(map[string]bool, len(variants))
+	for _, variant := range variants {
+		if err := checkVariant(initializers.DB, 0, variant); err != nil {
+			return err
+		}
+		label := strings.ToLower(variant.Label())
+		if skus[variant.SKU] || labels[label] {
+			return fmt.Errorf("variant %s is listed twice", variant.SKU)
+		}
+		skus[variant.SKU] = true
+		labels[label] = true
+	}
+	return nil
+}
+
+func findProductVariant(ctx *gin.Context) (int, models.ProductVariant, bool) {
+	var variant models.ProductVariant
+
+	productId, err := strconv.Atoi(ctx.Param("productId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid product ID", err)
+		return 0, variant, false
+	}
+	variantId, err := strconv.Atoi(ctx.Param("variantId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid variant ID", err)
+		return 0, variant, false
+	}
+
+	if err := initializers.DB.Where("product_id = ?", productId).First(&variant, variantId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, msgVariantNotFound, nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch variant", err)
+		}
+		return 0, variant, false
+	}
+	return productId, variant, true
+}
+
+func CreateProductVariant(ctx *gin.Context) {
+	productId, err := strconv.Atoi(ctx.Param("productId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid product ID", err)
+		return
+	}
+
+	var variant models.ProductVariant
+	if err := ctx.ShouldBindJSON(&variant); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	variant.ID = 0
+	variant.ProductID = productId
+	variant.Images = nil
+
+	if err := initializers.DB.Select("id").First(&models.Product{}, productId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, "Product not found", nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to validate product", err)
+		}
+		return
+	}
+	if err := checkVariant(initializers.DB, productId, variant); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid variant", err)
+		return
+	}
+
+	if err := initializers.DB.Create(&variant).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create variant", err)
+		return
+	}
+	notifyProductChanged(uint(productId), "updated")
+
+	ctx.JSON(http.StatusCreated, variant)
+}
+
+// UpdateProductVariant replaces a variant's SKU, attributes, price override and stock, and
+// whether it is active when active is sent. Leaving price out removes the override.
+func UpdateProductVariant(ctx *gin.Context) {
+	productId, variant, ok := findProductVariant(ctx)
+	if !ok {
+		return
+	}
+
+	var updateData struct {
+		SKU      string        `json:"sku" binding:"required"`
+		Color    string        `json:"color"`
+		Size     string        `json:"size"`
+		Capacity string        `json:"capacity"`
+		Price    *models.Money `json:"price"`
+		Stock    *int          `json:"stock"`
+		Active   *bool         `json:"active"`
+	}
+	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	variant.SKU = updateData.SKU
+	variant.Color = updateData.Color
+	variant.Size = updateData.Size
+	variant.Capacity = updateData.Capacity
+	variant.Price = updateData.Price
+	variant.Stock = updateData.Stock
+	if updateData.Active != nil {
+		variant.Active = *updateData.Active
+	}
+	if err := checkVariant(initializers.DB, productId, variant); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid variant", err)
+		return
+	}
+	if err := initializers.DB.Model(&variant).
+		Select("sku", "color", "size", "capacity", "price", "stock", "active").
+		Updates(&variant).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to update variant", err)
+		return
+	}
+	notifyProductChanged(uint(productId), "updated")
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Variant updated successfully", "variant": variant})
+}
+
+// DeleteProductVariant removes a variant. Orders keep its SKU and name, its images become
+// images of the product and carts holding it are asked to choose another variant.
+func DeleteProductVariant(ctx *gin.Context) {
+	productId, variant, ok := findProductVariant(ctx)
+	if !ok {
+		return
+	}
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ProductImage{}).Where("variant_id = ?", variant.ID).Update("variant_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&variant).Error
+	})
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to delete variant", err)
+		return
+	}
+	notifyProductChanged(uint(productId), "updated")
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Variant deleted successfully"})
+}
@@ -0,0 +1,725 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultReturnWindowDays = 7
+	maxReturnPhotos         = 5
+	maxReturnPhotoSize      = 5 << 20
+
+	msgReturnNotFound = "Return request not found"
+)
+
+var (
+	errReturnWindowClosed = errors.New("the return window for this order has closed")
+	errOrderNotReturnable = errors.New("only delivered and paid orders can be returned")
+	errReturnQuantity     = errors.New("more items requested than can be returned")
+	errRefundAmount       = errors.New("invalid refund amount")
+	errNoPesapalPayment   = errors.New("the order has no Pesapal payment to refund, record a manual refund instead")
+	errOrderNotRefundable = errors.New("only paid orders can be refunded, the payment may have been reversed")
+)
+
+// returnTransitions lists the states a return request can move to from each state
+var returnTransitions = map[string][]string{
+	models.ReturnStatusRequested: {models.ReturnStatusApproved, models.ReturnStatusRejected, models.ReturnStatusCancelled},
+	models.ReturnStatusApproved:  {models.ReturnStatusReceived},
+	models.ReturnStatusReceived:  {models.ReturnStatusRefunding},
+	models.ReturnStatusRefunding: {models.ReturnStatusRefunded},
+}
+
+// returnWindow is how long after delivery items can be returned, from RETURN_WINDOW_DAYS
+func returnWindow() time.Duration {
+	days := defaultReturnWindowDays
+	if value := os.Getenv("RETURN_WINDOW_DAYS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err == nil && parsed >= 0 {
+			days = parsed
+		} else {
+			log.Println("Invalid RETURN_WINDOW_DAYS, using default:", value)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// returnDeadline is when the return window of an order closes. Orders delivered before
+// delivery dates were recorded fall back to when their status last changed.
+func returnDeadline(order models.Order) (time.Time, error) {
+	if order.PaymentStatus != paymentStatusCompleted {
+		return time.Time{}, errOrderNotReturnable
+	}
+	if order.DeliveredAt != nil {
+		return order.DeliveredAt.Add(returnWindow()), nil
+	}
+	if event, _ := orderStatusEvent(order.Status); event == orderEventDelivered {
+		return order.UpdatedAt.Add(returnWindow()), nil
+	}
+	return time.Time{}, errOrderNotReturnable
+}
+
+// returnedQuantities sums the quantities of each order item in the order's open and completed returns
+func returnedQuantities(db *gorm.DB, orderID uint) (map[int]int, error) {
+	var rows []struct {
+		OrderItemID int
+		Quantity    int
+	}
+	if err := db.Model(&models.ReturnItem{}).
+		Select("return_items.order_item_id, SUM(return_items.quantity) AS quantity").
+		Joins("JOIN return_requests ON return_requests.id = return_items.return_request_id AND return_requests.deleted_at IS NULL").
+		Where("return_requests.order_id = ? AND return_requests.status NOT IN ?", orderID,
+			[]string{models.ReturnStatusRejected, models.ReturnStatusCancelled}).
+		Group("return_items.order_item_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	returned := make(map[int]int, len(rows))
+	for _, row := range rows {
+		returned[row.OrderItemID] = row.Quantity
+	}
+	return returned, nil
+}
+
+// itemRefundAmount is what the customer paid for quantity units of an order item: its share of
+// the item discount and, when prices exclude it, of the VAT
+func itemRefundAmount(order models.Order, item models.OrderItem, quantity int) models.Money {
+	share := func(amount models.Money) models.Money {
+		return models.Money(int64(amount) * int64(quantity) / int64(item.Quantity))
+	}
+	amount := item.Price.Mul(quantity) - share(item.Discount)
+	if !order.PricesIncludeTax {
+		amount += share(item.TaxAmount)
+	}
+	return amount
+}
+
+// refundedAmount is the total already refunded, or being refunded, through returns on an order
+func refundedAmount(db *gorm.DB, orderID uint) (models.Money, error) {
+	var refunded models.Money
+	err := db.Model(&models.ReturnRequest{}).
+		Select("COALESCE(SUM(refund_amount), 0)").
+		Where("order_id = ? AND status IN ?", orderID, []string{models.ReturnStatusRefunding, models.ReturnStatusRefunded}).
+		Scan(&refunded).Error
+	return refunded, err
+}
+
+// CreateReturnRequest opens a return for items of one of the customer's delivered orders.
+// Photos of the items are added afterwards with UploadReturnPhotos.
+func CreateReturnRequest(ctx *gin.Context) {
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var body struct {
+		OrderID int    `json:"orderId" binding:"required"`
+		Reason  string `json:"reason" binding:"required"`
+		Details string `json:"details"`
+		Items   []struct {
+			OrderItemID int `json:"orderItemId" binding:"required"`
+			Quantity    int `json:"quantity" binding:"required,min=1"`
+		} `json:"items" binding:"required,min=1,dive"`
+	}
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if !slices.Contains(models.ReturnReasons, body.Reason) {
+		sendErrorResponse(ctx, http.StatusBadRequest, "reason must be one of "+strings.Join(models.ReturnReasons, ", "))
+		return
+	}
+
+	var order models.Order
+	if err := initializers.DB.Preload("OrderItems").First(&order, body.OrderID).Error; err != nil || !order.PlacedBy(userID) {
+		sendErrorResponse(ctx, http.StatusNotFound, "Order not found")
+		return
+	}
+	deadline, err := returnDeadline(order)
+	if err != nil {
+		sendErrorResponse(ctx, http.StatusConflict, err.Error())
+		return
+	}
+	if time.Now().After(deadline) {
+		sendErrorResponse(ctx, http.StatusConflict, errReturnWindowClosed.Error())
+		return
+	}
+
+	returnRequest := models.ReturnRequest{
+		OrderID:  int(order.ID),
+		UserID:   userID,
+		Status:   models.ReturnStatusRequested,
+		Reason:   body.Reason,
+		Details:  strings.TrimSpace(body.Details),
+		Currency: order.Currency,
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the order so two requests can't return the same items
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Order{}, order.ID).Error; err != nil {
+			return err
+		}
+		returned, err := returnedQuantities(tx, order.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, requested := range body.Items {
+			index := slices.IndexFunc(order.OrderItems, func(item models.OrderItem) bool {
+				return int(item.ID) == requested.OrderItemID
+			})
+			if index < 0 {
+				return fmt.Errorf("%w: item %d is not part of this order", errReturnQuantity, requested.OrderItemID)
+			}
+			item := order.OrderItems[index]
+			returned[requested.OrderItemID] += requested.Quantity
+			if returned[requested.OrderItemID] > item.Quantity {
+				return fmt.Errorf("%w: %s", errReturnQuantity, item.Name)
+			}
+
+			refund := itemRefundAmount(order, item, requested.Quantity)
+			returnRequest.RefundAmount += refund
+			returnRequest.Items = append(returnRequest.Items, models.ReturnItem{
+				OrderItemID:  requested.OrderItemID,
+				ProductID:    item.ProductId,
+				VariantID:    item.VariantID,
+				Name:         orderItemName(item),
+				Quantity:     requested.Quantity,
+				RefundAmount: refund,
+			})
+		}
+		return tx.Create(&returnRequest).Error
+	})
+	if err != nil {
+		if errors.Is(err, errReturnQuantity) {
+			respondWithError(ctx, http.StatusBadRequest, "Invalid return items", err)
+			return
+		}
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create return request", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusCreated, gin.H{
+		"message":       "Return request submitted.",
+		"returnRequest": returnRequest,
+		"deadline":      deadline,
+	})
+}
+
+// findReturnRequest loads the return in the returnId parameter with its items and photos.
+// Customers can only see their own returns, others are reported as missing.
+func findReturnRequest(ctx *gin.Context) (models.ReturnRequest, bool) {
+	var returnRequest models.ReturnRequest
+
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return returnRequest, false
+	}
+	returnId, err := strconv.Atoi(ctx.Param("returnId"))
+	if err != nil {
+		sendErrorResponse(ctx, http.StatusBadRequest, "Invalid return ID")
+		return returnRequest, false
+	}
+
+	if err := initializers.DB.Preload("Items").Preload("Photos").First(&returnRequest, returnId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendErrorResponse(ctx, http.StatusNotFound, msgReturnNotFound)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch return request", err)
+		}
+		return returnRequest, false
+	}
+	if returnRequest.UserID != userID && !isAdminUser(ctx) {
+		sendErrorResponse(ctx, http.StatusNotFound, msgReturnNotFound)
+		return returnRequest, false
+	}
+	return returnRequest, true
+}
+
+// GetReturnRequests lists return requests, newest first. Customers see their own returns and
+// admins see everyone's. Both can filter by status (comma separated) and orderId.
+func GetReturnRequests(ctx *gin.Context) {
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "15"))
+	if limit < 1 || limit > 100 {
+		limit = 15
+	}
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+
+	query := initializers.DB.Model(&models.ReturnRequest{})
+	if !isAdminUser(ctx) {
+		query = query.Where("user_id = ?", userID)
+	}
+	if statuses := queryList(ctx, "status"); len(statuses) > 0 {
+		query = query.Where("status IN ?", statuses)
+	}
+	if orderID := ctx.Query("orderId"); orderID != "" {
+		query = query.Where("order_id = ?", orderID)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch return requests", err)
+		return
+	}
+	returnRequests := []models.ReturnRequest{}
+	if err := query.Preload("Items").Preload("Photos").
+		Order("created_at DESC").
+		Limit(limit).Offset((page - 1) * limit).
+		Find(&returnRequests).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch return requests", err)
+		return
+	}
+
+	previousPage := page - 1
+	nextPage := page + 1
+	totalPages := math.Ceil(float64(count) / float64(limit))
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"returnRequests": returnRequests,
+		"metadata": gin.H{
+			"total":        count,
+			"currentPage":  page,
+			"limit":        limit,
+			"hasPrevPage":  previousPage > 0,
+			"hasNextPage":  int(totalPages) > page,
+			"previousPage": previousPage,
+			"nextPage":     nextPage,
+		},
+	})
+}
+
+func GetReturnRequest(ctx *gin.Context) {
+	returnRequest, ok := findReturnRequest(ctx)
+	if !ok {
+		return
+	}
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"returnRequest": returnRequest})
+}
+
+// UploadReturnPhotos adds photos (the "photos" form field) to a return that has not been reviewed yet
+func UploadReturnPhotos(ctx *gin.Context) {
+	returnRequest, ok := findReturnRequest(ctx)
+	if !ok {
+		return
+	}
+	if returnRequest.Status != models.ReturnStatusRequested {
+		sendErrorResponse(ctx, http.StatusConflict, "Photos can only be added before the return is reviewed")
+		return
+	}
+
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid form data", err)
+		return
+	}
+	files := form.File["photos"]
+	if len(files) == 0 {
+		sendErrorResponse(ctx, http.StatusBadRequest, "No photos uploaded")
+		return
+	}
+	if len(returnRequest.Photos)+len(files) > maxReturnPhotos {
+		sendErrorResponse(ctx, http.StatusBadRequest, fmt.Sprintf("A return can have at most %d photos", maxReturnPhotos))
+		return
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Header.Get("Content-Type"), "image/") || file.Size > maxReturnPhotoSize {
+			sendErrorResponse(ctx, http.StatusBadRequest, "Photos must be images of up to 5MB")
+			return
+		}
+	}
+
+	uploader, err := getAWSUploader()
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to configure AWS", err)
+		return
+	}
+
+	var failedUploads []string
+	for _, file := range files {
+		key := fmt.Sprintf("returns/%d-%s-%s", returnRequest.ID, time.Now().Format("20060102150405"), file.Filename)
+		location, uploadErr := uploadPublicFile(uploader, file, key)
+		if uploadErr != nil {
+			log.Printf("Error uploading file %s: %v", file.Filename, uploadErr)
+			failedUploads = append(failedUploads, file.Filename)
+			continue
+		}
+
+		photo := models.ReturnPhoto{ReturnRequestID: returnRequest.ID, Url: location}
+		if err := initializers.DB.Create(&photo).Error; err != nil {
+			log.Printf("Error saving return photo: %v", err)
+			failedUploads = append(failedUploads, file.Filename)
+			continue
+		}
+		returnRequest.Photos = append(returnRequest.Photos, photo)
+	}
+
+	response := gin.H{"message": "Photos processed", "photos": returnRequest.Photos}
+	if len(failedUploads) > 0 {
+		response["failed"] = failedUploads
+	}
+	sendJSONResponse(ctx, http.StatusOK, response)
+}
+
+// moveReturnRequest changes the status of a return within tx, along with any other updates.
+// The status is checked again in the update so concurrent changes can't both apply.
+func moveReturnRequest(tx *gorm.DB, returnRequest *models.ReturnRequest, status string, updates map[string]any) error {
+	if !slices.Contains(returnTransitions[returnRequest.Status], status) {
+		return fmt.Errorf("a %s return can't be %s", returnRequest.Status, status)
+	}
+
+	updates["status"] = status
+	result := tx.Model(&models.ReturnRequest{}).
+		Where("id = ? AND status = ?", returnRequest.ID, returnRequest.Status).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("the return was changed by someone else, reload it and try again")
+	}
+	returnRequest.Status = status
+	return nil
+}
+
+// CancelReturnRequest lets a customer withdraw a return before it is reviewed
+func CancelReturnRequest(ctx *gin.Context) {
+	returnRequest, ok := findReturnRequest(ctx)
+	if !ok {
+		return
+	}
+	if err := moveReturnRequest(initializers.DB, &returnRequest, models.ReturnStatusCancelled, map[string]any{}); err != nil {
+		respondWithError(ctx, http.StatusConflict, "Failed to cancel return request", err)
+		return
+	}
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Return request cancelled.", "returnRequest": returnRequest})
+}
+
+// reviewReturnRequest approves or rejects a return, with an optional note for the customer
+func reviewReturnRequest(ctx *gin.Context, status, event string) {
+	returnRequest, ok := findReturnRequest(ctx)
+	if !ok {
+		return
+	}
+	var body struct {
+		Note string `json:"note"`
+	}
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+	}
+
+	now := time.Now()
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]any{"reviewed_at": now, "admin_note": strings.TrimSpace(body.Note)}
+		if err := moveReturnRequest(tx, &returnRequest, status, updates); err != nil {
+			return err
+		}
+		return queueOrderNotificationsByID(tx, event, uint(returnRequest.OrderID))
+	})
+	if err != nil {
+		respondWithError(ctx, http.StatusConflict, "Failed to update return request", err)
+		return
+	}
+	returnRequest.ReviewedAt = &now
+	returnRequest.AdminNote = strings.TrimSpace(body.Note)
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Return request " + status + ".", "returnRequest": returnRequest})
+}
+
+func ApproveReturnRequest(ctx *gin.Context) {
+	reviewReturnRequest(ctx, models.ReturnStatusApproved, orderEventReturnApproved)
+}
+
+func RejectReturnRequest(ctx *gin.Context) {
+	reviewReturnRequest(ctx, models.ReturnStatusRejected, orderEventReturnRejected)
+}
+
+// ReceiveReturnRequest records that the items of an approved return arrived and their
+// condition. Items with restock set are put back into stock if their product tracks it.
+func ReceiveReturnRequest(ctx *gin.Context) {
+	returnRequest, ok := findReturnRequest(ctx)
+	if !ok {
+		return
+	}
+	var body struct {
+		Note  string `json:"note"`
+		Items []struct {
+			ReturnItemID uint   `json:"returnItemId" binding:"required"`
+			Condition    string `json:"condition" binding:"required,oneof=resellable damaged faulty"`
+			Restock      bool   `json:"restock"`
+		} `json:"items" binding:"required,dive"`
+	}
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if len(body.Items) != len(returnRequest.Items) {
+		sendErrorResponse(ctx, http.StatusBadRequest, "The condition of every returned item is required")
+		return
+	}
+
+	seen := make(map[uint]bool, len(body.Items))
+	for _, received := range body.Items {
+		if seen[received.ReturnItemID] {
+			sendErrorResponse(ctx, http.StatusBadRequest, "Each returned item can only be listed once")
+			return
+		}
+		seen[received.ReturnItemID] = true
+	}
+
+	now := time.Now()
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]any{"received_at": now}
+		if note := strings.TrimSpace(body.Note); note != "" {
+			updates["admin_note"] = note
+		}
+		if err := moveReturnRequest(tx, &returnRequest, models.ReturnStatusReceived, updates); err != nil {
+			return err
+		}
+
+		for _, received := range body.Items {
+			index := slices.IndexFunc(returnRequest.Items, func(item models.ReturnItem) bool {
+				return item.ID == received.ReturnItemID
+			})
+			if index < 0 {
+				return fmt.Errorf("item %d is not part of this return", received.ReturnItemID)
+			}
+			item := &returnRequest.Items[index]
+
+			item.Condition = received.Condition
+			if received.Restock {
+				restocked, err := releaseStock(tx, item.ProductID, item.VariantID, item.Quantity)
+				if err != nil {
+					return err
+				}
+				item.Restocked = restocked
+			}
+			if err := tx.Model(item).Updates(map[string]any{"condition": item.Condition, "restocked": item.Restocked}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondWithError(ctx, http.StatusConflict, "Failed to receive return", err)
+		return
+	}
+	returnRequest.ReceivedAt = &now
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Return received.", "returnRequest": returnRequest})
+}
+
+// requestPesapalRefund asks Pesapal to refund amount of the payment with confirmationCode.
+// Pesapal reviews refunds before paying them out.
+func requestPesapalRefund(confirmationCode string, amount models.Money, username, remarks string) error {
+	token, err := GetPesapalAccessToken()
+	if err != nil {
+		return err
+	}
+
+	resp, err := resty.New().R().
+		SetHeader("Authorization", "Bearer "+token).
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Accept", "application/json").
+		SetBody(map[string]string{
+			"confirmation_code": confirmationCode,
+			"amount":            amount.String(),
+			"username":          username,
+			"remarks":           remarks,
+		}).
+		Post("https://pay.pesapal.com/v3/api/Transactions/RefundRequest")
+	if err != nil {
+		return err
+	}
+
+	var refundResp map[string]any
+	if err := json.Unmarshal(resp.Body(), &refundResp); err != nil {
+		return fmt.Errorf("invalid refund response from pesapal: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK || pesapalString(refundResp["status"]) != "200" {
+		return fmt.Errorf("pesapal refused the refund: %s", pesapalString(refundResp["message"]))
+	}
+	return nil
+}
+
+// restoreReturnRequest moves a return whose refund failed back to the status it had before,
+// along with the refund amount it suggested
+func restoreReturnRequest(returnRequest *models.ReturnRequest, status string, amount models.Money) error {
+	result := initializers.DB.Model(&models.ReturnRequest{}).
+		Where("id = ? AND status = ?", returnRequest.ID, models.ReturnStatusRefunding).
+		Updates(map[string]any{"status": status, "refund_amount": amount, "refund_method": ""})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("the return is no longer being refunded")
+	}
+	returnRequest.Status = status
+	returnRequest.RefundAmount = amount
+	returnRequest.RefundMethod = ""
+	return nil
+}
+
+// RefundReturnRequest refunds a received return of a paid order. The amount defaults to what
+// the customer paid for the returned items and can't take the order's refunds above its total.
+// With the pesapal method (the default) a refund is requested against the order's payment,
+// with manual the reference of a refund made outside the shop is recorded.
+//
+// The return is moved to refunding with the order locked before the refund is requested, so
+// concurrent refunds of an order count each other and a return can only be refunded once.
+func RefundReturnRequest(ctx *gin.Context) {
+	adminID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	returnRequest, ok := findReturnRequest(ctx)
+	if !ok {
+		return
+	}
+	var body struct {
+		Amount    *models.Money `json:"amount"`
+		Method    string        `json:"method"`
+		Reference string        `json:"reference"`
+	}
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+	}
+	if !slices.Contains(returnTransitions[returnRequest.Status], models.ReturnStatusRefunding) {
+		sendErrorResponse(ctx, http.StatusConflict, "Only received returns can be refunded, receive the items first")
+		return
+	}
+
+	method := body.Method
+	if method == "" {
+		method = models.RefundMethodPesapal
+	}
+	if method != models.RefundMethodPesapal && method != models.RefundMethodManual {
+		sendErrorResponse(ctx, http.StatusBadRequest, "method must be pesapal or manual")
+		return
+	}
+	if method == models.RefundMethodManual && strings.TrimSpace(body.Reference) == "" {
+		sendErrorResponse(ctx, http.StatusBadRequest, "reference is required for manual refunds")
+		return
+	}
+
+	var admin models.User
+	if err := initializers.DB.Select("username").First(&admin, adminID).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to fetch user", err)
+		return
+	}
+
+	previousStatus := returnRequest.Status
+	suggestedAmount := returnRequest.RefundAmount
+	amount := suggestedAmount
+	if body.Amount != nil {
+		amount = *body.Amount
+	}
+
+	var order models.Order
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Invoice").First(&order, returnRequest.OrderID).Error; err != nil {
+			return err
+		}
+		if order.PaymentStatus != paymentStatusCompleted {
+			return errOrderNotRefundable
+		}
+		refunded, err := refundedAmount(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		if amount <= 0 || amount > order.Total-refunded {
+			return fmt.Errorf("%w: it must be more than 0 and at most %s", errRefundAmount, (order.Total - refunded).Format(order.Currency))
+		}
+		if method == models.RefundMethodPesapal && orderInvoice(order).ConfirmationCode == "" {
+			return errNoPesapalPayment
+		}
+		return moveReturnRequest(tx, &returnRequest, models.ReturnStatusRefunding, map[string]any{
+			"refund_amount": amount,
+			"refund_method": method,
+		})
+	})
+	if errors.Is(err, errRefundAmount) {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid refund amount", err)
+		return
+	}
+	if err != nil {
+		respondWithError(ctx, http.StatusConflict, "Failed to refund return", err)
+		return
+	}
+
+	reference := strings.TrimSpace(body.Reference)
+	if method == models.RefundMethodPesapal {
+		invoice := orderInvoice(order)
+		remarks := fmt.Sprintf("Return #%d on order #%d", returnRequest.ID, order.ID)
+		if err := requestPesapalRefund(invoice.ConfirmationCode, amount, admin.Username, remarks); err != nil {
+			if restoreErr := restoreReturnRequest(&returnRequest, previousStatus, suggestedAmount); restoreErr != nil {
+				log.Printf("Error restoring return %d after a failed refund: %v", returnRequest.ID, restoreErr)
+			}
+			respondWithError(ctx, http.StatusBadGateway, "Refund request failed", err)
+			return
+		}
+		reference = invoice.ConfirmationCode
+	}
+
+	// The refund has been made, so from here failures are logged for the admin to reconcile.
+	// The return stays refunding until then and still counts towards the order's refunds.
+	now := time.Now()
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]any{
+			"refund_reference": reference,
+			"refunded_at":      now,
+		}
+		if err := moveReturnRequest(tx, &returnRequest, models.ReturnStatusRefunded, updates); err != nil {
+			return err
+		}
+		if err := queueOrderWebhook(tx, models.WebhookEventOrderRefunded, order.ID, gin.H{"returnId": returnRequest.ID, "amount": amount}); err != nil {
+			return err
+		}
+		return queueOrderNotificationsByID(tx, orderEventReturnRefunded, order.ID)
+	})
+	if err != nil {
+		log.Printf("Error recording refund of return %d: %v", returnRequest.ID, err)
+		respondWithError(ctx, http.StatusInternalServerError, "The refund was made but could not be recorded", err)
+		return
+	}
+	returnRequest.RefundAmount = amount
+	returnRequest.RefundMethod = method
+	returnRequest.RefundReference = reference
+	returnRequest.RefundedAt = &now
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Return refunded.", "returnRequest": returnRequest})
+}
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// queueSMS adds a text message to the SMS outbox within tx. Nothing is queued when SMS is
+// disabled. The phone number must already be in E.164 format.
+func queueSMS(tx *gorm.DB, phone, body string) error {
+	if !utils.SMSEnabled() {
+		return nil
+	}
+
+	message := models.OutboxTextMessage{
+		Recipient:     phone,
+		Body:          body,
+		Status:        models.OutboxStatusPending,
+		NextAttemptAt: time.Now(),
+		MaxAttempts:   outboxMaxAttempts,
+	}
+	return tx.Create(&message).Error
+}
+
+// claimOutboxTextMessages takes due text messages for this worker, leasing them like emails
+func claimOutboxTextMessages() ([]models.OutboxTextMessage, error) {
+	var messages []models.OutboxTextMessage
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ?", []string{models.OutboxStatusPending, models.OutboxStatusSending}, time.Now()).
+			Order("next_attempt_at asc").
+			Limit(outboxBatchSize).
+			Find(&messages).Error; err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(messages))
+		for _, message := range messages {
+			ids = append(ids, message.ID)
+		}
+		return tx.Model(&models.OutboxTextMessage{}).Where("id IN ?", ids).Updates(map[string]any{
+			"status":          models.OutboxStatusSending,
+			"next_attempt_at": time.Now().Add(outboxSendingLease),
+		}).Error
+	})
+	return messages, err
+}
+
+// deliverOutboxTextMessage makes one delivery attempt and records its outcome
+func deliverOutboxTextMessage(sender utils.SMSSender, message models.OutboxTextMessage) {
+	messageID, err := sender.Send(utils.SMSMessage{To: message.Recipient, Body: message.Body})
+
+	attempts := message.Attempts + 1
+	updates := map[string]any{"attempts": attempts}
+
+	switch {
+	case err == nil:
+		updates["status"] = models.OutboxStatusSent
+		updates["sent_at"] = time.Now()
+		updates["provider_message_id"] = messageID
+		updates["last_error"] = ""
+	case attempts >= message.MaxAttempts:
+		updates["status"] = models.OutboxStatusFailed
+		updates["last_error"] = err.Error()
+		log.Printf("Giving up on SMS %d to %s after %d attempts: %v", message.ID, message.Recipient, attempts, err)
+	default:
+		updates["status"] = models.OutboxStatusPending
+		updates["next_attempt_at"] = time.Now().Add(outboxBackoff(attempts))
+		updates["last_error"] = err.Error()
+		log.Printf("Error sending SMS %d to %s, will retry: %v", message.ID, message.Recipient, err)
+	}
+
+	if err := initializers.DB.Model(&models.OutboxTextMessage{}).Where("id = ?", message.ID).Updates(updates).Error; err != nil {
+		log.Println("Error recording SMS delivery attempt:", err)
+	}
+}
+
+func processSMSOutbox() {
+	sender := utils.DefaultSMSSender()
+	if sender == nil {
+		return
+	}
+
+	messages, err := claimOutboxTextMessages()
+	if err != nil {
+		log.Println("Error claiming outbox text messages:", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, message := range messages {
+		wg.Add(1)
+		go func(message models.OutboxTextMessage) {
+			defer wg.Done()
+			deliverOutboxTextMessage(sender, message)
+		}(message)
+	}
+	wg.Wait()
+}
+
+// StartSMSOutboxWorker delivers queued text messages in the background until the process exits
+func StartSMSOutboxWorker() {
+	go func() {
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			processSMSOutbox()
+		}
+	}()
+}
+
+// GetNotificationPreferences returns the authenticated user's notification settings
+func GetNotificationPreferences(ctx *gin.Context) {
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var user models.User
+	if err := initializers.DB.Select("id", "sms_opt_out", "subscribe_to_news").First(&user, userID).Error; err != nil {
+		respondWithError(ctx, http.StatusNotFound, "User not found", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"smsOptOut":       user.SMSOptOut,
+		"subscribeToNews": user.SubscribeToNews,
+	})
+}
+
+// UpdateNotificationPreferences lets the authenticated user opt out of, or back in to, SMS notifications
+func UpdateNotificationPreferences(ctx *gin.Context) {
+	userID, ok := getAuthenticatedUserID(ctx)
+	if !ok {
+		sendErrorResponse(ctx, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var preferences struct {
+		SMSOptOut *bool `json:"smsOptOut" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&preferences); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	if err := initializers.DB.Model(&models.User{}).Where("id = ?", userID).Update("sms_opt_out", *preferences.SMSOptOut).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to update notification preferences", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message":   "Notification preferences updated",
+		"smsOptOut": *preferences.SMSOptOut,
+	})
+}
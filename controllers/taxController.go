@@ -0,0 +1,359 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// pricesIncludeTax reports whether catalogue prices already contain VAT.
+// Set PRICES_INCLUDE_TAX=false when prices are entered before tax.
+func pricesIncludeTax() bool {
+	value := os.Getenv("PRICES_INCLUDE_TAX")
+	if value == "" {
+		return true
+	}
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Println("Invalid PRICES_INCLUDE_TAX, assuming prices include tax:", value)
+		return true
+	}
+	return include
+}
+
+// taxOn returns the tax contained in, or to be added to, an amount
+func taxOn(amount models.Money, rate float64, inclusive bool) models.Money {
+	if rate <= 0 {
+		return 0
+	}
+	if inclusive {
+		return amount.Percent(100 * rate / (100 + rate))
+	}
+	return amount.Percent(rate)
+}
+
+type taxRules struct {
+	classes         map[uint]models.TaxClass
+	categoryClasses map[uint]uint // tax class ids by category id
+	categories      categoryTree
+	defaultClass    models.TaxClass
+}
+
+func loadTaxRules(db *gorm.DB) (taxRules, error) {
+	rules := taxRules{
+		classes:         make(map[uint]models.TaxClass),
+		categoryClasses: make(map[uint]uint),
+	}
+
+	var classes []models.TaxClass
+	if err := db.Find(&classes).Error; err != nil {
+		return rules, err
+	}
+	for _, class := range classes {
+		rules.classes[class.ID] = class
+		if class.IsDefault {
+			rules.defaultClass = class
+		}
+	}
+
+	var categoryClasses []models.CategoryTaxClass
+	if err := db.Find(&categoryClasses).Error; err != nil {
+		return rules, err
+	}
+	for _, categoryClass := range categoryClasses {
+		rules.categoryClasses[categoryClass.CategoryID] = uint(categoryClass.TaxClassID)
+	}
+
+	var err error
+	rules.categories, err = loadCategoryTree(db)
+	return rules, err
+}
+
+// classFor picks the product's own tax class, then the class of its category or the nearest
+// ancestor with one, then the default
+func (rules taxRules) classFor(product models.Product) models.TaxClass {
+	if product.TaxClassID != nil {
+		if class, exists := rules.classes[uint(*product.TaxClassID)]; exists {
+			return class
+		}
+	}
+	if product.CategoryID != nil {
+		trail := rules.categories.breadcrumb(uint(*product.CategoryID))
+		for i := len(trail) - 1; i >= 0; i-- {
+			if classID, exists := rules.categoryClasses[trail[i].ID]; exists {
+				if class, exists := rules.classes[classID]; exists {
+					return class
+				}
+			}
+		}
+	}
+	return rules.defaultClass
+}
+
+// calculateOrderTaxes sets the tax on each item and on the order, and returns one tax line per
+// tax class. Delivery is taxed at the default rate. When prices exclude tax it is added to the total.
+func calculateOrderTaxes(db *gorm.DB, order *models.Order, items []models.OrderItem) ([]models.OrderTaxLine, error) {
+	rules, err := loadTaxRules(db)
+	if err != nil {
+		return nil, err
+	}
+
+	productIDs := make([]int, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductId)
+	}
+	products, err := findProductsByID(productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	inclusive := pricesIncludeTax()
+	order.PricesIncludeTax = inclusive
+
+	lines := make(map[uint]*models.OrderTaxLine)
+	addToLine := func(class models.TaxClass, amount, tax models.Money) {
+		line, exists := lines[class.ID]
+		if !exists {
+			line = &models.OrderTaxLine{
+				TaxClassID: int(class.ID),
+				Name:       class.Name,
+				Rate:       class.Rate,
+				Exempt:     class.Exempt,
+			}
+			lines[class.ID] = line
+		}
+		if inclusive {
+			amount -= tax
+		}
+		line.TaxableAmount += amount
+		line.TaxAmount += tax
+	}
+
+	var taxTotal models.Money
+	for i := range items {
+		class := rules.classFor(products[items[i].ProductId])
+		amount := items[i].Price.Mul(items[i].Quantity) - items[i].Discount
+		tax := taxOn(amount, class.Rate, inclusive)
+
+		items[i].TaxRate = class.Rate
+		items[i].TaxAmount = tax
+		taxTotal += tax
+		addToLine(class, amount, tax)
+	}
+
+	if order.DeliveryFee > 0 {
+		tax := taxOn(order.DeliveryFee, rules.defaultClass.Rate, inclusive)
+		taxTotal += tax
+		addToLine(rules.defaultClass, order.DeliveryFee, tax)
+	}
+
+	order.TaxTotal = taxTotal
+	if !inclusive {
+		order.Total += order.TaxTotal
+	}
+
+	taxLines := make([]models.OrderTaxLine, 0, len(lines))
+	for _, line := range lines {
+		taxLines = append(taxLines, *line)
+	}
+	sort.Slice(taxLines, func(i, j int) bool {
+		return taxLines[i].Rate > taxLines[j].Rate
+	})
+	return taxLines, nil
+}
+
+func GetTaxClasses(ctx *gin.Context) {
+	var classes []models.TaxClass
+	if result := initializers.DB.Order("rate desc, name asc").Find(&classes); result.Error != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch tax classes", result.Error)
+		return
+	}
+
+	var categoryClasses []models.CategoryTaxClass
+	if result := initializers.DB.Order("category asc").Find(&categoryClasses); result.Error != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch category tax classes", result.Error)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"taxClasses":         classes,
+		"categoryTaxClasses": categoryClasses,
+		"pricesIncludeTax":   pricesIncludeTax(),
+	})
+}
+
+// saveTaxClass creates or updates a tax class, keeping a single default class
+func saveTaxClass(class *models.TaxClass) error {
+	return initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if class.Exempt {
+			class.Rate = 0
+		}
+
+		if class.ID == 0 {
+			if err := tx.Create(class).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(class).
+			Select("*").
+			Omit("ID", "CreatedAt", "DeletedAt").
+			Updates(class).Error; err != nil {
+			return err
+		}
+		if class.IsDefault {
+			return tx.Model(&models.TaxClass{}).
+				Where("id <> ?", class.ID).
+				Update("is_default", false).Error
+		}
+		return nil
+	})
+}
+
+func CreateTaxClass(ctx *gin.Context) {
+	var class models.TaxClass
+	if err := ctx.ShouldBindJSON(&class); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if class.Rate < 0 || class.Rate > 100 {
+		sendErrorResponse(ctx, http.StatusBadRequest, "Tax rate must be between 0 and 100")
+		return
+	}
+	class.ID = 0
+
+	if err := saveTaxClass(&class); err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create tax class", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, class)
+}
+
+func UpdateTaxClass(ctx *gin.Context) {
+	taxClassId, err := strconv.Atoi(ctx.Param("taxClassId"))
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Unable to parse taxClassId")
+		return
+	}
+
+	var class models.TaxClass
+	if err := initializers.DB.First(&class, taxClassId).Error; err != nil {
+		sendErrorResponse(ctx, http.StatusNotFound, "Tax class not found")
+		return
+	}
+
+	var updateData models.TaxClass
+	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if updateData.Rate < 0 || updateData.Rate > 100 {
+		sendErrorResponse(ctx, http.StatusBadRequest, "Tax rate must be between 0 and 100")
+		return
+	}
+	updateData.ID = class.ID
+
+	// Orders keep the rate they were charged in their tax lines, so past orders are unaffected
+	if err := saveTaxClass(&updateData); err != nil {
+		log.Println("Failed to update tax class:", err)
+		sendErrorResponse(ctx, http.StatusInternalServerError, "Failed to update tax class")
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message":  "Tax class updated successfully",
+		"taxClass": updateData,
+	})
+}
+
+func DeleteTaxClass(ctx *gin.Context) {
+	taxClassId, err := strconv.Atoi(ctx.Param("taxClassId"))
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Unable to parse taxClassId")
+		return
+	}
+
+	var class models.TaxClass
+	if err := initializers.DB.First(&class, taxClassId).Error; err != nil {
+		sendErrorResponse(ctx, http.StatusNotFound, "Tax class not found")
+		return
+	}
+	if class.IsDefault {
+		sendErrorResponse(ctx, http.StatusBadRequest, "The default tax class cannot be deleted.")
+		return
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Product{}).Where("tax_class_id = ?", taxClassId).Update("tax_class_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tax_class_id = ?", taxClassId).Delete(&models.CategoryTaxClass{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&class).Error
+	})
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to delete tax class.")
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Tax class deleted successfully."})
+}
+
+// SetCategoryTaxClass assigns a tax class to every product in a category and its subcategories
+// without its own class
+func SetCategoryTaxClass(ctx *gin.Context) {
+	var categoryClass models.CategoryTaxClass
+	if err := ctx.ShouldBindJSON(&categoryClass); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	var category models.Category
+	if err := initializers.DB.First(&category, categoryClass.CategoryID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, msgCategoryNotFound, nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to validate category", err)
+		}
+		return
+	}
+	categoryClass.Category = category.Name
+
+	if err := initializers.DB.First(&models.TaxClass{}, categoryClass.TaxClassID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, "Tax class not found", nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to validate tax class", err)
+		}
+		return
+	}
+
+	var existing models.CategoryTaxClass
+	result := initializers.DB.Where("category_id = ?", categoryClass.CategoryID).Limit(1).Find(&existing)
+	if result.Error != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to save category tax class", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		categoryClass.ID = existing.ID
+		categoryClass.CreatedAt = existing.CreatedAt
+	}
+
+	if err := initializers.DB.Save(&categoryClass).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to save category tax class", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, categoryClass)
+}
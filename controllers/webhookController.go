@@ -0,0 +1,594 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	webhookPollInterval    = 5 * time.Second
+	webhookBatchSize       = 20
+	webhookMaxAttempts     = 10
+	webhookBaseBackoff     = time.Minute
+	webhookMaxBackoff      = 6 * time.Hour
+	webhookSendingLease    = 2 * time.Minute
+	webhookTimeout         = 10 * time.Second
+	webhookMaxResponseBody = 2048
+	msgWebhookNotFound     = "Webhook not found"
+	msgDeliveryNotFound    = "Delivery not found"
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// webhookPayload is the JSON body sent to endpoints
+type webhookPayload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+func webhookSubscribes(endpoint models.WebhookEndpoint, event string) bool {
+	for _, subscribed := range strings.Split(endpoint.Events, ",") {
+		subscribed = strings.TrimSpace(subscribed)
+		if subscribed == "*" || subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookEndpointsFor returns the active endpoints subscribed to an event
+func webhookEndpointsFor(tx *gorm.DB, event string) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	if err := tx.Where("active = ?", true).Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+
+	subscribed := endpoints[:0]
+	for _, endpoint := range endpoints {
+		if webhookSubscribes(endpoint, event) {
+			subscribed = append(subscribed, endpoint)
+		}
+	}
+	return subscribed, nil
+}
+
+// createWebhookDeliveries queues one event for each of the endpoints within tx
+func createWebhookDeliveries(tx *gorm.DB, endpoints []models.WebhookEndpoint, event string, data any) error {
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	eventID, err := utils.GenerateCode(12)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(webhookPayload{
+		ID:        "evt_" + eventID,
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			WebhookEndpointID: int(endpoint.ID),
+			EventID:           "evt_" + eventID,
+			Event:             event,
+			Payload:           string(payload),
+			Status:            models.OutboxStatusPending,
+			NextAttemptAt:     time.Now(),
+			MaxAttempts:       webhookMaxAttempts,
+		})
+	}
+	return tx.Create(&deliveries).Error
+}
+
+// queueOrderWebhook sends an order event with the full order. The order is only loaded when an
+// endpoint is subscribed to the event.
+func queueOrderWebhook(tx *gorm.DB, event string, orderID uint, extra gin.H) error {
+	endpoints, err := webhookEndpointsFor(tx, event)
+	if err != nil || len(endpoints) == 0 {
+		return err
+	}
+
+	var order models.Order
+	if err := tx.Preload("OrderItems").Preload("DeliveryAddress").Preload("TaxLines").Preload("Invoice").First(&order, orderID).Error; err != nil {
+		return err
+	}
+
+	data := gin.H{"order": order}
+	for key, value := range extra {
+		data[key] = value
+	}
+	return createWebhookDeliveries(tx, endpoints, event, data)
+}
+
+// notifyProductChanged sends the product.changed event after an admin changes a product.
+// The change is already saved, so failures are only logged.
+func notifyProductChanged(productID uint, action string) {
+	endpoints, err := webhookEndpointsFor(initializers.DB, models.WebhookEventProductChanged)
+	if err != nil || len(endpoints) == 0 {
+		if err != nil {
+			log.Println("Error finding webhooks for product change:", err)
+		}
+		return
+	}
+
+	data := gin.H{"action": action, "productId": productID}
+	if action != "deleted" {
+		var product models.Product
+		if err := initializers.DB.Preload("Specifications").Preload("Images").First(&product, productID).Error; err != nil {
+			log.Println("Error loading product for webhook:", err)
+			return
+		}
+		data["product"] = product
+	}
+
+	if err := createWebhookDeliveries(initializers.DB, endpoints, models.WebhookEventProductChanged, data); err != nil {
+		log.Println("Error queueing product webhook:", err)
+	}
+}
+
+// claimWebhookDeliveries takes due deliveries for this worker, leasing them like outbox emails
+func claimWebhookDeliveries() ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ?", []string{models.OutboxStatusPending, models.OutboxStatusSending}, time.Now()).
+			Order("next_attempt_at asc").
+			Limit(webhookBatchSize).
+			Find(&deliveries).Error; err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).Updates(map[string]any{
+			"status":          models.OutboxStatusSending,
+			"next_attempt_at": time.Now().Add(webhookSendingLease),
+		}).Error
+	})
+	return deliveries, err
+}
+
+// postWebhook sends a signed payload and returns the response status and the start of its body
+func postWebhook(endpoint models.WebhookEndpoint, delivery models.WebhookDelivery) (int, string, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Amexan-Webhooks/1.0")
+	req.Header.Set("X-Amexan-Event", delivery.Event)
+	req.Header.Set("X-Amexan-Event-Id", delivery.EventID)
+	req.Header.Set("X-Amexan-Delivery", strconv.Itoa(int(delivery.ID)))
+	req.Header.Set(utils.WebhookSignatureHeader, utils.SignWebhookPayload(endpoint.Secret, time.Now(), body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, webhookMaxResponseBody))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(responseBody), errors.New("endpoint returned " + resp.Status)
+	}
+	return resp.StatusCode, string(responseBody), nil
+}
+
+// deliverWebhook makes one delivery attempt and records its outcome
+func deliverWebhook(delivery models.WebhookDelivery) {
+	var endpoint models.WebhookEndpoint
+	err := initializers.DB.First(&endpoint, delivery.WebhookEndpointID).Error
+
+	attempt := models.WebhookDeliveryAttempt{
+		WebhookDeliveryID: int(delivery.ID),
+		Attempt:           delivery.Attempts + 1,
+	}
+	if err == nil && !endpoint.Active {
+		err = errors.New("endpoint is disabled")
+	}
+	if err == nil {
+		started := time.Now()
+		attempt.StatusCode, attempt.ResponseBody, err = postWebhook(endpoint, delivery)
+		attempt.DurationMs = time.Since(started).Milliseconds()
+	}
+	attempt.Succeeded = err == nil
+
+	updates := map[string]any{
+		"attempts":         attempt.Attempt,
+		"last_status_code": attempt.StatusCode,
+	}
+
+	switch {
+	case err == nil:
+		updates["status"] = models.OutboxStatusSent
+		updates["delivered_at"] = time.Now()
+		updates["last_error"] = ""
+	case attempt.Attempt >= delivery.MaxAttempts || !endpoint.Active:
+		attempt.Error = err.Error()
+		updates["status"] = models.OutboxStatusFailed
+		updates["last_error"] = attempt.Error
+		log.Printf("Giving up on webhook delivery %d after %d attempts: %v", delivery.ID, attempt.Attempt, err)
+	default:
+		attempt.Error = err.Error()
+		updates["status"] = models.OutboxStatusPending
+		updates["next_attempt_at"] = time.Now().Add(retryBackoff(webhookBaseBackoff, webhookMaxBackoff, attempt.Attempt))
+		updates["last_error"] = attempt.Error
+		log.Printf("Error delivering webhook %d to %s, will retry: %v", delivery.ID, endpoint.URL, err)
+	}
+
+	if err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error
+	}); err != nil {
+		log.Println("Error recording webhook delivery attempt:", err)
+	}
+}
+
+func processWebhooks() {
+	deliveries, err := claimWebhookDeliveries()
+	if err != nil {
+		log.Println("Error claiming webhook deliveries:", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery models.WebhookDelivery) {
+			defer wg.Done()
+			deliverWebhook(delivery)
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+// StartWebhookWorker delivers queued webhook events in the background until the process exits
+func StartWebhookWorker() {
+	go func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			processWebhooks()
+		}
+	}()
+}
+
+type webhookEndpointData struct {
+	URL         string   `json:"url" binding:"required,url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events" binding:"required,min=1"`
+	Active      *bool    `json:"active"`
+}
+
+// validate checks the URL scheme and event names and returns the events as stored
+func (data webhookEndpointData) validate() (string, error) {
+	parsed, err := url.Parse(data.URL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return "", errors.New("url must be an http or https URL")
+	}
+
+	for _, event := range data.Events {
+		if event != "*" && !slices.Contains(models.WebhookEvents, event) {
+			return "", errors.New("unknown event " + event)
+		}
+	}
+	return strings.Join(data.Events, ","), nil
+}
+
+func newWebhookSecret() (string, error) {
+	secret, err := utils.GenerateCode(32)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + secret, nil
+}
+
+func findWebhookEndpoint(ctx *gin.Context) (models.WebhookEndpoint, bool) {
+	var endpoint models.WebhookEndpoint
+
+	webhookId, err := strconv.Atoi(ctx.Param("webhookId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid webhook ID", err)
+		return endpoint, false
+	}
+
+	if err := initializers.DB.First(&endpoint, webhookId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, msgWebhookNotFound, nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Unable to retrieve webhook", err)
+		}
+		return endpoint, false
+	}
+	return endpoint, true
+}
+
+func GetWebhookEndpoints(ctx *gin.Context) {
+	var endpoints []models.WebhookEndpoint
+	if err := initializers.DB.Order("created_at desc").Find(&endpoints).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch webhooks", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"webhooks": endpoints, "events": models.WebhookEvents})
+}
+
+func GetWebhookEndpoint(ctx *gin.Context) {
+	endpoint, ok := findWebhookEndpoint(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, endpoint)
+}
+
+// CreateWebhookEndpoint registers an endpoint. Its signing secret is only returned here.
+func CreateWebhookEndpoint(ctx *gin.Context) {
+	var endpointData webhookEndpointData
+	if err := ctx.ShouldBindJSON(&endpointData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	events, err := endpointData.validate()
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid webhook", err)
+		return
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create webhook", err)
+		return
+	}
+
+	endpoint := models.WebhookEndpoint{
+		URL:         endpointData.URL,
+		Description: endpointData.Description,
+		Events:      events,
+		Secret:      secret,
+		Active:      endpointData.Active == nil || *endpointData.Active,
+	}
+	if err := initializers.DB.Create(&endpoint).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to create webhook", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"webhook": endpoint, "secret": secret})
+}
+
+func UpdateWebhookEndpoint(ctx *gin.Context) {
+	endpoint, ok := findWebhookEndpoint(ctx)
+	if !ok {
+		return
+	}
+
+	var endpointData webhookEndpointData
+	if err := ctx.ShouldBindJSON(&endpointData); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	events, err := endpointData.validate()
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid webhook", err)
+		return
+	}
+
+	updates := map[string]any{
+		"url":         endpointData.URL,
+		"description": endpointData.Description,
+		"events":      events,
+	}
+	if endpointData.Active != nil {
+		updates["active"] = *endpointData.Active
+	}
+	if err := initializers.DB.Model(&endpoint).Updates(updates).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to update webhook", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{
+		"message": "Webhook updated successfully",
+		"webhook": endpoint,
+	})
+}
+
+// RotateWebhookSecret replaces an endpoint's signing secret and returns the new one
+func RotateWebhookSecret(ctx *gin.Context) {
+	endpoint, ok := findWebhookEndpoint(ctx)
+	if !ok {
+		return
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to rotate secret", err)
+		return
+	}
+	if err := initializers.DB.Model(&endpoint).Update("secret", secret).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to rotate secret", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"secret": secret})
+}
+
+func DeleteWebhookEndpoint(ctx *gin.Context) {
+	endpoint, ok := findWebhookEndpoint(ctx)
+	if !ok {
+		return
+	}
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_endpoint_id = ?", endpoint.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&endpoint).Error
+	})
+	if err != nil {
+		log.Println(err)
+		sendErrorResponse(ctx, http.StatusBadRequest, "Failed to delete webhook.")
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Webhook deleted successfully."})
+}
+
+// GetWebhookDeliveries is the delivery log of an endpoint, optionally filtered by status or event
+func GetWebhookDeliveries(ctx *gin.Context) {
+	endpoint, ok := findWebhookEndpoint(ctx)
+	if !ok {
+		return
+	}
+
+	var deliveries []models.WebhookDelivery
+
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "15"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 15
+	}
+	offset := (page - 1) * limit
+
+	query := initializers.DB.Model(&models.WebhookDelivery{}).Where("webhook_endpoint_id = ?", endpoint.ID)
+	if status := ctx.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if event := ctx.Query("event"); event != "" {
+		query = query.Where("event = ?", event)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch deliveries", err)
+		return
+	}
+
+	if err := query.Omit("payload").
+		Order("created_at desc").
+		Limit(limit).
+		Offset(offset).
+		Find(&deliveries).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Unable to fetch deliveries", err)
+		return
+	}
+
+	previousPage := page - 1
+	nextPage := page + 1
+	totalPages := math.Ceil(float64(count) / float64(limit))
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"metadata": gin.H{
+			"total":        count,
+			"currentPage":  page,
+			"limit":        limit,
+			"hasPrevPage":  previousPage > 0,
+			"hasNextPage":  int(totalPages) > page,
+			"previousPage": previousPage,
+			"nextPage":     nextPage,
+		},
+	})
+}
+
+func findWebhookDelivery(ctx *gin.Context) (models.WebhookDelivery, bool) {
+	var delivery models.WebhookDelivery
+
+	endpoint, ok := findWebhookEndpoint(ctx)
+	if !ok {
+		return delivery, false
+	}
+
+	deliveryId, err := strconv.Atoi(ctx.Param("deliveryId"))
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Invalid delivery ID", err)
+		return delivery, false
+	}
+
+	if err := initializers.DB.Preload("AttemptLog").
+		Where("webhook_endpoint_id = ?", endpoint.ID).
+		First(&delivery, deliveryId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(ctx, http.StatusNotFound, msgDeliveryNotFound, nil)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, "Unable to retrieve delivery", err)
+		}
+		return delivery, false
+	}
+	return delivery, true
+}
+
+// GetWebhookDelivery shows a delivery with its payload and attempts
+func GetWebhookDelivery(ctx *gin.Context) {
+	delivery, ok := findWebhookDelivery(ctx)
+	if !ok {
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"delivery": delivery})
+}
+
+// RedeliverWebhook queues a delivery to be sent again with the same event id and payload
+func RedeliverWebhook(ctx *gin.Context) {
+	delivery, ok := findWebhookDelivery(ctx)
+	if !ok {
+		return
+	}
+
+	if delivery.Status == models.OutboxStatusPending || delivery.Status == models.OutboxStatusSending {
+		sendErrorResponse(ctx, http.StatusConflict, "Delivery is already waiting to be sent")
+		return
+	}
+
+	// Attempts start again from zero, earlier ones stay in the attempt log
+	if err := initializers.DB.Model(&delivery).Updates(map[string]any{
+		"status":          models.OutboxStatusPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error; err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to redeliver webhook", err)
+		return
+	}
+
+	sendJSONResponse(ctx, http.StatusOK, gin.H{"message": "Webhook queued for redelivery."})
+}
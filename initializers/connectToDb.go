@@ -3,16 +3,26 @@ package initializers
 import (
 	"log"
 	"os"
+	"time"
 
+	driver "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
+// DBLocation is the time zone DATETIME columns are written and read in, the loc of DB_DSN
+var DBLocation = time.UTC
+
 func ConnectToDB() {
-	var err error
 	dsn := os.Getenv("DB_DSN")
+	config, err := driver.ParseDSN(dsn)
+	if err != nil {
+		log.Fatal("Invalid database DSN:", err)
+	}
+	DBLocation = config.Loc
+
 	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
 	if err != nil {
 		log.Fatal("Error connecting to the database:", err)
@@ -0,0 +1,42 @@
+package initializers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tableIndex is an index that can't be declared with struct tags, usually because it
+// includes a column of the embedded gorm.Model
+type tableIndex struct {
+	Table   string
+	Name    string
+	Columns []string
+}
+
+// Indexes behind the admin order search. Each sortable column is paired with id so cursor
+// pagination can seek straight to the next page.
+var tableIndexes = []tableIndex{
+	{"orders", "idx_orders_created_at", []string{"created_at", "id"}},
+	{"orders", "idx_orders_updated_at", []string{"updated_at", "id"}},
+	{"orders", "idx_orders_total", []string{"total", "id"}},
+	{"orders", "idx_orders_status", []string{"status", "created_at"}},
+	{"orders", "idx_orders_payment_status", []string{"payment_status", "created_at"}},
+	{"orders", "idx_orders_user", []string{"user_id", "created_at"}},
+	{"orders", "idx_orders_email", []string{"email"}},
+	{"orders", "idx_orders_phone", []string{"phone"}},
+	{"order_items", "idx_order_items_product", []string{"product_id", "order_id"}},
+}
+
+// ensureIndexes creates the indexes in tableIndexes that don't exist yet
+func ensureIndexes() error {
+	for _, index := range tableIndexes {
+		if DB.Migrator().HasIndex(index.Table, index.Name) {
+			continue
+		}
+		statement := fmt.Sprintf("CREATE INDEX %s ON %s (%s)", index.Name, index.Table, strings.Join(index.Columns, ", "))
+		if err := DB.Exec(statement).Error; err != nil {
+			return fmt.Errorf("creating index %s: %w", index.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package initializers
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"gorm.io/gorm"
+)
+
+const productBrandsMigration = "product_brands"
+
+// migrateProductBrands creates a brand for each brand name used by products and links the
+// products to it. Names that only differ in case or punctuation, like "Samsung" and
+// "SAMSUNG", share a brand. It runs after AutoMigrate and only once.
+func migrateProductBrands() error {
+	var applied int64
+	if err := DB.Model(&models.SchemaMigration{}).Where("id = ?", productBrandsMigration).Count(&applied).Error; err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	var names []string
+	if err := DB.Unscoped().Model(&models.Product{}).
+		Where("brand_id IS NULL AND brand <> ''").
+		Distinct().Pluck("brand", &names).Error; err != nil {
+		return err
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		brands := make(map[string]models.Brand)
+		for _, name := range names {
+			slug := utils.Slugify(name)
+			if slug == "" {
+				continue
+			}
+
+			brand, exists := brands[slug]
+			if !exists {
+				if err := tx.Where("slug = ?", slug).Limit(1).Find(&brand).Error; err != nil {
+					return err
+				}
+				if brand.ID == 0 {
+					brand = models.Brand{Name: strings.TrimSpace(name), Slug: slug}
+					if err := tx.Create(&brand).Error; err != nil {
+						return err
+					}
+				}
+				brands[slug] = brand
+			}
+
+			if err := tx.Unscoped().Model(&models.Product{}).
+				Where("brand = ? AND brand_id IS NULL", name).
+				Updates(map[string]any{"brand_id": brand.ID, "brand": brand.Name}).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.SchemaMigration{ID: productBrandsMigration, AppliedAt: time.Now()}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Product brands migrated, %d names found.", len(names))
+	return nil
+}
@@ -0,0 +1,71 @@
+package initializers
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/Kariqs/amexan-api/utils"
+	"gorm.io/gorm"
+)
+
+const productCategoriesMigration = "product_categories"
+
+// migrateProductCategories creates a top level category for each category name used by
+// products and links the products to it. Names that only differ in case or punctuation, like
+// "Phones" and "phones", share a category. It runs after AutoMigrate and only once.
+func migrateProductCategories() error {
+	var applied int64
+	if err := DB.Model(&models.SchemaMigration{}).Where("id = ?", productCategoriesMigration).Count(&applied).Error; err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	var names []string
+	if err := DB.Unscoped().Model(&models.Product{}).
+		Where("category_id IS NULL AND category <> ''").
+		Distinct().Pluck("category", &names).Error; err != nil {
+		return err
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		categories := make(map[string]models.Category)
+		for _, name := range names {
+			slug := utils.Slugify(name)
+			if slug == "" {
+				continue
+			}
+
+			category, exists := categories[slug]
+			if !exists {
+				if err := tx.Where("slug = ?", slug).Limit(1).Find(&category).Error; err != nil {
+					return err
+				}
+				if category.ID == 0 {
+					category = models.Category{Name: strings.TrimSpace(name), Slug: slug}
+					if err := tx.Create(&category).Error; err != nil {
+						return err
+					}
+				}
+				categories[slug] = category
+			}
+
+			if err := tx.Unscoped().Model(&models.Product{}).
+				Where("category = ? AND category_id IS NULL", name).
+				Updates(map[string]any{"category_id": category.ID, "category": category.Name}).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.SchemaMigration{ID: productCategoriesMigration, AppliedAt: time.Now()}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Product categories migrated, %d names found.", len(names))
+	return nil
+}
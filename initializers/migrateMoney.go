@@ -0,0 +1,80 @@
+package initializers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Kariqs/amexan-api/models"
+	"gorm.io/gorm"
+)
+
+const moneyMinorUnitsMigration = "money_minor_units"
+
+// Money columns that held whole or fractional shillings before amounts were stored in cents
+var moneyColumns = map[string][]string{
+	"products":           {"price"},
+	"cart_items":         {"price"},
+	"orders":             {"total", "subtotal", "discount", "delivery_fee", "tax_total"},
+	"order_items":        {"price", "discount", "tax_amount"},
+	"coupons":            {"max_discount", "min_order_value"},
+	"coupon_redemptions": {"discount"},
+	"delivery_zones":     {"fee", "free_delivery_threshold"},
+	"order_tax_lines":    {"taxable_amount", "tax_amount"},
+}
+
+// migrateMoneyToMinorUnits scales existing amounts to cents. It must run before AutoMigrate
+// changes the float columns to integers so no fractions are lost, and only runs once.
+func migrateMoneyToMinorUnits() error {
+	if err := DB.AutoMigrate(&models.SchemaMigration{}); err != nil {
+		return err
+	}
+
+	var applied int64
+	if err := DB.Model(&models.SchemaMigration{}).Where("id = ?", moneyMinorUnitsMigration).Count(&applied).Error; err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	migrator := DB.Migrator()
+
+	// Fixed coupon amounts move out of the percentage value column
+	if migrator.HasTable("coupons") && !migrator.HasColumn(&models.Coupon{}, "Amount") {
+		if err := migrator.AddColumn(&models.Coupon{}, "Amount"); err != nil {
+			return err
+		}
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for table, columns := range moneyColumns {
+			if !migrator.HasTable(table) {
+				continue
+			}
+			for _, column := range columns {
+				if !migrator.HasColumn(table, column) {
+					continue
+				}
+				query := fmt.Sprintf("UPDATE `%s` SET `%s` = ROUND(`%s` * 100)", table, column, column)
+				if err := tx.Exec(query).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if migrator.HasTable("coupons") {
+			if err := tx.Exec("UPDATE `coupons` SET `amount` = ROUND(`value` * 100), `value` = 0 WHERE `type` = ?", models.CouponTypeFixed).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.SchemaMigration{ID: moneyMinorUnitsMigration, AppliedAt: time.Now()}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Println("Money columns migrated to minor units.")
+	return nil
+}
@@ -0,0 +1,30 @@
+package initializers
+
+import (
+	"log"
+
+	"github.com/Kariqs/amexan-api/models"
+)
+
+// SeedTaxClasses creates the Kenyan VAT classes the first time the app starts
+func SeedTaxClasses() {
+	var count int64
+	if err := DB.Model(&models.TaxClass{}).Count(&count).Error; err != nil {
+		log.Println("Error checking tax classes:", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	taxClasses := []models.TaxClass{
+		{Name: "Standard rated", Description: "Standard VAT rate", Rate: 16, IsDefault: true},
+		{Name: "Zero rated", Description: "Taxable supplies charged VAT at 0%", Rate: 0},
+		{Name: "Exempt", Description: "Supplies exempt from VAT", Rate: 0, Exempt: true},
+	}
+	if err := DB.Create(&taxClasses).Error; err != nil {
+		log.Println("Error seeding tax classes:", err)
+		return
+	}
+	log.Println("Tax classes seeded successfully.")
+}
@@ -7,13 +7,55 @@ import (
 )
 
 func SyncDatabase() {
+	if err := migrateMoneyToMinorUnits(); err != nil {
+		log.Fatal("Error migrating money columns:", err)
+	}
+
 	DB.AutoMigrate(
 		&models.User{},
 		&models.Product{},
 		&models.ProductImage{},
 		&models.ProductSpecs{},
+		&models.ProductVariant{},
+		&models.Category{},
+		&models.Brand{},
 		&models.OrderItem{},
 		&models.Order{},
+		&models.Cart{},
+		&models.CartItem{},
+		&models.IdempotencyKey{},
+		&models.Coupon{},
+		&models.CouponRedemption{},
+		&models.DeliveryZone{},
+		&models.Address{},
+		&models.OrderAddress{},
+		&models.TaxClass{},
+		&models.CategoryTaxClass{},
+		&models.OrderTaxLine{},
+		&models.Invoice{},
+		&models.InvoiceCounter{},
+		&models.OutboxEmail{},
+		&models.OutboxEmailAttachment{},
+		&models.OutboxEmailAttempt{},
+		&models.NewsletterSubscriber{},
+		&models.NewsletterCampaign{},
+		&models.NewsletterDelivery{},
+		&models.OutboxTextMessage{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
+		&models.WebhookDeliveryAttempt{},
+		&models.ReturnRequest{},
+		&models.ReturnItem{},
+		&models.ReturnPhoto{},
 	)
+	if err := ensureIndexes(); err != nil {
+		log.Fatal("Error creating indexes:", err)
+	}
+	if err := migrateProductCategories(); err != nil {
+		log.Fatal("Error migrating product categories:", err)
+	}
+	if err := migrateProductBrands(); err != nil {
+		log.Fatal("Error migrating product brands:", err)
+	}
 	log.Println("Database synced successfully.")
 }
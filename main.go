@@ -3,6 +3,7 @@ package main
 import (
 	"time"
 
+	"github.com/Kariqs/amexan-api/controllers"
 	"github.com/Kariqs/amexan-api/initializers"
 	"github.com/Kariqs/amexan-api/routes"
 	"github.com/gin-contrib/cors"
@@ -13,6 +14,7 @@ func init() {
 	initializers.LoadEnv()
 	initializers.ConnectToDB()
 	initializers.SyncDatabase()
+	initializers.SeedTaxClasses()
 }
 
 func main() {
@@ -20,8 +22,8 @@ func main() {
 	server.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:4200", "https://www.amexan.store", "https://pay.pesapal.com"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Cart-Token", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -29,5 +31,22 @@ func main() {
 	routes.AuthRoutes(server)
 	routes.ProductRoutes(server)
 	routes.OrderRoutes(server)
+	routes.CartRoutes(server)
+	routes.CouponRoutes(server)
+	routes.DeliveryRoutes(server)
+	routes.AddressRoutes(server)
+	routes.TaxRoutes(server)
+	routes.EmailRoutes(server)
+	routes.NewsletterRoutes(server)
+	routes.WebhookRoutes(server)
+	routes.AnalyticsRoutes(server)
+	routes.ReturnRoutes(server)
+	routes.CategoryRoutes(server)
+	routes.BrandRoutes(server)
+
+	controllers.StartEmailOutboxWorker()
+	controllers.StartSMSOutboxWorker()
+	controllers.StartNewsletterWorker()
+	controllers.StartWebhookWorker()
 	server.Run()
 }
@@ -0,0 +1,204 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Kariqs/amexan-api/initializers"
+	"github.com/Kariqs/amexan-api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	defaultIdempotencyKeyTTL  = 24 * time.Hour
+	maxIdempotencyKeyLength   = 255
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	cartTokenHeader           = "X-Cart-Token" // the guest cart header read by the cart endpoints
+)
+
+// idempotencyKeyTTL reads how long keys are kept from IDEMPOTENCY_KEY_TTL, e.g. "24h"
+func idempotencyKeyTTL() time.Duration {
+	if value := os.Getenv("IDEMPOTENCY_KEY_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Println("Invalid IDEMPOTENCY_KEY_TTL, using default:", value)
+	}
+	return defaultIdempotencyKeyTTL
+}
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w responseRecorder) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// idempotencyScope keeps keys from different users apart. Guests are told apart by their cart
+// token or, for orders placed without a cart, by the email in the request together with the key.
+// Guests with neither have no scope, since a shared one would replay one guest's response, and
+// its order access token, to another.
+func idempotencyScope(ctx *gin.Context, key string, body []byte) (string, bool) {
+	if userClaims, exists := ctx.Get("user"); exists {
+		if claims, ok := userClaims.(jwt.MapClaims); ok {
+			if userID, ok := claims["user_id"].(float64); ok {
+				return fmt.Sprintf("user:%d", int(userID)), true
+			}
+		}
+	}
+	if cartToken := ctx.GetHeader(cartTokenHeader); cartToken != "" {
+		hash := sha256.Sum256([]byte(cartToken))
+		return "guest:" + hex.EncodeToString(hash[:24]), true
+	}
+
+	var request struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &request) == nil && request.Email != "" {
+		hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(request.Email)) + "\n" + key))
+		return "guest-email:" + hex.EncodeToString(hash[:24]), true
+	}
+	return "", false
+}
+
+func requestFingerprint(ctx *gin.Context, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(ctx.Request.Method + " " + ctx.Request.URL.Path + "?" + ctx.Request.URL.RawQuery + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Idempotency replays the stored response when a request is retried with the same
+// Idempotency-Key header, so retries don't repeat side effects such as creating orders.
+// Guest requests are deduplicated when they carry a cart token or an email. Server errors
+// aren't stored, the key is released so the request can be retried.
+func Idempotency() gin.HandlerFunc {
+	ttl := idempotencyKeyTTL()
+
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Unable to read request body"})
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope, scoped := idempotencyScope(ctx, key, body)
+		if !scoped {
+			ctx.Next()
+			return
+		}
+
+		fingerprint := requestFingerprint(ctx, body)
+
+		var stored models.IdempotencyKey
+		result := initializers.DB.Where("`key` = ? AND scope = ?", key, scope).Limit(1).Find(&stored)
+		if result.Error != nil {
+			log.Println("Idempotency key lookup error:", result.Error)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+
+		if result.RowsAffected > 0 && stored.ExpiresAt.After(time.Now()) {
+			if stored.Fingerprint != fingerprint {
+				ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"message": "Idempotency-Key was already used with a different request"})
+				return
+			}
+			if !stored.Completed {
+				ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "A request with this Idempotency-Key is still being processed"})
+				return
+			}
+
+			ctx.Header(idempotencyReplayedHeader, "true")
+			ctx.Data(stored.StatusCode, stored.ContentType, []byte(stored.ResponseBody))
+			ctx.Abort()
+			return
+		}
+
+		// Expired keys can be reused, and this is a good moment to clear out the others
+		if err := initializers.DB.Unscoped().Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyKey{}).Error; err != nil {
+			log.Println("Error deleting expired idempotency keys:", err)
+		}
+
+		stored = models.IdempotencyKey{
+			Key:         key,
+			Scope:       scope,
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().Add(ttl),
+		}
+		if err := initializers.DB.Create(&stored).Error; err != nil {
+			// Another request with the same key got in first
+			if isDuplicateEntry(err) {
+				ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "A request with this Idempotency-Key is still being processed"})
+				return
+			}
+			log.Println("Error saving idempotency key:", err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+
+		// A panicking handler releases the key so the request can be retried straight away
+		defer func() {
+			if r := recover(); r != nil {
+				if err := initializers.DB.Unscoped().Delete(&stored).Error; err != nil {
+					log.Println("Error releasing idempotency key:", err)
+				}
+				panic(r)
+			}
+		}()
+
+		recorder := responseRecorder{ResponseWriter: ctx.Writer, body: &bytes.Buffer{}}
+		ctx.Writer = recorder
+		ctx.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			if err := initializers.DB.Unscoped().Delete(&stored).Error; err != nil {
+				log.Println("Error releasing idempotency key:", err)
+			}
+			return
+		}
+		if err := initializers.DB.Model(&stored).Updates(map[string]any{
+			"completed":     true,
+			"status_code":   recorder.Status(),
+			"content_type":  recorder.Header().Get("Content-Type"),
+			"response_body": recorder.body.String(),
+		}).Error; err != nil {
+			log.Println("Error saving idempotent response:", err)
+		}
+	}
+}
+
+// isDuplicateEntry detects MySQL unique constraint violations
+func isDuplicateEntry(err error) bool {
+	return strings.Contains(err.Error(), "Duplicate entry")
+}
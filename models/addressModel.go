@@ -0,0 +1,33 @@
+package models
+
+import "gorm.io/gorm"
+
+type Address struct {
+	gorm.Model
+	UserID    int    `json:"userId" gorm:"index"`
+	Label     string `json:"label" binding:"required"`
+	FirstName string `json:"firstName" binding:"required"`
+	LastName  string `json:"lastName" binding:"required"`
+	Phone     string `json:"phone" binding:"required"`
+	County    string `json:"county" binding:"required"`
+	Town      string `json:"town" binding:"required"`
+	Street    string `json:"street"`
+	Landmark  string `json:"landmark"`
+	IsDefault bool   `json:"isDefault"`
+}
+
+// OrderAddress is the copy of the delivery address kept with an order.
+// It never changes after the order is placed.
+type OrderAddress struct {
+	gorm.Model
+	OrderID   int    `json:"orderId" gorm:"uniqueIndex"`
+	AddressID int    `json:"addressId"`
+	Label     string `json:"label"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Phone     string `json:"phone"`
+	County    string `json:"county"`
+	Town      string `json:"town"`
+	Street    string `json:"street"`
+	Landmark  string `json:"landmark"`
+}
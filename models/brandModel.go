@@ -0,0 +1,14 @@
+package models
+
+import "gorm.io/gorm"
+
+// Brand is a product brand. Products keep the brand's name in Product.Brand, which is updated
+// when the brand is renamed.
+type Brand struct {
+	gorm.Model
+	Name         string `json:"name" binding:"required" gorm:"size:128"`
+	Slug         string `json:"slug" gorm:"size:128;uniqueIndex"`
+	Description  string `json:"description" gorm:"type:text"`
+	LogoURL      string `json:"logoUrl"`
+	ProductCount int64  `json:"productCount" gorm:"->;-:migration"` // filled by queries that count products
+}
@@ -0,0 +1,28 @@
+package models
+
+import "gorm.io/gorm"
+
+type Cart struct {
+	gorm.Model
+	UserID     int        `json:"userId" gorm:"index"`
+	GuestToken string     `json:"-" gorm:"size:64;index"`
+	CartItems  []CartItem `json:"cartItems" gorm:"foreignKey:CartID;constraint:OnDelete:CASCADE"`
+}
+
+type CartItem struct {
+	gorm.Model
+	CartID      int    `json:"cartId" gorm:"index"`
+	ProductID   int    `json:"productId"`
+	VariantID   int    `json:"variantId"`
+	SKU         string `json:"sku"`
+	Name        string `json:"name"`
+	VariantName string `json:"variantName"`
+	Price       Money  `json:"price"`
+	Quantity    int    `json:"quantity"`
+
+	// Populated when the cart is revalidated against the current product data
+	Available      bool  `json:"available" gorm:"-"`
+	PriceChanged   bool  `json:"priceChanged" gorm:"-"`
+	PreviousPrice  Money `json:"previousPrice,omitempty" gorm:"-"`
+	StockAvailable *int  `json:"stockAvailable,omitempty" gorm:"-"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "gorm.io/gorm"
+
+// Category is a node of the product category tree. Products keep the name of their category
+// in Product.Category, which is updated when the category is renamed.
+type Category struct {
+	gorm.Model
+	ParentID    *int       `json:"parentId" gorm:"index"`
+	Name        string     `json:"name" binding:"required" gorm:"size:128"`
+	Slug        string     `json:"slug" gorm:"size:128;uniqueIndex"`
+	Description string     `json:"description" gorm:"type:text"`
+	ImageURL    string     `json:"imageUrl"`
+	SortOrder   int        `json:"sortOrder"`
+	Children    []Category `json:"children,omitempty" gorm:"-"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	CouponTypePercentage   = "percentage"
+	CouponTypeFixed        = "fixed"
+	CouponTypeFreeDelivery = "free_delivery"
+)
+
+type Coupon struct {
+	gorm.Model
+	Code          string     `json:"code" binding:"required" gorm:"size:64;uniqueIndex"`
+	Description   string     `json:"description"`
+	Type          string     `json:"type" binding:"required,oneof=percentage fixed free_delivery"`
+	Value         float64    `json:"value"`       // percentage off for percentage coupons
+	Amount        Money      `json:"amount"`      // amount off for fixed coupons
+	MaxDiscount   Money      `json:"maxDiscount"` // caps percentage discounts, 0 means no cap
+	MinOrderValue Money      `json:"minOrderValue"`
+	StartsAt      *time.Time `json:"startsAt"`
+	EndsAt        *time.Time `json:"endsAt"`
+	UsageLimit    int        `json:"usageLimit"`   // 0 means unlimited
+	PerUserLimit  int        `json:"perUserLimit"` // 0 means unlimited
+	TimesUsed     int        `json:"timesUsed"`
+	Active        bool       `json:"active"`
+
+	// Restrictions, an empty list means the coupon applies to everything. Categories include
+	// their subcategories.
+	ProductIDs  datatypes.JSONSlice[int]  `json:"productIds"`
+	CategoryIDs datatypes.JSONSlice[uint] `json:"categoryIds"`
+	BrandIDs    datatypes.JSONSlice[uint] `json:"brandIds"`
+}
+
+type CouponRedemption struct {
+	gorm.Model
+	CouponID int    `json:"couponId" gorm:"index"`
+	OrderID  int    `json:"orderId" gorm:"index"`
+	UserID   int    `json:"userId" gorm:"index"`
+	Email    string `json:"email" gorm:"size:255;index"`
+	Discount Money  `json:"discount"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "gorm.io/gorm"
+
+type DeliveryZone struct {
+	gorm.Model
+	Name                  string `json:"name" binding:"required" gorm:"size:128;uniqueIndex"`
+	Description           string `json:"description"`
+	Fee                   Money  `json:"fee"`
+	FreeDeliveryThreshold Money  `json:"freeDeliveryThreshold"` // order value from which delivery is free, 0 means never
+	MinLeadDays           int    `json:"minLeadDays"`
+	MaxLeadDays           int    `json:"maxLeadDays"`
+	IsPickupPoint         bool   `json:"isPickupPoint"`
+	Active                bool   `json:"active"`
+	SortOrder             int    `json:"sortOrder"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type IdempotencyKey struct {
+	gorm.Model
+	Key          string    `gorm:"size:255;uniqueIndex:idx_idempotency_scope_key"`
+	Scope        string    `gorm:"size:64;uniqueIndex:idx_idempotency_scope_key"`
+	Fingerprint  string    `gorm:"size:64"`
+	Completed    bool      `gorm:"default:false"`
+	StatusCode   int       `gorm:"default:0"`
+	ContentType  string    `gorm:"size:255"`
+	ResponseBody string    `gorm:"type:longtext"`
+	ExpiresAt    time.Time `gorm:"index"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Invoice is issued once for each paid order. Numbers come from InvoiceCounter so they
+// are sequential with no gaps within a series.
+type Invoice struct {
+	gorm.Model
+	OrderID          int       `json:"orderId" gorm:"uniqueIndex"`
+	Series           string    `json:"series" gorm:"size:32;uniqueIndex:idx_invoices_series_sequence,priority:1"`
+	Sequence         int       `json:"sequence" gorm:"uniqueIndex:idx_invoices_series_sequence,priority:2"`
+	Number           string    `json:"number" gorm:"size:32;uniqueIndex"`
+	IssuedAt         time.Time `json:"issuedAt"`
+	PaymentReference string    `json:"paymentReference"`
+	PaymentMethod    string    `json:"paymentMethod"`
+	ConfirmationCode string    `json:"confirmationCode"`
+}
+
+// InvoiceCounter holds the last invoice number used in a series
+type InvoiceCounter struct {
+	Series     string `gorm:"primaryKey;size:32"`
+	LastNumber int
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency prices are set and charged in
+const DefaultCurrency = "KES"
+
+var errInvalidMoney = errors.New("invalid money amount")
+
+// Money is an amount in minor units (cents) of a currency.
+// It is stored as an integer and written to JSON as a decimal number with two places, e.g. 1499.50
+type Money int64
+
+// NewMoney converts a whole currency amount to Money
+func NewMoney(major int64) Money {
+	return Money(major * 100)
+}
+
+// ParseMoney reads a decimal amount such as "1499.5" without going through floating point
+func ParseMoney(value string) (Money, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, errInvalidMoney
+	}
+
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	if len(fraction) > 2 {
+		return 0, fmt.Errorf("%w: more than two decimal places in %q", errInvalidMoney, value)
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, errInvalidMoney
+	}
+	minor, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil || major < 0 || minor < 0 {
+		return 0, errInvalidMoney
+	}
+
+	amount := Money(major*100 + minor)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// Percent returns rate percent of the amount, rounded to the nearest minor unit
+func (m Money) Percent(rate float64) Money {
+	return Money(math.Round(float64(m) * rate / 100))
+}
+
+// Mul multiplies the amount by a quantity
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// Allocate splits the amount in proportion to weights so that the parts add up exactly.
+// Any remainder from rounding goes to the last non-zero weight.
+func (m Money) Allocate(weights []Money) []Money {
+	parts := make([]Money, len(weights))
+
+	var total Money
+	for _, weight := range weights {
+		total += weight
+	}
+	if total == 0 {
+		return parts
+	}
+
+	var allocated Money
+	last := -1
+	for i, weight := range weights {
+		if weight == 0 {
+			continue
+		}
+		parts[i] = Money(math.Round(float64(m) * float64(weight) / float64(total)))
+		allocated += parts[i]
+		last = i
+	}
+	parts[last] += m - allocated
+	return parts
+}
+
+// String formats the amount as a plain decimal, e.g. 1499.50
+func (m Money) String() string {
+	sign := ""
+	value := int64(m)
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, value/100, value%100)
+}
+
+// Format formats the amount for display with a currency and thousands separators, e.g. KES 1,499.50
+func (m Money) Format(currency string) string {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	amount := m.String()
+	sign := ""
+	if strings.HasPrefix(amount, "-") {
+		sign = "-"
+		amount = amount[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(amount, ".")
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	return fmt.Sprintf("%s %s%s.%s", currency, sign, grouped.String(), fraction)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts the amount as a JSON number or a string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.Trim(data, `"`)
+	if string(data) == "null" {
+		return nil
+	}
+
+	amount, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+	*m = amount
+	return nil
+}
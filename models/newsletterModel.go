@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Newsletter subscriber states. Subscribers stay pending until they confirm their email address.
+const (
+	SubscriberStatusPending      = "pending"
+	SubscriberStatusSubscribed   = "subscribed"
+	SubscriberStatusUnsubscribed = "unsubscribed"
+)
+
+// Campaign states
+const (
+	CampaignStatusDraft   = "draft"
+	CampaignStatusSending = "sending"
+	CampaignStatusSent    = "sent"
+)
+
+// Campaign audiences
+const (
+	SegmentAll       = "all"       // every confirmed subscriber
+	SegmentCustomers = "customers" // subscribers with a paid order
+	SegmentCategory  = "category"  // subscribers who bought a product in the SegmentValue category id or its subcategories
+	SegmentBrand     = "brand"     // subscribers who bought a product of the SegmentValue brand id
+)
+
+// Delivery states of a campaign email to one subscriber
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSending = "sending" // claimed by the newsletter worker
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed"
+	DeliveryStatusSkipped = "skipped" // the subscriber unsubscribed before it was sent
+)
+
+type NewsletterSubscriber struct {
+	gorm.Model
+	Email                  string     `json:"email" gorm:"size:255;uniqueIndex"`
+	Name                   string     `json:"name"`
+	UserID                 int        `json:"userId" gorm:"index"`
+	Status                 string     `json:"status" gorm:"size:16;index"`
+	Source                 string     `json:"source"`
+	ConfirmedAt            *time.Time `json:"confirmedAt"`
+	UnsubscribedAt         *time.Time `json:"unsubscribedAt"`
+	UnsubscribedCampaignID int        `json:"unsubscribedCampaignId" gorm:"index"`
+}
+
+// NewsletterCampaign is an email written by an admin. Body and TextBody are templates that
+// can use {{.Name}}, {{.Email}} and {{.UnsubscribeURL}}.
+type NewsletterCampaign struct {
+	gorm.Model
+	Subject      string     `json:"subject" binding:"required"`
+	Body         string     `json:"body" binding:"required" gorm:"type:longtext"`
+	TextBody     string     `json:"textBody" gorm:"type:longtext"`
+	Segment      string     `json:"segment" binding:"omitempty,oneof=all customers category brand"`
+	SegmentValue string     `json:"segmentValue"`
+	Status       string     `json:"status" gorm:"size:16;index"`
+	StartedAt    *time.Time `json:"startedAt"`
+	CompletedAt  *time.Time `json:"completedAt"`
+}
+
+type NewsletterDelivery struct {
+	gorm.Model
+	CampaignID   int        `json:"campaignId" gorm:"uniqueIndex:idx_campaign_subscriber;index:idx_campaign_status,priority:1"`
+	SubscriberID int        `json:"subscriberId" gorm:"uniqueIndex:idx_campaign_subscriber"`
+	Email        string     `json:"email"`
+	Status       string     `json:"status" gorm:"size:16;index:idx_campaign_status,priority:2"`
+	Error        string     `json:"error" gorm:"type:text"`
+	SentAt       *time.Time `json:"sentAt"`
+}
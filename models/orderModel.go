@@ -1,27 +1,72 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Order struct {
 	gorm.Model
-	UserID            int         `json:"userId"`
-	FirstName         string      `json:"firstName"`
-	LastName          string      `json:"lastName"`
-	Email             string      `json:"email"`
-	Phone             string      `json:"phone"`
-	DeliveryLocation  string      `json:"deliveryLocation"`
-	Total             float64     `json:"total"`
-	Status            string      `json:"status"`
-	PesapalTrackingId string      `json:"pesapalTrackingId"`
-	PaymentStatus     string      `json:"paymentStatus"`
-	OrderItems        []OrderItem `json:"orderItems" gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
+	UserID            *int           `json:"userId"` // nil for guest orders
+	FirstName         string         `json:"firstName"`
+	LastName          string         `json:"lastName"`
+	Email             string         `json:"email" gorm:"size:255"`
+	Phone             string         `json:"phone" gorm:"size:32"`
+	DeliveryLocation  string         `json:"deliveryLocation"`
+	DeliveryZoneID    int            `json:"deliveryZoneId"`
+	DeliveryZoneName  string         `json:"deliveryZoneName"`
+	DeliveryFee       Money          `json:"deliveryFee"`
+	DeliveryFrom      *time.Time     `json:"deliveryFrom"`
+	DeliveryBy        *time.Time     `json:"deliveryBy"`
+	DeliveredAt       *time.Time     `json:"deliveredAt"` // set when an admin marks the order delivered
+	Subtotal          Money          `json:"subtotal"`
+	TaxTotal          Money          `json:"taxTotal"`
+	Currency          string         `json:"currency" gorm:"size:3;default:KES"`
+	PricesIncludeTax  bool           `json:"pricesIncludeTax"`
+	Total             Money          `json:"total"` // grand total charged to the customer
+	CouponCode        string         `json:"couponCode"`
+	Discount          Money          `json:"discount"`
+	FreeDelivery      bool           `json:"freeDelivery"`
+	Status            string         `json:"status" gorm:"size:32"`
+	PesapalTrackingId string         `json:"pesapalTrackingId"`
+	PaymentStatus     string         `json:"paymentStatus" gorm:"size:32"`
+	AddressID         int            `json:"addressId" gorm:"-"`
+	DeliveryAddress   *OrderAddress  `json:"deliveryAddress,omitempty" gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
+	TaxLines          []OrderTaxLine `json:"taxLines" gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
+	Invoice           *Invoice       `json:"invoice,omitempty" gorm:"foreignKey:OrderID"`
+	OrderItems        []OrderItem    `json:"orderItems" gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
+}
+
+// IsGuest reports whether the order was placed without an account
+func (order Order) IsGuest() bool {
+	return order.UserID == nil
+}
+
+// CustomerID is the id of the user who placed the order, 0 for guests
+func (order Order) CustomerID() int {
+	if order.UserID == nil {
+		return 0
+	}
+	return *order.UserID
+}
+
+// PlacedBy reports whether the order belongs to the user
+func (order Order) PlacedBy(userID int) bool {
+	return order.UserID != nil && *order.UserID == userID
 }
 
 type OrderItem struct {
 	gorm.Model
-	OrderID   int     `json:"orderId"`
-	ProductId int     `json:"productId"`
-	Name      string  `json:"name"`
-	Price     float64 `json:"price"`
-	Quantity  int     `json:"quantity"`
+	OrderID     int     `json:"orderId"`
+	ProductId   int     `json:"productId"`
+	VariantID   int     `json:"variantId"` // the variant that was sold, 0 for products without variants
+	SKU         string  `json:"sku"`
+	Name        string  `json:"name"`
+	VariantName string  `json:"variantName"`
+	Price       Money   `json:"price"`
+	Quantity    int     `json:"quantity"`
+	Discount    Money   `json:"discount"`
+	TaxRate     float64 `json:"taxRate"`
+	TaxAmount   Money   `json:"taxAmount"`
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Delivery states of an outbox email
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSending = "sending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusFailed  = "failed"
+)
+
+// OutboxEmail is a message waiting to be, or already, delivered by the email worker.
+// It is written in the same transaction as the change that caused it.
+type OutboxEmail struct {
+	gorm.Model
+	Recipient     string                  `json:"recipient"`
+	Subject       string                  `json:"subject"`
+	HTMLBody      string                  `json:"htmlBody,omitempty" gorm:"type:longtext"`
+	TextBody      string                  `json:"textBody,omitempty" gorm:"type:longtext"`
+	Status        string                  `json:"status" gorm:"size:16;index:idx_outbox_due,priority:1"`
+	NextAttemptAt time.Time               `json:"nextAttemptAt" gorm:"index:idx_outbox_due,priority:2"`
+	Attempts      int                     `json:"attempts"`
+	MaxAttempts   int                     `json:"maxAttempts"`
+	LastError     string                  `json:"lastError" gorm:"type:text"`
+	SentAt        *time.Time              `json:"sentAt"`
+	Attachments   []OutboxEmailAttachment `json:"attachments,omitempty" gorm:"foreignKey:OutboxEmailID;constraint:OnDelete:CASCADE"`
+	AttemptLog    []OutboxEmailAttempt    `json:"attemptLog,omitempty" gorm:"foreignKey:OutboxEmailID;constraint:OnDelete:CASCADE"`
+}
+
+type OutboxEmailAttachment struct {
+	gorm.Model
+	OutboxEmailID int    `json:"outboxEmailId" gorm:"index"`
+	Filename      string `json:"filename"`
+	ContentType   string `json:"contentType"`
+	Content       []byte `json:"-" gorm:"type:longblob"`
+}
+
+// OutboxEmailAttempt records the outcome of one delivery attempt
+type OutboxEmailAttempt struct {
+	gorm.Model
+	OutboxEmailID int    `json:"outboxEmailId" gorm:"index"`
+	Attempt       int    `json:"attempt"`
+	Succeeded     bool   `json:"succeeded"`
+	Error         string `json:"error" gorm:"type:text"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxTextMessage is an SMS waiting to be, or already, delivered by the SMS worker. Like
+// OutboxEmail it is written in the same transaction as the change that caused it and uses
+// the same statuses.
+type OutboxTextMessage struct {
+	gorm.Model
+	Recipient         string     `json:"recipient" gorm:"size:16"`
+	Body              string     `json:"body" gorm:"type:text"`
+	Status            string     `json:"status" gorm:"size:16;index:idx_sms_outbox_due,priority:1"`
+	NextAttemptAt     time.Time  `json:"nextAttemptAt" gorm:"index:idx_sms_outbox_due,priority:2"`
+	Attempts          int        `json:"attempts"`
+	MaxAttempts       int        `json:"maxAttempts"`
+	LastError         string     `json:"lastError" gorm:"type:text"`
+	ProviderMessageID string     `json:"providerMessageId"`
+	SentAt            *time.Time `json:"sentAt"`
+}
@@ -7,7 +7,7 @@ import (
 
 type ProductSpecs struct {
 	gorm.Model
-	Name     string `json:"name" binding:"required"`
+	Name      string `json:"name" binding:"required"`
 	Value     string `json:"value" binding:"required"`
 	ProductID int    `json:"productId" binding:"required"`
 }
@@ -16,16 +16,24 @@ type ProductImage struct {
 	gorm.Model
 	Url       string `json:"url" binding:"required"`
 	ProductID int    `json:"productId" binding:"required"`
+	VariantID *int   `json:"variantId" gorm:"index"` // set for images of one variant
 }
 
 type Product struct {
 	gorm.Model
-	Brand          string         `json:"brand" binding:"required"`
-	Name           string         `json:"name" binding:"required"`
-	Description    string         `json:"description" binding:"required"`
-	Price          int            `json:"price" binding:"required"`
-	Category       string         `json:"category" binding:"required"`
-	Colors         datatypes.JSON `json:"colors"`
-	Specifications []ProductSpecs `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
-	Images         []ProductImage `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
+	Brand          string           `json:"brand" binding:"required_without=BrandID"` // name of the brand, kept in sync with BrandID
+	BrandID        *int             `json:"brandId" gorm:"index"`
+	Name           string           `json:"name" binding:"required"`
+	Description    string           `json:"description" binding:"required"`
+	Price          Money            `json:"price" binding:"required"`
+	Currency       string           `json:"currency" gorm:"size:3;default:KES"`
+	Category       string           `json:"category" binding:"required_without=CategoryID"` // name of the category, kept in sync with CategoryID
+	CategoryID     *int             `json:"categoryId" gorm:"index"`
+	Colors         datatypes.JSON   `json:"colors"` // free form, products sold in several colors should use Variants
+	Stock          *int             `json:"stock"`  // nil when inventory is not tracked
+	TaxClassID     *int             `json:"taxClassId"`
+	Specifications []ProductSpecs   `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
+	Images         []ProductImage   `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
+	Variants       []ProductVariant `json:"variants" gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
+	Breadcrumb     []Category       `json:"breadcrumb,omitempty" gorm:"-"` // the category and its ancestors, from the root
 }
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ProductVariant is a purchasable version of a product, such as a phone in one color and
+// storage size. Products with active variants are sold by variant.
+type ProductVariant struct {
+	gorm.Model
+	ProductID int            `json:"productId" gorm:"index"`
+	SKU       string         `json:"sku" gorm:"size:64;index" binding:"required"`
+	Color     string         `json:"color" gorm:"size:64"`
+	Size      string         `json:"size" gorm:"size:64"`
+	Capacity  string         `json:"capacity" gorm:"size:64"` // storage and memory, e.g. "256GB" or "8GB / 256GB"
+	Price     *Money         `json:"price"`                   // overrides the product price when set
+	Stock     *int           `json:"stock"`                   // nil when inventory is not tracked
+	Active    bool           `json:"active"`                  // true unless the JSON it is created from says otherwise
+	Images    []ProductImage `json:"images" gorm:"foreignKey:VariantID"`
+}
+
+// UnmarshalJSON makes variants active when active is left out, on their own or nested in a product
+func (v *ProductVariant) UnmarshalJSON(data []byte) error {
+	type plainVariant ProductVariant
+	variant := plainVariant{Active: true}
+	if err := json.Unmarshal(data, &variant); err != nil {
+		return err
+	}
+	*v = ProductVariant(variant)
+	return nil
+}
+
+// Label describes the variant's attributes, e.g. "Black / 256GB"
+func (v ProductVariant) Label() string {
+	var parts []string
+	for _, attribute := range []string{v.Color, v.Size, v.Capacity} {
+		if attribute != "" {
+			parts = append(parts, attribute)
+		}
+	}
+	return strings.Join(parts, " / ")
+}
+
+// PriceOf is the price of the variant of product, the product price unless it is overridden
+func (v ProductVariant) PriceOf(product Product) Money {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return product.Price
+}
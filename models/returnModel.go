@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Return request states. A request is reviewed by an admin, then the items are received
+// back and the customer is refunded.
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusCancelled = "cancelled" // withdrawn by the customer before review
+	ReturnStatusReceived  = "received"
+	ReturnStatusRefunding = "refunding" // a refund is being requested from the payment provider
+	ReturnStatusRefunded  = "refunded"
+)
+
+// Reasons a customer can give for a return
+const (
+	ReturnReasonFaulty         = "faulty"
+	ReturnReasonDamaged        = "damaged"
+	ReturnReasonWrongItem      = "wrong_item"
+	ReturnReasonNotAsDescribed = "not_as_described"
+	ReturnReasonOther          = "other"
+)
+
+var ReturnReasons = []string{
+	ReturnReasonFaulty,
+	ReturnReasonDamaged,
+	ReturnReasonWrongItem,
+	ReturnReasonNotAsDescribed,
+	ReturnReasonOther,
+}
+
+// Condition of a returned item when it is received
+const (
+	ReturnConditionResellable = "resellable"
+	ReturnConditionDamaged    = "damaged"
+	ReturnConditionFaulty     = "faulty"
+)
+
+// Ways a return is refunded
+const (
+	RefundMethodPesapal = "pesapal" // refund request to Pesapal against the order payment
+	RefundMethodManual  = "manual"  // paid back outside the shop, e.g. an M-Pesa transfer
+)
+
+type ReturnRequest struct {
+	gorm.Model
+	OrderID         int           `json:"orderId" gorm:"index"`
+	UserID          int           `json:"userId" gorm:"index"`
+	Status          string        `json:"status" gorm:"size:32;index"`
+	Reason          string        `json:"reason" gorm:"size:32"`
+	Details         string        `json:"details" gorm:"type:text"`
+	AdminNote       string        `json:"adminNote" gorm:"type:text"`
+	ReviewedAt      *time.Time    `json:"reviewedAt"`
+	ReceivedAt      *time.Time    `json:"receivedAt"`
+	Currency        string        `json:"currency" gorm:"size:3;default:KES"`
+	RefundAmount    Money         `json:"refundAmount"`
+	RefundMethod    string        `json:"refundMethod" gorm:"size:32"`
+	RefundReference string        `json:"refundReference"`
+	RefundedAt      *time.Time    `json:"refundedAt"`
+	Items           []ReturnItem  `json:"items" gorm:"foreignKey:ReturnRequestID;constraint:OnDelete:CASCADE"`
+	Photos          []ReturnPhoto `json:"photos" gorm:"foreignKey:ReturnRequestID;constraint:OnDelete:CASCADE"`
+}
+
+// ReturnItem is a quantity of an order item being returned
+type ReturnItem struct {
+	gorm.Model
+	ReturnRequestID uint   `json:"returnRequestId" gorm:"index"`
+	OrderItemID     int    `json:"orderItemId" gorm:"index"`
+	ProductID       int    `json:"productId"`
+	VariantID       int    `json:"variantId"`
+	Name            string `json:"name"`
+	Quantity        int    `json:"quantity"`
+	Condition       string `json:"condition" gorm:"size:32"` // set when the item is received
+	Restocked       bool   `json:"restocked"`
+	RefundAmount    Money  `json:"refundAmount"`
+}
+
+type ReturnPhoto struct {
+	gorm.Model
+	ReturnRequestID uint   `json:"returnRequestId" gorm:"index"`
+	Url             string `json:"url"`
+}
@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// SchemaMigration records one-off data migrations that have been applied
+type SchemaMigration struct {
+	ID        string `gorm:"primaryKey;size:128"`
+	AppliedAt time.Time
+}
@@ -0,0 +1,32 @@
+package models
+
+import "gorm.io/gorm"
+
+type TaxClass struct {
+	gorm.Model
+	Name        string  `json:"name" binding:"required" gorm:"size:128;uniqueIndex"`
+	Description string  `json:"description"`
+	Rate        float64 `json:"rate"` // percentage, e.g. 16 for standard rated VAT
+	Exempt      bool    `json:"exempt"`
+	IsDefault   bool    `json:"isDefault"`
+}
+
+// CategoryTaxClass sets the tax class for products in a category and its subcategories that
+// don't have their own. The class of the nearest category applies.
+type CategoryTaxClass struct {
+	gorm.Model
+	CategoryID uint   `json:"categoryId" binding:"required" gorm:"index"`
+	Category   string `json:"category" gorm:"size:128"` // name of the category, kept in sync with CategoryID
+	TaxClassID int    `json:"taxClassId" binding:"required"`
+}
+
+type OrderTaxLine struct {
+	gorm.Model
+	OrderID       int     `json:"orderId" gorm:"index"`
+	TaxClassID    int     `json:"taxClassId"`
+	Name          string  `json:"name"`
+	Rate          float64 `json:"rate"`
+	Exempt        bool    `json:"exempt"`
+	TaxableAmount Money   `json:"taxableAmount"`
+	TaxAmount     Money   `json:"taxAmount"`
+}
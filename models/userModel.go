@@ -13,6 +13,7 @@ type User struct {
 	Role                   string  `json:"role"`
 	AcceptTerms            bool    `json:"acceptTerms"`
 	SubscribeToNews        bool    `json:"subscribeToNews"`
+	SMSOptOut              bool    `json:"smsOptOut"`
 	AccountActivationToken string  `json:"accountActivationToken"`
 	AccountActivated       bool    `json:"accountActivated"`
 	PasswordResetToken     string  `json:"passwordResetToken"`
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Events that can be sent to webhook endpoints
+const (
+	WebhookEventOrderCreated       = "order.created"
+	WebhookEventOrderPaid          = "order.paid"
+	WebhookEventOrderStatusChanged = "order.status_changed"
+	WebhookEventOrderRefunded      = "order.refunded"
+	WebhookEventProductChanged     = "product.changed"
+)
+
+var WebhookEvents = []string{
+	WebhookEventOrderCreated,
+	WebhookEventOrderPaid,
+	WebhookEventOrderStatusChanged,
+	WebhookEventOrderRefunded,
+	WebhookEventProductChanged,
+}
+
+// WebhookEndpoint is an external URL that is sent the events it subscribes to. Events is a
+// comma separated list of event names, "*" subscribes to all of them.
+type WebhookEndpoint struct {
+	gorm.Model
+	URL         string            `json:"url" gorm:"size:2048"`
+	Description string            `json:"description"`
+	Events      string            `json:"events"`
+	Secret      string            `json:"-"`
+	Active      bool              `json:"active"`
+	Deliveries  []WebhookDelivery `json:"-" gorm:"foreignKey:WebhookEndpointID;constraint:OnDelete:CASCADE"`
+}
+
+// WebhookDelivery is one event sent, or waiting to be sent, to one endpoint. It uses the
+// outbox statuses and is written in the same transaction as the change that caused it.
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookEndpointID int                      `json:"webhookEndpointId" gorm:"index"`
+	EventID           string                   `json:"eventId" gorm:"size:64;index"`
+	Event             string                   `json:"event" gorm:"size:64"`
+	Payload           string                   `json:"payload,omitempty" gorm:"type:longtext"`
+	Status            string                   `json:"status" gorm:"size:16;index:idx_webhook_due,priority:1"`
+	NextAttemptAt     time.Time                `json:"nextAttemptAt" gorm:"index:idx_webhook_due,priority:2"`
+	Attempts          int                      `json:"attempts"`
+	MaxAttempts       int                      `json:"maxAttempts"`
+	LastStatusCode    int                      `json:"lastStatusCode"`
+	LastError         string                   `json:"lastError" gorm:"type:text"`
+	DeliveredAt       *time.Time               `json:"deliveredAt"`
+	AttemptLog        []WebhookDeliveryAttempt `json:"attemptLog,omitempty" gorm:"foreignKey:WebhookDeliveryID;constraint:OnDelete:CASCADE"`
+}
+
+// WebhookDeliveryAttempt records the outcome of one request to an endpoint
+type WebhookDeliveryAttempt struct {
+	gorm.Model
+	WebhookDeliveryID int    `json:"webhookDeliveryId" gorm:"index"`
+	Attempt           int    `json:"attempt"`
+	StatusCode        int    `json:"statusCode"`
+	Succeeded         bool   `json:"succeeded"`
+	Error             string `json:"error" gorm:"type:text"`
+	ResponseBody      string `json:"responseBody" gorm:"type:text"`
+	DurationMs        int64  `json:"durationMs"`
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func AddressRoutes(server *gin.Engine) {
+	server.GET("/address", middlewares.RequireAuth(), controllers.GetAddresses)
+	server.POST("/address", middlewares.RequireAuth(), controllers.CreateAddress)
+	server.PUT("/address/:addressId", middlewares.RequireAuth(), controllers.UpdateAddress)
+	server.PATCH("/address/:addressId/default", middlewares.RequireAuth(), controllers.SetDefaultAddress)
+	server.DELETE("/address/:addressId", middlewares.RequireAuth(), controllers.DeleteAddress)
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func AnalyticsRoutes(server *gin.Engine) {
+	analytics := server.Group("/analytics", middlewares.RequireAuth(), middlewares.RequireAdmin())
+	{
+		analytics.GET("/summary", controllers.GetAnalyticsSummary)
+		analytics.GET("/sales", controllers.GetSalesAnalytics)
+		analytics.GET("/top-products", controllers.GetTopProducts)
+		analytics.GET("/top-categories", controllers.GetTopCategories)
+		analytics.GET("/payments", controllers.GetPaymentAnalytics)
+		analytics.GET("/customers", controllers.GetCustomerAnalytics)
+	}
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
 	"github.com/gin-gonic/gin"
 )
 
@@ -13,5 +14,7 @@ func AuthRoutes(server *gin.Engine) {
 		auth.POST("/verify-email/:activationToken", controllers.ActivateAccount)
 		auth.POST("/forgot-password", controllers.SendPasswordResetLink)
 		auth.POST("/reset-password/:resetToken", controllers.ResetPassword)
+		auth.GET("/notification-preferences", middlewares.RequireAuth(), controllers.GetNotificationPreferences)
+		auth.PUT("/notification-preferences", middlewares.RequireAuth(), controllers.UpdateNotificationPreferences)
 	}
 }
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func BrandRoutes(server *gin.Engine) {
+	server.GET("/brands", controllers.GetBrands)
+	server.GET("/brands/:brandId", controllers.GetBrand)
+
+	admin := server.Group("/brands", middlewares.RequireAuth(), middlewares.RequireAdmin())
+	{
+		admin.POST("", controllers.CreateBrand)
+		admin.PUT("/:brandId", controllers.UpdateBrand)
+		admin.DELETE("/:brandId", controllers.DeleteBrand)
+		admin.POST("/:brandId/merge", controllers.MergeBrand)
+		admin.POST("/:brandId/logo", controllers.UploadBrandLogo)
+	}
+}
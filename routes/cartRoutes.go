@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func CartRoutes(server *gin.Engine) {
+	server.GET("/cart", middlewares.OptionalAuth(), controllers.GetCart)
+	server.POST("/cart/items", middlewares.OptionalAuth(), controllers.AddCartItem)
+	server.PATCH("/cart/items/:itemId", middlewares.OptionalAuth(), controllers.UpdateCartItem)
+	server.DELETE("/cart/items/:itemId", middlewares.OptionalAuth(), controllers.RemoveCartItem)
+	server.POST("/cart/checkout", middlewares.OptionalAuth(), middlewares.Idempotency(), controllers.CheckoutCart)
+	server.GET("/carts/abandoned", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetAbandonedCarts)
+}
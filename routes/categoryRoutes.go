@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func CategoryRoutes(server *gin.Engine) {
+	server.GET("/categories", controllers.GetCategories)
+	server.GET("/categories/:categoryId", controllers.GetCategory)
+
+	admin := server.Group("/categories", middlewares.RequireAuth(), middlewares.RequireAdmin())
+	{
+		admin.POST("", controllers.CreateCategory)
+		admin.PUT("/:categoryId", controllers.UpdateCategory)
+		admin.DELETE("/:categoryId", controllers.DeleteCategory)
+		admin.POST("/:categoryId/merge", controllers.MergeCategory)
+		admin.POST("/:categoryId/image", controllers.UploadCategoryImage)
+	}
+}
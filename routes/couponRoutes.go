@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func CouponRoutes(server *gin.Engine) {
+	server.POST("/coupon/validate", middlewares.OptionalAuth(), controllers.ValidateCoupon)
+	server.POST("/coupon", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.CreateCoupon)
+	server.GET("/coupon", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetCoupons)
+	server.GET("/coupon/:couponId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetCoupon)
+	server.PUT("/coupon/:couponId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.UpdateCoupon)
+	server.DELETE("/coupon/:couponId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.DeleteCoupon)
+}
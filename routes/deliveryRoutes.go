@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func DeliveryRoutes(server *gin.Engine) {
+	server.GET("/delivery-zone", middlewares.OptionalAuth(), controllers.GetDeliveryZones)
+	server.GET("/cart/delivery-quote", middlewares.OptionalAuth(), controllers.GetDeliveryQuote)
+	server.POST("/delivery-zone", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.CreateDeliveryZone)
+	server.PUT("/delivery-zone/:zoneId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.UpdateDeliveryZone)
+	server.DELETE("/delivery-zone/:zoneId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.DeleteDeliveryZone)
+}
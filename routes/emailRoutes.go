@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func EmailRoutes(server *gin.Engine) {
+	server.GET("/email/preview/:event", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.PreviewOrderEmail)
+	server.GET("/email/outbox", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetOutboxEmails)
+	server.GET("/email/outbox/:emailId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetOutboxEmail)
+	server.POST("/email/outbox/:emailId/resend", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.ResendOutboxEmail)
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func NewsletterRoutes(server *gin.Engine) {
+	server.POST("/newsletter/subscribe", middlewares.OptionalAuth(), controllers.SubscribeToNewsletter)
+	server.GET("/newsletter/confirm", controllers.ConfirmNewsletterSubscription)
+	server.GET("/newsletter/unsubscribe", controllers.GetNewsletterUnsubscribe)
+	server.POST("/newsletter/unsubscribe", controllers.UnsubscribeFromNewsletter)
+
+	server.GET("/newsletter/subscribers", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetNewsletterSubscribers)
+	server.GET("/newsletter/campaigns", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetNewsletterCampaigns)
+	server.POST("/newsletter/campaigns", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.CreateNewsletterCampaign)
+	server.GET("/newsletter/campaigns/:campaignId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetNewsletterCampaign)
+	server.PUT("/newsletter/campaigns/:campaignId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.UpdateNewsletterCampaign)
+	server.DELETE("/newsletter/campaigns/:campaignId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.DeleteNewsletterCampaign)
+	server.GET("/newsletter/campaigns/:campaignId/preview", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.PreviewNewsletterCampaign)
+	server.POST("/newsletter/campaigns/:campaignId/send", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.SendNewsletterCampaign)
+}
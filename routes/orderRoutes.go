@@ -9,11 +9,21 @@ import (
 func OrderRoutes(server *gin.Engine) {
 	server.POST("/pesapal/ipn", controllers.HandlePesapalIPN)
 	server.GET("/paymentstatus", middlewares.RequireAuth(), controllers.CheckPaymentStatus)
-	server.POST("/order", middlewares.RequireAuth(), controllers.CreateOrder)
+	server.POST("/order", middlewares.RequireAuth(), middlewares.Idempotency(), controllers.CreateOrder)
+	server.POST("/guest/order", middlewares.Idempotency(), controllers.CreateGuestOrder)
+	server.GET("/guest/order", controllers.GetGuestOrder)
+	server.POST("/guest/order/pay", controllers.PayGuestOrder)
+	server.GET("/guest/order/invoice", controllers.GetGuestOrderInvoice)
+	server.GET("/guest/order/events", controllers.StreamGuestOrderEvents)
+	server.POST("/order/claim", middlewares.RequireAuth(), controllers.ClaimGuestOrders)
 	server.GET("/order", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetOrders)
 	server.GET("/user/:userId/orders", middlewares.RequireAuth(), controllers.GetOderByCustomerId)
 	server.GET("/order/:orderId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetOderById)
+	server.GET("/order/:orderId/invoice", middlewares.RequireAuth(), controllers.GetOrderInvoice)
+	server.GET("/order/:orderId/events", middlewares.RequireAuth(), controllers.StreamOrderEvents)
 	server.PATCH("/order/:orderId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.UpdateOrderStatus)
 	server.DELETE("/order/:orderId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.DeleteOrder)
 	server.GET("/orders/undelivered", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetUndeliveredOrders)
+	server.GET("/orders/events", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.StreamAllOrderEvents)
+	server.GET("/orders/export", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.ExportOrders)
 }
@@ -14,4 +14,7 @@ func ProductRoutes(server *gin.Engine) {
 	server.GET("/product/:id", controllers.GetProduct)
 	server.PUT("/product/:productId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.UpdateProduct)
 	server.DELETE("/product/:productId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.DeleteProduct)
+	server.POST("/product/:productId/variants", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.CreateProductVariant)
+	server.PUT("/product/:productId/variants/:variantId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.UpdateProductVariant)
+	server.DELETE("/product/:productId/variants/:variantId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.DeleteProductVariant)
 }
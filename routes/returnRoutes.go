@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func ReturnRoutes(server *gin.Engine) {
+	returns := server.Group("/returns", middlewares.RequireAuth())
+	{
+		returns.POST("", controllers.CreateReturnRequest)
+		returns.GET("", controllers.GetReturnRequests)
+		returns.GET("/:returnId", controllers.GetReturnRequest)
+		returns.POST("/:returnId/photos", controllers.UploadReturnPhotos)
+		returns.POST("/:returnId/cancel", controllers.CancelReturnRequest)
+		returns.POST("/:returnId/approve", middlewares.RequireAdmin(), controllers.ApproveReturnRequest)
+		returns.POST("/:returnId/reject", middlewares.RequireAdmin(), controllers.RejectReturnRequest)
+		returns.POST("/:returnId/receive", middlewares.RequireAdmin(), controllers.ReceiveReturnRequest)
+		returns.POST("/:returnId/refund", middlewares.RequireAdmin(), controllers.RefundReturnRequest)
+	}
+}
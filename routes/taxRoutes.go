@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func TaxRoutes(server *gin.Engine) {
+	server.GET("/tax-class", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.GetTaxClasses)
+	server.POST("/tax-class", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.CreateTaxClass)
+	server.PUT("/tax-class/:taxClassId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.UpdateTaxClass)
+	server.DELETE("/tax-class/:taxClassId", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.DeleteTaxClass)
+	server.PUT("/category-tax-class", middlewares.RequireAuth(), middlewares.RequireAdmin(), controllers.SetCategoryTaxClass)
+}
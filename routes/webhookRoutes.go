@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/Kariqs/amexan-api/controllers"
+	"github.com/Kariqs/amexan-api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+func WebhookRoutes(server *gin.Engine) {
+	webhooks := server.Group("/webhooks", middlewares.RequireAuth(), middlewares.RequireAdmin())
+	{
+		webhooks.GET("", controllers.GetWebhookEndpoints)
+		webhooks.POST("", controllers.CreateWebhookEndpoint)
+		webhooks.GET("/:webhookId", controllers.GetWebhookEndpoint)
+		webhooks.PUT("/:webhookId", controllers.UpdateWebhookEndpoint)
+		webhooks.DELETE("/:webhookId", controllers.DeleteWebhookEndpoint)
+		webhooks.POST("/:webhookId/rotate-secret", controllers.RotateWebhookSecret)
+		webhooks.GET("/:webhookId/deliveries", controllers.GetWebhookDeliveries)
+		webhooks.GET("/:webhookId/deliveries/:deliveryId", controllers.GetWebhookDelivery)
+		webhooks.POST("/:webhookId/deliveries/:deliveryId/redeliver", controllers.RedeliverWebhook)
+	}
+}
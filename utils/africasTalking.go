@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	africasTalkingURL        = "https://api.africastalking.com/version1/messaging"
+	africasTalkingSandboxURL = "https://api.sandbox.africastalking.com/version1/messaging"
+)
+
+// AfricasTalkingSender sends text messages through the Africa's Talking bulk SMS API
+type AfricasTalkingSender struct {
+	URL      string // messaging endpoint, e.g. a local stub in development
+	Username string
+	APIKey   string
+	SenderID string // registered short code or alphanumeric sender, optional
+	Client   *http.Client
+}
+
+// NewAfricasTalkingSenderFromEnv configures a sender from AT_USERNAME, AT_API_KEY and the optional
+// AT_SENDER_ID and AT_API_URL. The "sandbox" username uses the sandbox endpoint.
+func NewAfricasTalkingSenderFromEnv() *AfricasTalkingSender {
+	sender := &AfricasTalkingSender{
+		URL:      os.Getenv("AT_API_URL"),
+		Username: os.Getenv("AT_USERNAME"),
+		APIKey:   os.Getenv("AT_API_KEY"),
+		SenderID: os.Getenv("AT_SENDER_ID"),
+		Client:   &http.Client{Timeout: 30 * time.Second},
+	}
+	if sender.URL == "" {
+		sender.URL = africasTalkingURL
+		if sender.Username == "sandbox" {
+			sender.URL = africasTalkingSandboxURL
+		}
+	}
+	return sender
+}
+
+type africasTalkingResponse struct {
+	SMSMessageData struct {
+		Message    string `json:"Message"`
+		Recipients []struct {
+			StatusCode int    `json:"statusCode"`
+			Number     string `json:"number"`
+			Status     string `json:"status"`
+			MessageID  string `json:"messageId"`
+		} `json:"Recipients"`
+	} `json:"SMSMessageData"`
+}
+
+func (s *AfricasTalkingSender) Send(message SMSMessage) (string, error) {
+	form := url.Values{
+		"username": {s.Username},
+		"to":       {message.To},
+		"message":  {message.Body},
+	}
+	if s.SenderID != "" {
+		form.Set("from", s.SenderID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("apiKey", s.APIKey)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("africa's talking returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result africasTalkingResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("invalid africa's talking response: %w", err)
+	}
+	if len(result.SMSMessageData.Recipients) == 0 {
+		return "", fmt.Errorf("africa's talking did not accept the message: %s", result.SMSMessageData.Message)
+	}
+
+	// 100 Processed, 101 Sent and 102 Queued are accepted, other codes are failures
+	recipient := result.SMSMessageData.Recipients[0]
+	if recipient.StatusCode < 100 || recipient.StatusCode > 102 {
+		return "", fmt.Errorf("africa's talking rejected the message to %s: %s (%d)", recipient.Number, recipient.Status, recipient.StatusCode)
+	}
+	return recipient.MessageID, nil
+}
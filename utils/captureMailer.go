@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CapturedEmail is a message kept by a CaptureMailer
+type CapturedEmail struct {
+	Message EmailMessage
+	Raw     []byte // the MIME message as it would have been sent
+	SentAt  time.Time
+}
+
+// CaptureMailer keeps messages instead of sending them, for development and tests.
+// When Dir is set each message is also written there as an .eml file.
+type CaptureMailer struct {
+	Dir  string
+	From string
+
+	lock     sync.Mutex
+	messages []CapturedEmail
+}
+
+func NewCaptureMailer(dir string) *CaptureMailer {
+	from := os.Getenv("FROM_EMAIL")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	return &CaptureMailer{Dir: dir, From: from}
+}
+
+func (m *CaptureMailer) Send(message EmailMessage) error {
+	raw, err := BuildMessage(m.From, message)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	captured := CapturedEmail{Message: message, Raw: raw, SentAt: time.Now()}
+	m.messages = append(m.messages, captured)
+
+	if m.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	filename := fmt.Sprintf("%s-%04d.eml", captured.SentAt.Format("20060102-150405.000"), len(m.messages))
+	return os.WriteFile(filepath.Join(m.Dir, filename), raw, 0o644)
+}
+
+// Messages returns the messages captured so far
+func (m *CaptureMailer) Messages() []CapturedEmail {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]CapturedEmail(nil), m.messages...)
+}
+
+// Reset forgets the captured messages
+func (m *CaptureMailer) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages = nil
+}
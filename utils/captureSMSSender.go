@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CapturedSMS is a text message kept by a CaptureSMSSender
+type CapturedSMS struct {
+	Message   SMSMessage
+	MessageID string
+	SentAt    time.Time
+}
+
+// CaptureSMSSender keeps text messages instead of sending them, for development and tests
+type CaptureSMSSender struct {
+	lock     sync.Mutex
+	messages []CapturedSMS
+}
+
+func NewCaptureSMSSender() *CaptureSMSSender {
+	return &CaptureSMSSender{}
+}
+
+func (s *CaptureSMSSender) Send(message SMSMessage) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	captured := CapturedSMS{
+		Message:   message,
+		MessageID: fmt.Sprintf("capture-%d", len(s.messages)+1),
+		SentAt:    time.Now(),
+	}
+	s.messages = append(s.messages, captured)
+	return captured.MessageID, nil
+}
+
+// Messages returns the text messages captured so far
+func (s *CaptureSMSSender) Messages() []CapturedSMS {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]CapturedSMS(nil), s.messages...)
+}
+
+// Reset forgets the captured messages
+func (s *CaptureSMSSender) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = nil
+}
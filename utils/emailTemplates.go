@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sync"
+	texttemplate "text/template"
+)
+
+// Email templates are parsed the first time they are used and kept for later sends
+var (
+	htmlTemplates     = map[string]*template.Template{}
+	textTemplates     = map[string]*texttemplate.Template{}
+	emailTemplateLock sync.RWMutex
+)
+
+func htmlTemplate(path string) (*template.Template, error) {
+	emailTemplateLock.RLock()
+	tmpl, exists := htmlTemplates[path]
+	emailTemplateLock.RUnlock()
+	if exists {
+		return tmpl, nil
+	}
+
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	emailTemplateLock.Lock()
+	htmlTemplates[path] = tmpl
+	emailTemplateLock.Unlock()
+	return tmpl, nil
+}
+
+func textTemplate(path string) (*texttemplate.Template, error) {
+	emailTemplateLock.RLock()
+	tmpl, exists := textTemplates[path]
+	emailTemplateLock.RUnlock()
+	if exists {
+		return tmpl, nil
+	}
+
+	tmpl, err := texttemplate.ParseFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	emailTemplateLock.Lock()
+	textTemplates[path] = tmpl
+	emailTemplateLock.Unlock()
+	return tmpl, nil
+}
+
+// RenderEmail executes the HTML template and, when textTemplatePath is set, the plain text template
+func RenderEmail(data any, htmlTemplatePath, textTemplatePath string) (string, string, error) {
+	tmpl, err := htmlTemplate(htmlTemplatePath)
+	if err != nil {
+		return "", "", fmt.Errorf("template parse error: %w", err)
+	}
+
+	var htmlBody bytes.Buffer
+	if err := tmpl.Execute(&htmlBody, data); err != nil {
+		return "", "", fmt.Errorf("template execution error: %w", err)
+	}
+
+	if textTemplatePath == "" {
+		return htmlBody.String(), "", nil
+	}
+
+	textTmpl, err := textTemplate(textTemplatePath)
+	if err != nil {
+		return "", "", fmt.Errorf("template parse error: %w", err)
+	}
+
+	var textBody bytes.Buffer
+	if err := textTmpl.Execute(&textBody, data); err != nil {
+		return "", "", fmt.Errorf("template execution error: %w", err)
+	}
+
+	return htmlBody.String(), textBody.String(), nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"log"
+	"sync"
+)
+
+// eventBufferSize is how many events a subscriber can fall behind before events are dropped
+const eventBufferSize = 16
+
+// Event is a message published on an EventBus
+type Event struct {
+	Type string
+	Data any
+}
+
+// EventBus passes events between goroutines of this process. Publishing never blocks: events
+// for a subscriber that is not keeping up are dropped.
+type EventBus struct {
+	lock        sync.RWMutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[string]map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving the events published on topic and a function that
+// ends the subscription and closes the channel
+func (b *EventBus) Subscribe(topic string) (<-chan Event, func()) {
+	events := make(chan Event, eventBufferSize)
+
+	b.lock.Lock()
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = make(map[chan Event]struct{})
+	}
+	b.subscribers[topic][events] = struct{}{}
+	b.lock.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.lock.Lock()
+			defer b.lock.Unlock()
+			delete(b.subscribers[topic], events)
+			if len(b.subscribers[topic]) == 0 {
+				delete(b.subscribers, topic)
+			}
+			close(events)
+		})
+	}
+	return events, unsubscribe
+}
+
+// Publish sends an event to everyone subscribed to topic
+func (b *EventBus) Publish(topic string, event Event) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for subscriber := range b.subscribers[topic] {
+		select {
+		case subscriber <- event:
+		default:
+			log.Printf("Dropping %s event on %s for a slow subscriber", event.Type, topic)
+		}
+	}
+}
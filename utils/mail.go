@@ -1,11 +1,10 @@
 package utils
 
 import (
-	"bytes"
-	"fmt"
-	"html/template"
-	"net/smtp"
+	"log"
 	"os"
+	"strings"
+	"sync"
 )
 
 type EmailData struct {
@@ -15,37 +14,92 @@ type EmailData struct {
 	LogoURL         string
 }
 
-func SendEmail(emailTo string, emailSubject string, data EmailData, templatePath string) error {
+// EmailAttachment is a file sent along with an email
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
 
-	tmpl, err := template.ParseFiles(templatePath)
-	if err != nil {
-		return fmt.Errorf("template parse error: %w", err)
-	}
+// EmailMessage is a single email to one recipient. TextBody and Attachments are optional.
+type EmailMessage struct {
+	To          string
+	Subject     string
+	HTMLBody    string
+	TextBody    string
+	ReplyTo     string
+	Headers     map[string]string // extra headers such as List-Unsubscribe
+	Attachments []EmailAttachment
+}
 
-	var body bytes.Buffer
-	err = tmpl.Execute(&body, data)
-	if err != nil {
-		return fmt.Errorf("template execution error: %w", err)
+// Mailer delivers email messages
+type Mailer interface {
+	Send(message EmailMessage) error
+}
+
+var (
+	mailer     Mailer
+	mailerOnce sync.Once
+	mailerLock sync.RWMutex
+)
+
+// NewMailerFromEnv picks the mail transport from MAIL_TRANSPORT: "smtp" (the default),
+// "file" to write messages to MAIL_CAPTURE_DIR, or "memory" to keep them in memory.
+func NewMailerFromEnv() Mailer {
+	switch strings.ToLower(os.Getenv("MAIL_TRANSPORT")) {
+	case "", "smtp":
+		return NewSMTPMailerFromEnv()
+	case "file":
+		dir := os.Getenv("MAIL_CAPTURE_DIR")
+		if dir == "" {
+			dir = "tmp/mail"
+		}
+		return NewCaptureMailer(dir)
+	case "memory":
+		return NewCaptureMailer("")
+	default:
+		log.Println("Unknown MAIL_TRANSPORT, using smtp:", os.Getenv("MAIL_TRANSPORT"))
+		return NewSMTPMailerFromEnv()
 	}
+}
+
+// DefaultMailer returns the mailer used to send email, set up from the environment on first use
+func DefaultMailer() Mailer {
+	mailerOnce.Do(func() {
+		mailerLock.Lock()
+		defer mailerLock.Unlock()
+		if mailer == nil {
+			mailer = NewMailerFromEnv()
+		}
+	})
+
+	mailerLock.RLock()
+	defer mailerLock.RUnlock()
+	return mailer
+}
 
-	message := fmt.Sprintf(
-		"From: %s\r\nSubject: %s\r\nMIME-version: 1.0;\r\nContent-Type: text/html; charset=\"UTF-8\";\r\n\r\n%s",
-		os.Getenv("FROM_EMAIL"),
-		emailSubject,
-		body.String(),
-	)
-
-	auth := smtp.PlainAuth(
-		"",
-		os.Getenv("FROM_EMAIL"),
-		os.Getenv("FROM_EMAIL_PASSWORD"),
-		os.Getenv("FROM_EMAIL_SMTP"),
-	)
-
-	err = smtp.SendMail(os.Getenv("SMTP_ADDRESS"), auth, os.Getenv("FROM_EMAIL"), []string{emailTo}, []byte(message))
+// SetMailer replaces the mailer, e.g. with a CaptureMailer in tests
+func SetMailer(m Mailer) {
+	mailerLock.Lock()
+	defer mailerLock.Unlock()
+	mailer = m
+}
+
+func SendEmail(emailTo string, emailSubject string, data EmailData, templatePath string) error {
+	htmlBody, _, err := RenderEmail(data, templatePath, "")
 	if err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
+		return err
 	}
+	return SendMessage(emailTo, emailSubject, htmlBody, "")
+}
 
-	return nil
+// SendMessage sends an email with an HTML body, an optional plain text alternative and attachments
+func SendMessage(emailTo, emailSubject, htmlBody, textBody string, attachments ...EmailAttachment) error {
+	return DefaultMailer().Send(EmailMessage{
+		To:          emailTo,
+		Subject:     emailSubject,
+		HTMLBody:    htmlBody,
+		TextBody:    textBody,
+		Attachments: attachments,
+	})
 }
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// mimePart is a body part with its headers
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func quotedPrintablePart(contentType, body string) (mimePart, error) {
+	var encoded bytes.Buffer
+	writer := quotedprintable.NewWriter(&encoded)
+	if _, err := writer.Write([]byte(body)); err != nil {
+		return mimePart{}, err
+	}
+	if err := writer.Close(); err != nil {
+		return mimePart{}, err
+	}
+
+	return mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		body: encoded.Bytes(),
+	}, nil
+}
+
+func attachmentPart(attachment EmailAttachment) mimePart {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Base64 lines must not be longer than 76 characters
+	var encoded bytes.Buffer
+	content := base64.StdEncoding.EncodeToString(attachment.Content)
+	for len(content) > 76 {
+		encoded.WriteString(content[:76] + "\r\n")
+		content = content[76:]
+	}
+	encoded.WriteString(content + "\r\n")
+
+	return mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": attachment.Filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		},
+		body: encoded.Bytes(),
+	}
+}
+
+// multipartOf joins parts into a single multipart part of the given subtype
+func multipartOf(subtype string, parts ...mimePart) (mimePart, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		partWriter, err := writer.CreatePart(part.header)
+		if err != nil {
+			return mimePart{}, err
+		}
+		if _, err := partWriter.Write(part.body); err != nil {
+			return mimePart{}, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return mimePart{}, err
+	}
+
+	return mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type": {mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": writer.Boundary()})},
+		},
+		body: body.Bytes(),
+	}, nil
+}
+
+// newMessageID makes a unique Message-ID in the domain of the sender
+func newMessageID(from string) string {
+	domain := "localhost"
+	if address, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(address.Address, "@"); at >= 0 {
+			domain = address.Address[at+1:]
+		}
+	}
+
+	random := make([]byte, 16)
+	rand.Read(random)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain)
+}
+
+// formatAddress writes an address with an optional display name, encoding non-ASCII names
+func formatAddress(name, address string) string {
+	if name == "" {
+		return address
+	}
+	return (&mail.Address{Name: name, Address: address}).String()
+}
+
+// BuildMessage renders a message as MIME. The HTML and plain text bodies are sent as
+// alternatives and followed by any attachments.
+func BuildMessage(from string, message EmailMessage) ([]byte, error) {
+	body, err := quotedPrintablePart(`text/html; charset="UTF-8"`, message.HTMLBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.TextBody != "" {
+		text, err := quotedPrintablePart(`text/plain; charset="UTF-8"`, message.TextBody)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = multipartOf("alternative", text, body); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(message.Attachments) > 0 {
+		parts := []mimePart{body}
+		for _, attachment := range message.Attachments {
+			parts = append(parts, attachmentPart(attachment))
+		}
+		if body, err = multipartOf("mixed", parts...); err != nil {
+			return nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	writeHeader := func(name, value string) {
+		fmt.Fprintf(&out, "%s: %s\r\n", name, value)
+	}
+
+	writeHeader("From", from)
+	writeHeader("To", message.To)
+	if message.ReplyTo != "" {
+		writeHeader("Reply-To", message.ReplyTo)
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("UTF-8", message.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-ID", newMessageID(from))
+	for name, value := range message.Headers {
+		writeHeader(name, value)
+	}
+	writeHeader("MIME-Version", "1.0")
+	for name, values := range body.header {
+		for _, value := range values {
+			writeHeader(name, value)
+		}
+	}
+	out.WriteString("\r\n")
+	out.Write(body.body)
+
+	return out.Bytes(), nil
+}
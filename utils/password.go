@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2id parameters used for every newly hashed password.
+// Stored hashes carry their own parameters, so these can be raised later
+// and existing users will be rehashed on their next successful login.
+const (
+	argon2Time    uint32 = 3
+	argon2Memory  uint32 = 64 * 1024
+	argon2Threads uint8  = 2
+	argon2KeyLen  uint32 = 32
+	argon2SaltLen        = 16
+)
+
+var (
+	ErrPasswordMismatch = errors.New("password does not match")
+	ErrInvalidHash      = errors.New("invalid password hash format")
+)
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	keyLen  uint32
+}
+
+// HashPassword hashes a password with Argon2id and returns it in the
+// PHC string format: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		argon2Memory,
+		argon2Time,
+		argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword checks a password against a stored Argon2id or legacy bcrypt hash.
+// needsRehash is true when the password matched but the stored hash should be
+// replaced with a fresh one using the current algorithm and parameters.
+func VerifyPassword(encodedHash, password string) (needsRehash bool, err error) {
+	if isBcryptHash(encodedHash) {
+		if err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, ErrPasswordMismatch
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	params, salt, key, err := decodeArgon2Hash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, params.keyLen)
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, ErrPasswordMismatch
+	}
+
+	needsRehash = params.time != argon2Time ||
+		params.memory != argon2Memory ||
+		params.threads != argon2Threads ||
+		params.keyLen != argon2KeyLen
+	return needsRehash, nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func decodeArgon2Hash(encodedHash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	params.keyLen = uint32(len(key))
+
+	return params, salt, key, nil
+}
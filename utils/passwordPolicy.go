@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password must be at least 8 characters long")
+	ErrPasswordTooLong  = errors.New("password must be at most 128 characters long")
+	ErrPasswordCommon   = errors.New("password is too common, choose a less predictable password")
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+func loadCommonPasswords(contents string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}
+
+// ValidatePassword enforces the password policy used at signup and password reset.
+func ValidatePassword(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if length > MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
+	if _, found := commonPasswords[strings.ToLower(password)]; found {
+		return ErrPasswordCommon
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PDF page size in points (A4)
+const (
+	PDFPageWidth  = 595.28
+	PDFPageHeight = 841.89
+)
+
+// Widths of the printable ASCII characters, from space to tilde, in the standard
+// Helvetica fonts in thousandths of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// PDFDocument builds a simple PDF of text, lines and filled boxes using the built in
+// Helvetica fonts. Coordinates are in points from the top left corner of the page.
+type PDFDocument struct {
+	pages []*bytes.Buffer
+}
+
+func NewPDFDocument() *PDFDocument {
+	document := &PDFDocument{}
+	document.AddPage()
+	return document
+}
+
+// AddPage starts a new page, which becomes the page that is drawn on
+func (d *PDFDocument) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *PDFDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// pdfText converts text to the single byte encoding of the fonts and escapes it
+func pdfText(text string) string {
+	var escaped strings.Builder
+	for _, char := range text {
+		switch {
+		case char == '(' || char == ')' || char == '\\':
+			escaped.WriteByte('\\')
+			escaped.WriteRune(char)
+		case char < 32:
+			escaped.WriteByte(' ')
+		case char < 127:
+			escaped.WriteRune(char)
+		case char < 256:
+			fmt.Fprintf(&escaped, "\\%03o", char)
+		default:
+			escaped.WriteByte('?')
+		}
+	}
+	return escaped.String()
+}
+
+// TextWidth returns the width of text in points at the given font size
+func TextWidth(text string, size float64, bold bool) float64 {
+	widths := helveticaWidths
+	if bold {
+		widths = helveticaBoldWidths
+	}
+
+	total := 0
+	for _, char := range text {
+		if char >= 32 && char <= 126 {
+			total += widths[char-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// Text writes text with its baseline at y
+func (d *PDFDocument) Text(x, y, size float64, bold bool, text string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, PDFPageHeight-y, pdfText(text))
+}
+
+// TextRight writes text so that it ends at x
+func (d *PDFDocument) TextRight(x, y, size float64, bold bool, text string) {
+	d.Text(x-TextWidth(text, size, bold), y, size, bold, text)
+}
+
+// Line draws a line in the given grey level, from 0 for black to 1 for white
+func (d *PDFDocument) Line(x1, y1, x2, y2, width, grey float64) {
+	fmt.Fprintf(d.page(), "%.2f G %.2f w %.2f %.2f m %.2f %.2f l S\n", grey, width, x1, PDFPageHeight-y1, x2, PDFPageHeight-y2)
+}
+
+// FillRect draws a box filled in the given grey level, with its top left corner at x, y
+func (d *PDFDocument) FillRect(x, y, width, height, grey float64) {
+	fmt.Fprintf(d.page(), "%.2f g %.2f %.2f %.2f %.2f re f 0 g\n", grey, x, PDFPageHeight-y-height, width, height)
+}
+
+// Bytes returns the finished document
+func (d *PDFDocument) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+
+	writeObject := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// Objects 1 to 4 are the catalog, page tree and fonts, followed by each page and its content
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+i*2))
+	}
+	writeObject("<< /Type /Catalog /Pages 2 0 R >>")
+	writeObject(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	writeObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	writeObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, content := range d.pages {
+		writeObject(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PDFPageWidth, PDFPageHeight, 6+i*2,
+		))
+		writeObject(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
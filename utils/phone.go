@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidPhoneNumber = errors.New("invalid Kenyan phone number")
+
+// NormalizeKenyanPhone converts a Kenyan mobile number written in any of the usual ways,
+// e.g. "0712 345 678", "712345678", "254712345678" or "+254-712-345678", to E.164 (+254712345678)
+func NormalizeKenyanPhone(phone string) (string, error) {
+	var digits strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", ErrInvalidPhoneNumber
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case strings.HasPrefix(number, "00254"):
+		number = number[5:]
+	case strings.HasPrefix(number, "254"):
+		number = number[3:]
+	case strings.HasPrefix(number, "0"):
+		number = number[1:]
+	}
+
+	// Mobile numbers are 9 digits after the country code and start with 7 or 1
+	if len(number) != 9 || (number[0] != '7' && number[0] != '1') {
+		return "", ErrInvalidPhoneNumber
+	}
+	return "+254" + number, nil
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Slugify turns a name into a lowercase URL segment, e.g. "Phones & Tablets" becomes
+// "phones-tablets". Letters other than a-z and digits are dropped.
+func Slugify(name string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		case unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r):
+			dash = true
+		}
+	}
+	return slug.String()
+}
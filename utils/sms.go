@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SMSMessage is a text message to one phone number in E.164 format
+type SMSMessage struct {
+	To   string
+	Body string
+}
+
+// SMSSender delivers text messages. Send returns the provider's id for the message.
+type SMSSender interface {
+	Send(message SMSMessage) (string, error)
+}
+
+var (
+	smsSender     SMSSender
+	smsSenderOnce sync.Once
+	smsSenderLock sync.RWMutex
+)
+
+// NewSMSSenderFromEnv picks the SMS transport from SMS_TRANSPORT: "africastalking", "memory" to
+// keep messages in memory, or "none". When unset, Africa's Talking is used if AT_API_KEY is
+// set and SMS is disabled otherwise. It returns nil when SMS is disabled.
+func NewSMSSenderFromEnv() SMSSender {
+	switch strings.ToLower(os.Getenv("SMS_TRANSPORT")) {
+	case "":
+		if os.Getenv("AT_API_KEY") == "" {
+			return nil
+		}
+		return NewAfricasTalkingSenderFromEnv()
+	case "africastalking":
+		return NewAfricasTalkingSenderFromEnv()
+	case "memory":
+		return NewCaptureSMSSender()
+	case "none":
+		return nil
+	default:
+		log.Println("Unknown SMS_TRANSPORT, SMS is disabled:", os.Getenv("SMS_TRANSPORT"))
+		return nil
+	}
+}
+
+// DefaultSMSSender returns the sender used for text messages, or nil when SMS is disabled
+func DefaultSMSSender() SMSSender {
+	smsSenderOnce.Do(func() {
+		smsSenderLock.Lock()
+		defer smsSenderLock.Unlock()
+		if smsSender == nil {
+			smsSender = NewSMSSenderFromEnv()
+		}
+	})
+
+	smsSenderLock.RLock()
+	defer smsSenderLock.RUnlock()
+	return smsSender
+}
+
+// SetSMSSender replaces the SMS sender, e.g. with a CaptureSMSSender in tests
+func SetSMSSender(sender SMSSender) {
+	smsSenderLock.Lock()
+	defer smsSenderLock.Unlock()
+	smsSender = sender
+}
+
+// SMSEnabled reports whether text messages are sent at all
+func SMSEnabled() bool {
+	return DefaultSMSSender() != nil
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMTP connection security modes
+const (
+	SMTPSecurityStartTLS = "starttls"
+	SMTPSecurityTLS      = "tls"
+	SMTPSecurityNone     = "none"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// SMTPMailer sends email through an SMTP server
+type SMTPMailer struct {
+	Address  string // host:port of the server
+	Host     string // server name for TLS and authentication, defaults to the host in Address
+	Username string
+	Password string
+	From     string // sender address
+	FromName string
+	ReplyTo  string
+	// Security is "starttls" to upgrade a plain connection, "tls" for implicit TLS
+	// (usually port 465) or "none"
+	Security string
+}
+
+// NewSMTPMailerFromEnv configures an SMTPMailer from SMTP_ADDRESS, FROM_EMAIL_SMTP, FROM_EMAIL and
+// FROM_EMAIL_PASSWORD, with optional SMTP_SECURITY, MAIL_FROM_NAME and MAIL_REPLY_TO
+func NewSMTPMailerFromEnv() *SMTPMailer {
+	mailer := &SMTPMailer{
+		Address:  os.Getenv("SMTP_ADDRESS"),
+		Host:     os.Getenv("FROM_EMAIL_SMTP"),
+		Username: os.Getenv("FROM_EMAIL"),
+		Password: os.Getenv("FROM_EMAIL_PASSWORD"),
+		From:     os.Getenv("FROM_EMAIL"),
+		FromName: os.Getenv("MAIL_FROM_NAME"),
+		ReplyTo:  os.Getenv("MAIL_REPLY_TO"),
+		Security: strings.ToLower(os.Getenv("SMTP_SECURITY")),
+	}
+	if mailer.Security == "" {
+		mailer.Security = SMTPSecurityStartTLS
+		if strings.HasSuffix(mailer.Address, ":465") {
+			mailer.Security = SMTPSecurityTLS
+		}
+	}
+	return mailer
+}
+
+func (m *SMTPMailer) dial(host string) (*smtp.Client, error) {
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	if m.Security == SMTPSecurityTLS {
+		conn, err := tls.DialWithDialer(dialer, "tcp", m.Address, &tls.Config{ServerName: host})
+		if err != nil {
+			return nil, err
+		}
+		return smtp.NewClient(conn, host)
+	}
+
+	conn, err := dialer.Dial("tcp", m.Address)
+	if err != nil {
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if m.Security == SMTPSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("smtp server %s does not support STARTTLS", m.Address)
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (m *SMTPMailer) Send(message EmailMessage) error {
+	host, _, err := net.SplitHostPort(m.Address)
+	if err != nil {
+		return fmt.Errorf("invalid smtp address %q: %w", m.Address, err)
+	}
+	if m.Host != "" {
+		host = m.Host
+	}
+
+	if message.ReplyTo == "" {
+		message.ReplyTo = m.ReplyTo
+	}
+	data, err := BuildMessage(formatAddress(m.FromName, m.From), message)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+
+	client, err := m.dial(host)
+	if err != nil {
+		return fmt.Errorf("failed to connect to smtp server: %w", err)
+	}
+	defer client.Close()
+
+	if m.Username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+				return fmt.Errorf("smtp authentication failed: %w", err)
+			}
+		}
+	}
+
+	if err := client.Mail(m.From); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// WebhookSignatureHeader carries the signature of a webhook payload, "t=<unix time>,v1=<hex HMAC>"
+const WebhookSignatureHeader = "X-Amexan-Signature"
+
+// SignWebhookPayload signs a webhook body with the endpoint's secret. The HMAC-SHA256 covers
+// the timestamp and the body joined by a dot, so receivers can reject replayed requests.
+func SignWebhookPayload(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
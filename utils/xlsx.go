@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cell styles defined in xlsxStyles
+const (
+	xlsxStyleDefault = 0
+	xlsxStyleDate    = 1
+	xlsxStyleAmount  = 2
+)
+
+// excelEpoch is day zero of Excel's 1900 date system, which counts 1900 as a leap year
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+// xlsxStyles defines the default style, a date and time style and a two decimal amount style
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+</cellXfs>
+</styleSheet>`
+
+// XLSXWriter streams a single sheet spreadsheet, so rows are written out as they are
+// added instead of being kept in memory. Strings, integers, floats, bools and times are
+// supported, floats are shown with two decimals.
+type XLSXWriter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	rows    int
+}
+
+// NewXLSXWriter starts a workbook with one sheet called sheetName
+func NewXLSXWriter(w io.Writer, sheetName string) (*XLSXWriter, error) {
+	archive := zip.NewWriter(w)
+
+	workbook := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="` + xmlEscape(sheetName) + `" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", workbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(file, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	// The sheet is the last part, so it can stay open while rows are added
+	file, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheet := bufio.NewWriter(file)
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	return &XLSXWriter{archive: archive, sheet: sheet}, nil
+}
+
+// WriteHeader adds a row of bold column titles
+func (x *XLSXWriter) WriteHeader(titles []string) error {
+	x.rows++
+	x.sheet.WriteString(`<row r="` + strconv.Itoa(x.rows) + `">`)
+	for _, title := range titles {
+		x.sheet.WriteString(`<c t="inlineStr" s="3"><is><t>` + xmlEscape(title) + `</t></is></c>`)
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+// WriteRow adds a row of values. nil leaves a cell empty.
+func (x *XLSXWriter) WriteRow(values []any) error {
+	x.rows++
+	x.sheet.WriteString(`<row r="` + strconv.Itoa(x.rows) + `">`)
+	for _, value := range values {
+		x.writeCell(value)
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *XLSXWriter) writeCell(value any) {
+	switch v := value.(type) {
+	case nil:
+		x.sheet.WriteString(`<c/>`)
+	case string:
+		x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">` + xmlEscape(v) + `</t></is></c>`)
+	case int:
+		x.writeNumber(strconv.Itoa(v), xlsxStyleDefault)
+	case int64:
+		x.writeNumber(strconv.FormatInt(v, 10), xlsxStyleDefault)
+	case uint:
+		x.writeNumber(strconv.FormatUint(uint64(v), 10), xlsxStyleDefault)
+	case float64:
+		x.writeNumber(strconv.FormatFloat(v, 'f', -1, 64), xlsxStyleAmount)
+	case bool:
+		boolean := "0"
+		if v {
+			boolean = "1"
+		}
+		x.sheet.WriteString(`<c t="b"><v>` + boolean + `</v></c>`)
+	case time.Time:
+		if v.IsZero() {
+			x.sheet.WriteString(`<c/>`)
+			return
+		}
+		// Excel has no time zones, the time is shown as it reads in its own location
+		wall := time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), time.UTC)
+		days := wall.Sub(excelEpoch).Hours() / 24
+		x.writeNumber(strconv.FormatFloat(days, 'f', 6, 64), xlsxStyleDate)
+	default:
+		x.writeCell(fmt.Sprint(v))
+	}
+}
+
+func (x *XLSXWriter) writeNumber(number string, style int) {
+	if style == xlsxStyleDefault {
+		x.sheet.WriteString(`<c><v>` + number + `</v></c>`)
+		return
+	}
+	x.sheet.WriteString(`<c s="` + strconv.Itoa(style) + `"><v>` + number + `</v></c>`)
+}
+
+// Flush writes buffered rows to the underlying writer
+func (x *XLSXWriter) Flush() error {
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.archive.Flush()
+}
+
+// Close finishes the sheet and the workbook
+func (x *XLSXWriter) Close() error {
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.archive.Close()
+}
+
+func xmlEscape(value string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}